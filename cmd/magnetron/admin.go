@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"magnetron/pkg/client"
+	"strconv"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var persistFlag = &cli.BoolFlag{Name: "persist", Usage: "write the change back to the configuration file"}
+
+func passIDArg(cCtx *cli.Context) uint32 {
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected the pass ID of a registered server. e.g. 123456789")
+	}
+
+	passID, err := strconv.ParseUint(cCtx.Args().First(), 10, 32)
+	if err != nil {
+		log.Fatal("Invalid pass ID: ", cCtx.Args().First())
+	}
+
+	return uint32(passID)
+}
+
+func listRegisteredServers(cCtx *cli.Context) error {
+
+	servers, err := restClient(cCtx).RegisteredServers(cCtx.Context, client.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, server := range servers.Servers {
+		flags := ""
+		if server.Pinned {
+			flags += " [pinned]"
+		}
+		if server.Overridden {
+			flags += " [overridden]"
+		}
+		if server.Verified {
+			flags += " [verified]"
+		}
+		if server.PasswordEntry != "" {
+			flags += " [password: " + server.PasswordEntry + "]"
+		}
+
+		fmt.Printf("%d\t%s:%d\t%q\tusers: %d\tlast seen: %s%s\n", server.PassID, server.Host, server.Port, server.Name,
+			server.UserCount, server.LastSeen.Format("2006-01-02 15:04:05"), flags)
+	}
+
+	return nil
+}
+
+func expireRegisteredServer(cCtx *cli.Context) error {
+
+	passID := passIDArg(cCtx)
+
+	if err := restClient(cCtx).ExpireRegisteredServer(cCtx.Context, passID); err != nil {
+		return err
+	}
+
+	fmt.Println("Expired server", passID)
+	return nil
+}
+
+func overrideRegisteredServer(cCtx *cli.Context) error {
+
+	passID := passIDArg(cCtx)
+
+	// Only the flags given change the override, so that pinning a server keeps the name it is listed under.
+	var patch client.OverridePatch
+	if cCtx.IsSet("name") {
+		name := cCtx.String("name")
+		patch.Name = &name
+	}
+	if cCtx.IsSet("description") {
+		description := cCtx.String("description")
+		patch.Description = &description
+	}
+	if cCtx.IsSet("pin") {
+		pinned := cCtx.Bool("pin")
+		patch.Pinned = &pinned
+	}
+
+	if patch == (client.OverridePatch{}) {
+		return fmt.Errorf("expected --name, --description or --pin")
+	}
+
+	override, err := restClient(cCtx).PatchOverride(cCtx.Context, passID, patch, cCtx.Bool("persist"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Set override for server %d: name %q, description %q, pinned %t\n", override.PassID, override.Name, override.Description, override.Pinned)
+	return nil
+}
+
+func clearRegisteredServerOverride(cCtx *cli.Context) error {
+
+	passID := passIDArg(cCtx)
+
+	if err := restClient(cCtx).RemoveOverride(cCtx.Context, passID, cCtx.Bool("persist")); err != nil {
+		return err
+	}
+
+	fmt.Println("Cleared override for server", passID)
+	return nil
+}
+
+func listBans(cCtx *cli.Context) error {
+
+	bans, err := restClient(cCtx).Bans(cCtx.Context)
+	if err != nil {
+		return err
+	}
+
+	for _, ban := range bans.Bans {
+		fmt.Printf("%d\t%s\t%q\n", ban.ID, describeBan(ban), ban.Reason)
+	}
+
+	return nil
+}
+
+func addBan(cCtx *cli.Context) error {
+
+	ban := client.Ban{
+		PassID:  uint32(cCtx.Uint("pass-id")),
+		Address: cCtx.String("address"),
+		IP:      cCtx.String("ip"),
+		Reason:  cCtx.String("reason"),
+	}
+
+	ban, err := restClient(cCtx).CreateBan(cCtx.Context, ban, cCtx.Bool("persist"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added ban %d on %s\n", ban.ID, describeBan(ban))
+	return nil
+}
+
+func removeBan(cCtx *cli.Context) error {
+
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected a ban ID. e.g. 3")
+	}
+
+	id, err := strconv.ParseUint(cCtx.Args().First(), 10, 0)
+	if err != nil {
+		log.Fatal("Invalid ban ID: ", cCtx.Args().First())
+	}
+
+	if err := restClient(cCtx).RemoveBan(cCtx.Context, uint(id), cCtx.Bool("persist")); err != nil {
+		return err
+	}
+
+	fmt.Println("Removed ban", id)
+	return nil
+}
+
+func describeBan(ban client.Ban) string {
+	switch {
+	case ban.PassID != 0:
+		return fmt.Sprintf("pass ID %d", ban.PassID)
+	case ban.Address != "":
+		return "address " + ban.Address
+	default:
+		return "IP " + ban.IP
+	}
+}
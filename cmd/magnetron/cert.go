@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"magnetron/internal/certs"
+	"os"
+	"strings"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var certCommand = &cli.Command{
+	Name:  "cert",
+	Usage: "options for TLS certificates of the REST API and the TLS client listener",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "generate",
+			Usage: "generates a self-signed certificate and its key",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "cert", Usage: "certificate file, as set in CertFile", Value: "cert.pem"},
+				&cli.StringFlag{Name: "key", Usage: "key file, as set in KeyFile", Value: "key.pem"},
+				&cli.StringSliceFlag{Name: "host", Usage: "host name or address the certificate is valid for, may be repeated; this machine's name and the loopback names when omitted"},
+				&cli.IntFlag{Name: "days", Usage: "days the certificate is valid for", Value: int(certs.DefaultValidity / (24 * time.Hour))},
+				&cli.BoolFlag{Name: "force", Usage: "replace existing files; a running tracker reloads them"},
+			},
+			Action: generateCertificate,
+		},
+		{
+			Name:      "inspect",
+			Usage:     "shows the certificates in a PEM file",
+			ArgsUsage: "file",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "key", Usage: "also check that this key file belongs to the certificate"},
+			},
+			Action: inspectCertificate,
+		},
+	},
+}
+
+func generateCertificate(cCtx *cli.Context) error {
+
+	certFile, keyFile := cCtx.String("cert"), cCtx.String("key")
+
+	if !cCtx.Bool("force") {
+		for _, file := range []string{certFile, keyFile} {
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("%s already exists, use --force to replace it", file)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	if cCtx.Int("days") <= 0 {
+		return fmt.Errorf("--days must be positive")
+	}
+
+	hosts := cCtx.StringSlice("host")
+	if len(hosts) == 0 {
+		hosts = certs.DefaultHosts("")
+	}
+
+	validFor := time.Duration(cCtx.Int("days")) * 24 * time.Hour
+	if err := certs.WriteSelfSigned(certFile, keyFile, hosts, validFor); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated self-signed certificate for %s at %s, key at %s\n", strings.Join(hosts, ", "), certFile, keyFile)
+	return nil
+}
+
+func inspectCertificate(cCtx *cli.Context) error {
+
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected certificate file path. e.g. cert.pem")
+	}
+
+	path := cCtx.Args().First()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var found int
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("could not parse certificate %d: %s", found+1, err)
+		}
+		found++
+
+		var names []string
+		names = append(names, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			names = append(names, ip.String())
+		}
+
+		status := fmt.Sprintf("valid for %d more days", int(cert.NotAfter.Sub(now).Hours()/24))
+		if now.After(cert.NotAfter) {
+			status = "EXPIRED"
+		} else if now.Before(cert.NotBefore) {
+			status = "not valid yet"
+		}
+
+		fingerprint := sha256.Sum256(cert.Raw)
+
+		if found > 1 {
+			fmt.Println()
+		}
+		fmt.Printf("Subject:      %s\n", cert.Subject)
+		fmt.Printf("Issuer:       %s\n", cert.Issuer)
+		fmt.Printf("Self-signed:  %t\n", cert.Subject.String() == cert.Issuer.String() && cert.CheckSignatureFrom(cert) == nil)
+		fmt.Printf("Names:        %s\n", strings.Join(names, ", "))
+		fmt.Printf("Valid from:   %s\n", cert.NotBefore.Format(time.DateTime))
+		fmt.Printf("Valid until:  %s (%s)\n", cert.NotAfter.Format(time.DateTime), status)
+		fmt.Printf("Key:          %s\n", cert.PublicKeyAlgorithm)
+		fmt.Printf("SHA-256:      %s\n", strings.ToUpper(hex.EncodeToString(fingerprint[:])))
+	}
+
+	if found == 0 {
+		return fmt.Errorf("no certificates found in %s", path)
+	}
+
+	if keyFile := cCtx.String("key"); keyFile != "" {
+		if _, err := tls.LoadX509KeyPair(path, keyFile); err != nil {
+			return fmt.Errorf("key does not belong to the certificate: %s", err)
+		}
+		fmt.Println("\nKey matches the certificate.")
+	}
+
+	return nil
+}
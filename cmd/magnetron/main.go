@@ -2,13 +2,23 @@ package main
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"magnetron/internal/api"
 	"magnetron/internal/config"
+	"magnetron/internal/history"
+	"magnetron/internal/logging"
+	"magnetron/internal/metrics"
+	"magnetron/internal/peer"
 	"magnetron/internal/registry"
+	"magnetron/internal/webhook"
+	"magnetron/pkg/client"
+	"net"
 	"os"
 	"runtime/debug"
+	"strconv"
 
 	"gopkg.in/yaml.v3"
 
@@ -62,30 +72,99 @@ func main() {
 					},
 				},
 			},
+			passwordCommand,
+			tokenCommand,
+			certCommand,
 			{
-				Name:    "password",
-				Aliases: []string{"p"},
-				Usage:   "options for password management",
+				Name:    "tracker",
+				Aliases: []string{"t"},
+				Usage:   "options for federated tracker management on a running tracker",
 				Subcommands: []*cli.Command{
 					{
-						Name:   "init",
-						Usage:  "initializes a default password configuration",
-						Action: initPasswordConfig,
+						Name:   "candidates",
+						Usage:  "lists trackers found by discovery",
+						Flags:  restClientFlags,
+						Action: listCandidateTrackers,
 					},
 					{
-						Name:   "validate",
-						Usage:  "validates a password configuration file",
-						Action: validatePasswordConfig,
+						Name:      "promote",
+						Usage:     "promotes a candidate tracker into the federation",
+						ArgsUsage: "host:port",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{Name: "name", Usage: "name shown for the tracker, defaults to its listed name"},
+							&cli.StringFlag{Name: "description", Usage: "description shown for the tracker"},
+							&cli.BoolFlag{Name: "persist", Usage: "write the tracker back to the configuration file"},
+						}, restClientFlags...),
+						Action: promoteCandidateTracker,
+					},
+				},
+			},
+			{
+				Name:  "server",
+				Usage: "options for registered server moderation on a running tracker",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "lists registered servers as they are listed to clients",
+						Flags:  restClientFlags,
+						Action: listRegisteredServers,
+					},
+					{
+						Name:      "expire",
+						Usage:     "removes a registered server from the listing until it registers again",
+						ArgsUsage: "passId",
+						Flags:     restClientFlags,
+						Action:    expireRegisteredServer,
 					},
 					{
-						Name:   "encrypt",
-						Usage:  "encrypts a password",
-						Action: encryptPassword,
+						Name:      "override",
+						Usage:     "overrides how a registered server is listed",
+						ArgsUsage: "passId",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{Name: "name", Usage: "name to list the server under, empty for its own"},
+							&cli.StringFlag{Name: "description", Usage: "description to list the server with, empty for its own"},
+							&cli.BoolFlag{Name: "pin", Usage: "list the server before the other registered servers, --pin=false to unpin"},
+							persistFlag,
+						}, restClientFlags...),
+						Action: overrideRegisteredServer,
 					},
 					{
-						Name:   "check",
-						Usage:  "checks a password against the supplied password file",
-						Action: checkPassword,
+						Name:      "clear-override",
+						Usage:     "removes the override of a registered server",
+						ArgsUsage: "passId",
+						Flags:     append([]cli.Flag{persistFlag}, restClientFlags...),
+						Action:    clearRegisteredServerOverride,
+					},
+				},
+			},
+			{
+				Name:  "ban",
+				Usage: "options for banning servers on a running tracker",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "lists bans",
+						Flags:  restClientFlags,
+						Action: listBans,
+					},
+					{
+						Name:  "add",
+						Usage: "bans servers by pass ID, address or IP and delists the ones registered",
+						Flags: append([]cli.Flag{
+							&cli.UintFlag{Name: "pass-id", Usage: "pass ID of the server to ban"},
+							&cli.StringFlag{Name: "address", Usage: "ip:port of the server to ban"},
+							&cli.StringFlag{Name: "ip", Usage: "IP address or CIDR range to ban"},
+							&cli.StringFlag{Name: "reason", Usage: "why the server is banned"},
+							persistFlag,
+						}, restClientFlags...),
+						Action: addBan,
+					},
+					{
+						Name:      "remove",
+						Usage:     "removes a ban",
+						ArgsUsage: "banId",
+						Flags:     append([]cli.Flag{persistFlag}, restClientFlags...),
+						Action:    removeBan,
 					},
 				},
 			},
@@ -167,8 +246,6 @@ func serve(cCtx *cli.Context) error {
 
 	fmt.Println(banner)
 
-	log.Printf("Magnetron Hotline Tracker %s, %s", magnetronVersion, Commit)
-
 	if cCtx.Args().Len() != 1 {
 		log.Printf("Args: %s", cCtx.Args())
 		log.Fatalf("Expected config file path. e.g. ~/config.yaml, %s", cCtx.Args().First())
@@ -177,9 +254,44 @@ func serve(cCtx *cli.Context) error {
 	configPath := cCtx.Args().First()
 	cfg := config.ReadConfigFile(configPath)
 
+	if err := logging.Setup(cfg.Logging); err != nil {
+		return err
+	}
+
+	slog.Info("Magnetron Hotline Tracker", "version", magnetronVersion, "commit", Commit)
+
 	var passwordCfg *config.PasswordConfig
 	if cfg.EnablePasswords {
 		passwordCfg = config.ReadPasswordConfigFile(cfg.PasswordFile)
+		passwordCfg.Validate()
+	} else if _, err := os.Stat(cfg.PasswordFile); cfg.PasswordFile != "" && err == nil {
+		// Signed registrations are verified with the signing secrets of the password file even while passwords
+		// are off.
+		if loaded, err := config.LoadPasswordConfigFile(cfg.PasswordFile); err != nil {
+			slog.Warn("Signatures are not verified", logging.Err(err))
+		} else if errs := loaded.Errors(); len(errs) > 0 {
+			slog.Warn("Signatures are not verified, the password file is invalid", logging.Err(errors.Join(errs...)))
+		} else {
+			passwordCfg = loaded
+		}
+	}
+
+	if cfg.Metrics.Enabled && cfg.Metrics.Host != "" {
+		go metrics.Serve(cfg.Metrics.Host)
+	}
+
+	if err := history.NewHistoryService(&cfg); err != nil {
+		return err
+	} else {
+
+		go func() { history.HistoryServiceInstance.Serve() }()
+	}
+
+	if err := webhook.NewWebhookService(&cfg); err != nil {
+		return err
+	} else {
+
+		go func() { webhook.WebhookServiceInstance.Serve() }()
 	}
 
 	if err := api.NewRestService(&cfg); err != nil {
@@ -189,6 +301,13 @@ func serve(cCtx *cli.Context) error {
 		go func() { api.RestServiceInstance.Serve() }()
 	}
 
+	if err := peer.NewPeerService(&cfg); err != nil {
+		return err
+	} else {
+
+		go func() { peer.PeerServiceInstance.Serve() }()
+	}
+
 	if err := registry.NewRegistry(&cfg, passwordCfg); err != nil {
 		return err
 	} else {
@@ -204,75 +323,56 @@ func version(cCtx *cli.Context) error {
 	return nil
 }
 
-func encryptPassword(cCtx *cli.Context) error {
-
-	password, err := registry.PromptUserForPassword()
+func listCandidateTrackers(cCtx *cli.Context) error {
 
+	candidates, err := restClient(cCtx).CandidateTrackers(cCtx.Context, client.ListOptions{})
 	if err != nil {
-		fmt.Println("Error while prompting for password:", err)
+		return err
 	}
 
-	hashedPassword, err := registry.EncryptPassword(password)
-
-	if err != nil {
-		fmt.Println("Error while encrypting password:", err)
+	if len(candidates.Trackers) == 0 {
+		fmt.Println("No candidate trackers have been discovered.")
+		return nil
 	}
 
-	fmt.Println("\n\n" + hashedPassword)
+	for _, candidate := range candidates.Trackers {
+		fmt.Printf("%s:%d\t%q\tservers: %d\tdepth: %d\tvia: %s\tlast seen: %s\n", candidate.Host, candidate.Port, candidate.ListedName,
+			candidate.ServerCount, candidate.Depth, candidate.DiscoveredVia, candidate.LastSeen.Format("2006-01-02 15:04:05"))
+	}
 
 	return nil
 }
 
-func checkPassword(cCtx *cli.Context) error {
+func promoteCandidateTracker(cCtx *cli.Context) error {
 
 	if cCtx.Args().Len() != 1 {
-		log.Fatal("Expected config file path. e.g. ~/passwords.yaml")
+		log.Fatal("Expected candidate tracker address. e.g. 192.0.2.10:5498")
 	}
 
-	passwordConfig := config.ReadPasswordConfigFile(cCtx.Args().First())
-
-	password, err := registry.PromptUserForPassword()
-
+	host, portString, err := net.SplitHostPort(cCtx.Args().First())
 	if err != nil {
-		fmt.Println("Error while prompting for password:", err)
+		return err
 	}
 
-	if registry.CheckPassword(password, *passwordConfig) {
-		fmt.Println("\nPassword is valid.")
-	} else {
-		fmt.Println("\nPassword is invalid.")
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func initPasswordConfig(cCtx *cli.Context) error {
-
-	if cCtx.Args().Len() != 1 {
-		log.Fatal("Expected config destination file path. e.g. ~/passwords.yaml")
+	promoteRequest := client.PromoteCandidateRequest{
+		Host:        host,
+		Port:        uint16(port),
+		Name:        cCtx.String("name"),
+		Description: cCtx.String("description"),
+		Persist:     cCtx.Bool("persist"),
 	}
 
-	configDest := cCtx.Args().First()
-	defaultConfig := config.GetDefaultPasswordConfig()
-	config.WritePasswordConfig(*defaultConfig, configDest)
-
-	fmt.Println("Generated default password configuration and saved at:", configDest)
-
-	return nil
-}
-
-func validatePasswordConfig(cCtx *cli.Context) error {
-
-	if cCtx.Args().Len() != 1 {
-		log.Fatal("Expected config file path. e.g. ~/passwords.yaml")
+	tracker, err := restClient(cCtx).PromoteCandidateTracker(cCtx.Context, promoteRequest)
+	if err != nil {
+		return err
 	}
 
-	configPath := cCtx.Args().First()
-
-	c := config.ReadPasswordConfigFile(configPath)
-
-	c.Validate()
+	fmt.Printf("Promoted %s:%d to federated tracker %q\n", tracker.Host, tracker.Port, tracker.Name)
 
-	log.Println("Validated password configuration.")
 	return nil
 }
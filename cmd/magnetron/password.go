@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"magnetron/internal/config"
+	"magnetron/internal/registry"
+	"os"
+	"strings"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var passwordFileFlag = &cli.StringFlag{
+	Name:    "file",
+	Aliases: []string{"f"},
+	Usage:   "password file, as set in PasswordFile",
+	Value:   "./passwords.yml",
+	EnvVars: []string{"MAGNETRON_PASSWORD_FILE"},
+}
+
+var passwordInputFlags = []cli.Flag{
+	&cli.BoolFlag{Name: "password-stdin", Usage: "read the password from the first line of standard input"},
+	&cli.StringFlag{Name: "password-env", Usage: "read the password from the named environment variable"},
+}
+
+var passwordAlgorithmFlag = &cli.StringFlag{
+	Name:  "algorithm",
+	Usage: "hash algorithm, " + registry.Argon2id + " or " + registry.Bcrypt,
+	Value: registry.Argon2id,
+}
+
+var passwordCommand = &cli.Command{
+	Name:    "password",
+	Aliases: []string{"p"},
+	Usage:   "options for password management",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "init",
+			Usage:  "initializes a default password configuration",
+			Action: initPasswordConfig,
+		},
+		{
+			Name:   "validate",
+			Usage:  "validates a password configuration file",
+			Action: validatePasswordConfig,
+		},
+		{
+			Name:   "encrypt",
+			Usage:  "encrypts a password",
+			Flags:  append([]cli.Flag{passwordAlgorithmFlag}, passwordInputFlags...),
+			Action: encryptPassword,
+		},
+		{
+			Name:   "check",
+			Usage:  "checks a password against the supplied password file",
+			Flags:  passwordInputFlags,
+			Action: checkPassword,
+		},
+		{
+			Name:      "add",
+			Usage:     "adds a password entry to the password file",
+			ArgsUsage: "name",
+			Flags: append([]cli.Flag{
+				passwordFileFlag,
+				passwordAlgorithmFlag,
+				&cli.StringFlag{Name: "description", Usage: "who the password is for"},
+				&cli.StringSliceFlag{Name: "allowed-name", Usage: "server name pattern the entry may register, may be repeated"},
+				&cli.StringSliceFlag{Name: "allowed-ip", Usage: "address or CIDR the entry may register from, may be repeated"},
+				&cli.StringSliceFlag{Name: "reserved-name", Usage: "server name pattern only this entry may register, may be repeated"},
+				&cli.IntFlag{Name: "max-servers", Usage: "servers registered with the entry at the same time, unlimited when 0"},
+				&cli.StringFlag{Name: "expires", Usage: "how long the entry is accepted, e.g. 720h or 90d; never expires when empty"},
+			}, passwordInputFlags...),
+			Action: addPassword,
+		},
+		{
+			Name:      "remove",
+			Usage:     "removes a password entry from the password file",
+			ArgsUsage: "name",
+			Flags:     []cli.Flag{passwordFileFlag},
+			Action:    removePassword,
+		},
+		{
+			Name:   "list",
+			Usage:  "lists password entries without their hashes",
+			Flags:  []cli.Flag{passwordFileFlag},
+			Action: listPasswords,
+		},
+		{
+			Name:      "rotate",
+			Usage:     "replaces the password of an entry, keeping its other settings",
+			ArgsUsage: "name",
+			Flags:     append([]cli.Flag{passwordFileFlag, passwordAlgorithmFlag}, passwordInputFlags...),
+			Action:    rotatePassword,
+		},
+		{
+			Name:      "secret",
+			Usage:     "generates a signing secret for an entry and prints it, replacing any previous one",
+			ArgsUsage: "name",
+			Flags: []cli.Flag{
+				passwordFileFlag,
+				&cli.BoolFlag{Name: "remove", Usage: "remove the signing secret instead, so that servers must send the password again"},
+				&cli.BoolFlag{Name: "relay", Usage: "let trackers relaying with the secret name the servers they relay"},
+			},
+			Action: generateSigningSecret,
+		},
+	},
+}
+
+func passwordNameArg(cCtx *cli.Context) string {
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected a password entry name. e.g. acme")
+	}
+
+	return cCtx.Args().First()
+}
+
+// readPassword returns the password from standard input, an environment variable or an interactive prompt. A new
+// password is prompted for twice, so that a typo does not lock servers out.
+func readPassword(cCtx *cli.Context, confirm bool) (string, error) {
+	var password string
+
+	switch {
+	case cCtx.Bool("password-stdin"):
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("could not read password from standard input: %s", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	case cCtx.String("password-env") != "":
+		password = os.Getenv(cCtx.String("password-env"))
+	default:
+		var err error
+		if password, err = registry.PromptUserForPassword("Enter password: "); err != nil {
+			return "", fmt.Errorf("could not prompt for password: %s", err)
+		}
+		fmt.Println()
+
+		if confirm {
+			repeated, err := registry.PromptUserForPassword("Repeat password: ")
+			fmt.Println()
+			if err != nil {
+				return "", fmt.Errorf("could not prompt for password: %s", err)
+			}
+			if repeated != password {
+				return "", fmt.Errorf("passwords do not match")
+			}
+		}
+	}
+
+	if password == "" {
+		return "", fmt.Errorf("password must not be empty")
+	}
+
+	return password, nil
+}
+
+func validatePasswordEntries(passwordCfg *config.PasswordConfig) error {
+	if errs := passwordCfg.Errors(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func encryptPassword(cCtx *cli.Context) error {
+
+	password, err := readPassword(cCtx, false)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := registry.HashPassword(password, cCtx.String("algorithm"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(hashedPassword)
+
+	return nil
+}
+
+func checkPassword(cCtx *cli.Context) error {
+
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected config file path. e.g. ~/passwords.yaml")
+	}
+
+	passwordConfig := config.ReadPasswordConfigFile(cCtx.Args().First())
+
+	password, err := readPassword(cCtx, false)
+	if err != nil {
+		return err
+	}
+
+	if entry, ok := registry.MatchPassword(password, *passwordConfig); ok {
+		fmt.Printf("Password is valid (%s).\n", entry.Name)
+	} else {
+		fmt.Println("Password is invalid.")
+	}
+
+	return nil
+}
+
+func initPasswordConfig(cCtx *cli.Context) error {
+
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected config destination file path. e.g. ~/passwords.yaml")
+	}
+
+	configDest := cCtx.Args().First()
+	defaultConfig := config.GetDefaultPasswordConfig()
+	config.WritePasswordConfig(*defaultConfig, configDest)
+
+	fmt.Println("Generated default password configuration and saved at:", configDest)
+
+	return nil
+}
+
+func validatePasswordConfig(cCtx *cli.Context) error {
+
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected config file path. e.g. ~/passwords.yaml")
+	}
+
+	configPath := cCtx.Args().First()
+
+	c := config.ReadPasswordConfigFile(configPath)
+
+	c.Validate()
+
+	log.Println("Validated password configuration.")
+	return nil
+}
+
+func addPassword(cCtx *cli.Context) error {
+
+	name := passwordNameArg(cCtx)
+	path := cCtx.String("file")
+	passwordCfg := config.ReadPasswordConfigFile(path)
+
+	if passwordCfg.FindEntry(name) >= 0 {
+		return fmt.Errorf("a password entry named %s already exists, rotate or remove it instead", name)
+	}
+
+	expiry, err := parseExpiry(cCtx.String("expires"))
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword(cCtx, true)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := registry.HashPassword(password, cCtx.String("algorithm"))
+	if err != nil {
+		return err
+	}
+
+	passwordCfg.PasswordEntries = append(passwordCfg.PasswordEntries, config.PasswordEntry{
+		Name:          name,
+		Description:   cCtx.String("description"),
+		Password:      hashedPassword,
+		AllowedNames:  cCtx.StringSlice("allowed-name"),
+		AllowedIPs:    cCtx.StringSlice("allowed-ip"),
+		MaxServers:    cCtx.Int("max-servers"),
+		Expiry:        expiry,
+		ReservedNames: cCtx.StringSlice("reserved-name"),
+	})
+
+	if err := validatePasswordEntries(passwordCfg); err != nil {
+		return err
+	}
+
+	if err := config.SavePasswordConfig(*passwordCfg, path); err != nil {
+		return err
+	}
+
+	fmt.Println("Added password entry", name)
+	return nil
+}
+
+func removePassword(cCtx *cli.Context) error {
+
+	name := passwordNameArg(cCtx)
+	path := cCtx.String("file")
+	passwordCfg := config.ReadPasswordConfigFile(path)
+
+	var kept []config.PasswordEntry
+	for _, entry := range passwordCfg.PasswordEntries {
+		if entry.Name != name {
+			kept = append(kept, entry)
+		}
+	}
+
+	removed := len(passwordCfg.PasswordEntries) - len(kept)
+	if removed == 0 {
+		return fmt.Errorf("no password entry named %s", name)
+	}
+
+	// A running tracker refuses to load a file without entries and would keep accepting the removed password.
+	if len(kept) == 0 {
+		return fmt.Errorf("cannot remove the last password entry, disable EnablePasswords instead")
+	}
+
+	passwordCfg.PasswordEntries = kept
+
+	if err := config.SavePasswordConfig(*passwordCfg, path); err != nil {
+		return err
+	}
+
+	if removed == 1 {
+		fmt.Println("Removed password entry", name)
+	} else {
+		fmt.Printf("Removed %d password entries named %s\n", removed, name)
+	}
+	return nil
+}
+
+func listPasswords(cCtx *cli.Context) error {
+
+	passwordCfg := config.ReadPasswordConfigFile(cCtx.String("file"))
+	now := time.Now()
+
+	for _, entry := range passwordCfg.PasswordEntries {
+		status := "active"
+		if entry.Expired(now) {
+			status = "expired"
+		}
+
+		expiry := "never"
+		if !entry.Expiry.IsZero() {
+			expiry = entry.Expiry.Format("2006-01-02 15:04:05")
+		}
+
+		var constraints []string
+		if len(entry.AllowedNames) > 0 {
+			constraints = append(constraints, "names: "+strings.Join(entry.AllowedNames, ","))
+		}
+		if len(entry.AllowedIPs) > 0 {
+			constraints = append(constraints, "ips: "+strings.Join(entry.AllowedIPs, ","))
+		}
+		if len(entry.ReservedNames) > 0 {
+			constraints = append(constraints, "reserves: "+strings.Join(entry.ReservedNames, ","))
+		}
+		if entry.MaxServers > 0 {
+			constraints = append(constraints, fmt.Sprintf("max servers: %d", entry.MaxServers))
+		}
+		if entry.SigningSecret != "" {
+			constraints = append(constraints, "signing secret")
+		}
+
+		fmt.Printf("%s\t%q\t%s\texpires: %s\t%s\t[%s]\n", entry.Name, entry.Description, registry.HashAlgorithm(entry.Password),
+			expiry, strings.Join(constraints, "\t"), status)
+	}
+
+	return nil
+}
+
+func rotatePassword(cCtx *cli.Context) error {
+
+	name := passwordNameArg(cCtx)
+	path := cCtx.String("file")
+	passwordCfg := config.ReadPasswordConfigFile(path)
+
+	index := passwordCfg.FindEntry(name)
+	if index < 0 {
+		return fmt.Errorf("no password entry named %s", name)
+	}
+
+	password, err := readPassword(cCtx, true)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := registry.HashPassword(password, cCtx.String("algorithm"))
+	if err != nil {
+		return err
+	}
+
+	passwordCfg.PasswordEntries[index].Password = hashedPassword
+
+	if err := config.SavePasswordConfig(*passwordCfg, path); err != nil {
+		return err
+	}
+
+	fmt.Println("Rotated password entry", name)
+	return nil
+}
+
+func generateSigningSecret(cCtx *cli.Context) error {
+
+	name := passwordNameArg(cCtx)
+	path := cCtx.String("file")
+	passwordCfg := config.ReadPasswordConfigFile(path)
+
+	index := passwordCfg.FindEntry(name)
+	if index < 0 {
+		return fmt.Errorf("no password entry named %s", name)
+	}
+
+	if cCtx.Bool("remove") {
+		passwordCfg.PasswordEntries[index].SigningSecret = ""
+		passwordCfg.PasswordEntries[index].Relay = false
+
+		if err := config.SavePasswordConfig(*passwordCfg, path); err != nil {
+			return err
+		}
+
+		fmt.Println("Removed signing secret of password entry", name)
+		return nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	passwordCfg.PasswordEntries[index].SigningSecret = hex.EncodeToString(secret)
+	passwordCfg.PasswordEntries[index].Relay = cCtx.Bool("relay")
+
+	if err := validatePasswordEntries(passwordCfg); err != nil {
+		return err
+	}
+
+	if err := config.SavePasswordConfig(*passwordCfg, path); err != nil {
+		return err
+	}
+
+	fmt.Println(passwordCfg.PasswordEntries[index].SigningSecret)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"magnetron/pkg/client"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// Commands that act on a running tracker talk to its REST API, since the registry only lives in the tracker's memory.
+var restClientFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "url",
+		Usage:   "base URL of the tracker's REST API",
+		Value:   "http://localhost:8080",
+		EnvVars: []string{"MAGNETRON_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "token",
+		Usage:   "bearer token used to authenticate with the REST API",
+		EnvVars: []string{"MAGNETRON_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "cert",
+		Usage:   "client certificate used to authenticate with the REST API, instead of or as well as a token",
+		EnvVars: []string{"MAGNETRON_CERT"},
+	},
+	&cli.StringFlag{
+		Name:    "key",
+		Usage:   "private key of the client certificate",
+		EnvVars: []string{"MAGNETRON_KEY"},
+	},
+	&cli.StringFlag{
+		Name:    "ca",
+		Usage:   "PEM bundle the REST API's certificate is verified with, the system roots when empty",
+		EnvVars: []string{"MAGNETRON_CA"},
+	},
+}
+
+func restClient(cCtx *cli.Context) *client.Client {
+	options := []client.Option{client.WithToken(cCtx.String("token"))}
+
+	if cCtx.String("cert") != "" || cCtx.String("ca") != "" {
+		tlsConfig, err := client.TLSConfig(cCtx.String("cert"), cCtx.String("key"), cCtx.String("ca"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, client.WithTLSConfig(tlsConfig))
+	}
+
+	return client.New(cCtx.String("url"), options...)
+}
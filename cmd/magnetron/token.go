@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"magnetron/internal/api"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var tokenFileFlag = &cli.StringFlag{
+	Name:    "file",
+	Aliases: []string{"f"},
+	Usage:   "token file, as set in RestApi.TokenAuthFile",
+	Value:   "./tokens.yml",
+	EnvVars: []string{"MAGNETRON_TOKEN_FILE"},
+}
+
+var tokenExpiresFlag = &cli.StringFlag{
+	Name:  "expires",
+	Usage: "how long the token is accepted, e.g. 720h or 90d; never expires when empty",
+}
+
+var tokenScopesFlag = &cli.StringSliceFlag{
+	Name:  "scope",
+	Usage: "scope granted to the token, may be repeated: " + scopeNames() + ", or the wildcards *, read:* and admin:*",
+}
+
+func scopeNames() string {
+	names := make([]string, 0, len(api.Scopes))
+	for _, scope := range api.Scopes {
+		names = append(names, string(scope))
+	}
+	return strings.Join(names, ", ")
+}
+
+var tokenCommand = &cli.Command{
+	Name:  "token",
+	Usage: "options for REST API token management",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "init",
+			Usage:  "creates an empty token file",
+			Flags:  []cli.Flag{tokenFileFlag},
+			Action: initTokenConfig,
+		},
+		{
+			Name:      "create",
+			Usage:     "generates a token, prints it once and stores its hash",
+			ArgsUsage: "name",
+			Flags: []cli.Flag{
+				tokenFileFlag,
+				&cli.StringFlag{Name: "description", Usage: "what the token is used for"},
+				tokenScopesFlag,
+				tokenExpiresFlag,
+				&cli.GenericFlag{Name: "subject", Value: &subjectsValue{}, Usage: "client certificate subject authenticated as the token, e.g. CN=backup,O=Acme; may be repeated"},
+				&cli.BoolFlag{Name: "certificate-only", Usage: "only authenticate client certificates with --subject, without generating a token"},
+			},
+			Action: createToken,
+		},
+		{
+			Name:   "list",
+			Usage:  "lists tokens without revealing them",
+			Flags:  []cli.Flag{tokenFileFlag},
+			Action: listTokens,
+		},
+		{
+			Name:      "revoke",
+			Usage:     "removes a token",
+			ArgsUsage: "name",
+			Flags:     []cli.Flag{tokenFileFlag},
+			Action:    revokeToken,
+		},
+		{
+			Name:      "rotate",
+			Usage:     "replaces a token with a newly generated one, keeping its name, description and scopes",
+			ArgsUsage: "name",
+			Flags:     []cli.Flag{tokenFileFlag, tokenScopesFlag, tokenExpiresFlag},
+			Action:    rotateToken,
+		},
+	},
+}
+
+// subjectsValue collects repeated --subject flags. Subjects contain commas, which a StringSliceFlag would split them on.
+type subjectsValue []string
+
+func (v *subjectsValue) Set(value string) error {
+	*v = append(*v, value)
+	return nil
+}
+
+func (v *subjectsValue) String() string {
+	return strings.Join(*v, "; ")
+}
+
+func tokenNameArg(cCtx *cli.Context) string {
+	if cCtx.Args().Len() != 1 {
+		log.Fatal("Expected a token name. e.g. monitoring")
+	}
+
+	return cCtx.Args().First()
+}
+
+// parseExpiry turns a duration such as 720h or 90d into the time a token or password entry expires, the zero time when empty.
+func parseExpiry(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	var duration time.Duration
+	var err error
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var count int
+		count, err = strconv.Atoi(days)
+		duration = time.Duration(count) * 24 * time.Hour
+	} else {
+		duration, err = time.ParseDuration(value)
+	}
+
+	if err != nil || duration <= 0 {
+		return time.Time{}, fmt.Errorf("invalid expiry: %s", value)
+	}
+
+	return time.Now().Add(duration).UTC().Truncate(time.Second), nil
+}
+
+func initTokenConfig(cCtx *cli.Context) error {
+
+	path := cCtx.String("file")
+
+	// Overwriting an existing file would revoke every token in it.
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("token file already exists: %s", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tokenCfg, err := api.GetDefaultTokenConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := api.SaveTokenConfig(*tokenCfg, path); err != nil {
+		return err
+	}
+
+	fmt.Println("Generated empty token file and saved at:", path)
+	return nil
+}
+
+func createToken(cCtx *cli.Context) error {
+
+	name := tokenNameArg(cCtx)
+	path := cCtx.String("file")
+	tokenCfg, err := api.LoadTokenConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	if tokenCfg.FindEntry(name) >= 0 {
+		return fmt.Errorf("a token named %s already exists, rotate or revoke it instead", name)
+	}
+
+	scopes := cCtx.StringSlice("scope")
+	if len(scopes) == 0 {
+		return fmt.Errorf("grant the token at least one scope with --scope")
+	}
+
+	expiry, err := parseExpiry(cCtx.String("expires"))
+	if err != nil {
+		return err
+	}
+
+	subjects := *cCtx.Generic("subject").(*subjectsValue)
+	certificateOnly := cCtx.Bool("certificate-only")
+	if certificateOnly && len(subjects) == 0 {
+		return fmt.Errorf("a certificate only token needs at least one --subject")
+	}
+
+	var token, tokenHash string
+	if !certificateOnly {
+		if token, tokenHash, err = api.GenerateToken(); err != nil {
+			return err
+		}
+	}
+
+	tokenCfg.TokenEntries = append(tokenCfg.TokenEntries, api.TokenEntry{
+		Name:                name,
+		Description:         cCtx.String("description"),
+		TokenHash:           tokenHash,
+		Scopes:              scopes,
+		CertificateSubjects: subjects,
+		CreatedAt:           time.Now().UTC().Truncate(time.Second),
+		Expiry:              expiry,
+	})
+
+	// Invalid scopes and subjects are refused before the file is written.
+	if err := validateTokenConfig(tokenCfg); err != nil {
+		return err
+	}
+
+	if err := api.SaveTokenConfig(*tokenCfg, path); err != nil {
+		return err
+	}
+
+	if certificateOnly {
+		fmt.Println("Created certificate only token", name)
+	} else {
+		printNewToken(name, token)
+	}
+	return nil
+}
+
+func validateTokenConfig(tokenCfg *api.TokenConfig) error {
+	if errs := tokenCfg.Validate(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func listTokens(cCtx *cli.Context) error {
+
+	tokenCfg, err := api.LoadTokenConfigFile(cCtx.String("file"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	for _, entry := range tokenCfg.TokenEntries {
+		status := "active"
+		if entry.Expired(now) {
+			status = "expired"
+		} else if !entry.HasToken() {
+			status = "active, certificate only"
+		} else if entry.TokenHash == "" {
+			status = "active, stored in plain text"
+		}
+
+		expiry := "never"
+		if !entry.Expiry.IsZero() {
+			expiry = entry.Expiry.Format("2006-01-02 15:04:05")
+		}
+
+		created := "unknown"
+		if !entry.CreatedAt.IsZero() {
+			created = entry.CreatedAt.Format("2006-01-02 15:04:05")
+		}
+
+		scopes := "every scope"
+		if len(entry.Scopes) > 0 {
+			scopes = strings.Join(entry.Scopes, ",")
+		}
+
+		subjects := ""
+		if len(entry.CertificateSubjects) > 0 {
+			subjects = "\tsubjects: " + strings.Join(entry.CertificateSubjects, "; ")
+		}
+
+		fmt.Printf("%s\t%q\tscopes: %s%s\tcreated: %s\texpires: %s\t[%s]\n", entry.Name, entry.Description, scopes, subjects, created, expiry, status)
+	}
+
+	return nil
+}
+
+func revokeToken(cCtx *cli.Context) error {
+
+	name := tokenNameArg(cCtx)
+	path := cCtx.String("file")
+	tokenCfg, err := api.LoadTokenConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	index := tokenCfg.FindEntry(name)
+	if index < 0 {
+		return fmt.Errorf("no token named %s", name)
+	}
+
+	tokenCfg.TokenEntries = append(tokenCfg.TokenEntries[:index], tokenCfg.TokenEntries[index+1:]...)
+
+	if err := api.SaveTokenConfig(*tokenCfg, path); err != nil {
+		return err
+	}
+
+	fmt.Println("Revoked token", name)
+	return nil
+}
+
+func rotateToken(cCtx *cli.Context) error {
+
+	name := tokenNameArg(cCtx)
+	path := cCtx.String("file")
+	tokenCfg, err := api.LoadTokenConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	index := tokenCfg.FindEntry(name)
+	if index < 0 {
+		return fmt.Errorf("no token named %s", name)
+	}
+
+	token, tokenHash, err := api.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	entry := &tokenCfg.TokenEntries[index]
+	entry.TokenHash = tokenHash
+	entry.Token = ""
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	if cCtx.IsSet("expires") {
+		if entry.Expiry, err = parseExpiry(cCtx.String("expires")); err != nil {
+			return err
+		}
+	}
+
+	if cCtx.IsSet("scope") {
+		entry.Scopes = cCtx.StringSlice("scope")
+	}
+
+	if err := validateTokenConfig(tokenCfg); err != nil {
+		return err
+	}
+
+	if err := api.SaveTokenConfig(*tokenCfg, path); err != nil {
+		return err
+	}
+
+	printNewToken(name, token)
+	return nil
+}
+
+func printNewToken(name string, token string) {
+	fmt.Printf("Token %s:\n\n    %s\n\nStore it now, it is not kept and cannot be shown again.\n", name, token)
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+type SessionDocument struct {
+	TokenAuth          bool       `json:"tokenAuth"`                    // Requests need a token; every scope is granted when they do not
+	Name               string     `json:"name,omitempty"`               // Token entry the request was authenticated as
+	CertificateSubject string     `json:"certificateSubject,omitempty"` // Subject of the verified client certificate
+	Scopes             []Scope    `json:"scopes"`                       // Every scope granted, with wildcards expanded
+	Expiry             *time.Time `json:"expiry,omitempty"`             // When the token stops being accepted
+}
+
+type TokensDocument struct {
+	Enabled bool            `json:"enabled"` // Token authentication is enabled
+	Tokens  []TokenDocument `json:"tokens"`
+}
+
+type TokenDocument struct {
+	Name                string     `json:"name"`
+	Description         string     `json:"description"`
+	Scopes              []string   `json:"scopes"` // Scopes as granted, including wildcards; every scope when empty
+	CertificateSubjects []string   `json:"certificateSubjects,omitempty"`
+	CertificateOnly     bool       `json:"certificateOnly"` // Only client certificates authenticate as the entry
+	CreatedAt           *time.Time `json:"createdAt,omitempty"`
+	Expiry              *time.Time `json:"expiry,omitempty"`
+	Expired             bool       `json:"expired"`
+}
+
+// getSession describes the token or client certificate a request is authenticated with and what it may do, so
+// that clients such as the dashboard can offer only the actions they are allowed.
+func (r *RestService) getSession(w http.ResponseWriter, request *http.Request) {
+
+	session := SessionDocument{TokenAuth: r.cfg.RestConfig.EnableTokenAuth, Scopes: []Scope{}}
+
+	tokenEntry, authenticated := tokenFromContext(request.Context())
+
+	for _, scope := range Scopes {
+		if !session.TokenAuth || (authenticated && tokenEntry.Grants(scope)) {
+			session.Scopes = append(session.Scopes, scope)
+		}
+	}
+
+	if authenticated {
+		session.Name = tokenEntry.Name
+		session.Expiry = optionalTime(tokenEntry.Expiry)
+	}
+	session.CertificateSubject = clientCertificateSubject(request)
+
+	writeJson(w, http.StatusOK, session)
+}
+
+// getTokens lists the entries of the token file without their tokens or hashes.
+func (r *RestService) getTokens(w http.ResponseWriter, request *http.Request) {
+
+	document := TokensDocument{Enabled: r.cfg.RestConfig.EnableTokenAuth, Tokens: []TokenDocument{}}
+
+	if r.tokens != nil {
+		now := time.Now()
+
+		for _, entry := range r.tokens.list() {
+			scopes := entry.Scopes
+			if scopes == nil {
+				scopes = []string{}
+			}
+
+			document.Tokens = append(document.Tokens, TokenDocument{
+				Name:                entry.Name,
+				Description:         entry.Description,
+				Scopes:              scopes,
+				CertificateSubjects: entry.CertificateSubjects,
+				CertificateOnly:     !entry.HasToken(),
+				CreatedAt:           optionalTime(entry.CreatedAt),
+				Expiry:              optionalTime(entry.Expiry),
+				Expired:             entry.Expired(now),
+			})
+		}
+	}
+
+	writeJson(w, http.StatusOK, document)
+}
@@ -3,14 +3,22 @@ package api
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"gorm.io/gorm"
-	"log"
+	"magnetron/internal/certs"
 	"magnetron/internal/config"
 	"magnetron/internal/db"
+	"magnetron/internal/directory"
+	"magnetron/internal/history"
+	"magnetron/internal/listing"
+	"magnetron/internal/logging"
+	"magnetron/internal/webhook"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,13 +29,24 @@ type RestService struct {
 	federatedServerStore  *db.FederatedServerStore
 	staticServerStore     *db.StaticServerStore
 	registeredServerStore *db.RegisteredServerStore
+	candidateTrackerStore *db.CandidateTrackerStore
+	banStore              *db.BanStore
+	serverOverrideStore   *db.ServerOverrideStore
+	listingBuilder        *listing.Builder
+	webhookService        *webhook.WebhookService
+	historyService        *history.HistoryService
+	tokens                *tokenSet
+	directory             *directory.Directory
+	cfgMutex              sync.Mutex
 }
 
 type StaticServersDocument struct {
 	Servers []StaticServerDocument `json:"servers"`
+	PageDocument
 }
 
 type StaticServerDocument struct {
+	ID          uint   `json:"id"`
 	Name        string `json:"name"`
 	Host        string `json:"host"`
 	Port        uint16 `json:"port"`
@@ -37,20 +56,27 @@ type StaticServerDocument struct {
 
 type RegisteredServersDocument struct {
 	Servers []RegisteredServerDocument `json:"servers"`
+	PageDocument
 }
 
 type RegisteredServerDocument struct {
-	Name        string    `json:"name"`
-	Host        string    `json:"host"`
-	Port        uint16    `json:"port"`
-	Description string    `json:"description"`
-	UserCount   uint16    `json:"userCount"`
-	FirstSeen   time.Time `json:"firstSeen"`
-	LastSeen    time.Time `json:"lastSeen"`
+	PassID        uint32    `json:"passId"`
+	Name          string    `json:"name"`
+	Host          string    `json:"host"`
+	Port          uint16    `json:"port"`
+	Description   string    `json:"description"`
+	UserCount     uint16    `json:"userCount"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+	Pinned        bool      `json:"pinned"`                  // Listed before the other registered servers
+	Overridden    bool      `json:"overridden"`              // Name or description is replaced by an override
+	PasswordEntry string    `json:"passwordEntry,omitempty"` // Password entry the server registered with
+	Verified      bool      `json:"verified"`                // Registered with a valid password
 }
 
 type FederatedServersDocument struct {
 	Servers []FederatedServerDocument `json:"servers"`
+	PageDocument
 }
 
 type FederatedServerDocument struct {
@@ -67,16 +93,24 @@ type FederatedServerDocument struct {
 
 type FederatedTrackersDocument struct {
 	Trackers []FederatedTrackerDocument `json:"trackers"`
+	PageDocument
 }
 
 type FederatedTrackerDocument struct {
-	Name        string    `json:"name"`
-	Host        string    `json:"host"`
-	Port        uint16    `json:"port"`
-	Description string    `json:"description"`
-	UserCount   uint16    `json:"userCount"`
-	FirstSeen   time.Time `json:"firstSeen"`
-	LastSeen    time.Time `json:"lastSeen"`
+	ID            uint      `json:"id"`
+	Name          string    `json:"name"`
+	Host          string    `json:"host"`
+	Port          uint16    `json:"port"`
+	Description   string    `json:"description"`
+	UserCount     uint16    `json:"userCount"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+	Tls           bool      `json:"tls"`                     // Poll the tracker's TLS client listener
+	TlsServerName string    `json:"tlsServerName,omitempty"` // Name the certificate is verified for, the host when empty
+	TlsCAFile     string    `json:"tlsCAFile,omitempty"`     // PEM bundle on the tracker's host the certificate is verified with
+	Up            bool      `json:"up"`                      // The last poll succeeded
+	LastPolled    time.Time `json:"lastPolled"`              // Zero until the tracker is first polled
+	LastPollError string    `json:"lastPollError,omitempty"` // Why the last poll failed
 }
 
 var (
@@ -116,9 +150,57 @@ func NewRestService(cfg *config.Config) error {
 		return fmt.Errorf("error while initializing registered server store: %s", err)
 	}
 
+	candidateTrackerStore, err := db.NewCandidateTrackerStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing candidate tracker store: %s", err)
+	}
+
+	banStore, err := db.NewBanStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing ban store: %s", err)
+	}
+
+	serverOverrideStore, err := db.NewServerOverrideStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing server override store: %s", err)
+	}
+
+	listingBuilder, err := listing.NewBuilder(database, cfg)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing listing builder: %s", err)
+	}
+
+	var publicDirectory *directory.Directory
+
+	if cfg.Directory.Enabled {
+		if !cfg.RestConfig.Enabled {
+			return fmt.Errorf("the directory needs the REST API to be enabled")
+		}
+
+		if publicDirectory, err = directory.NewDirectory(database, cfg, listingBuilder); err != nil {
+			return fmt.Errorf("error while initializing directory: %s", err)
+		}
+	}
+
+	var tokens *tokenSet
+
+	// Without token authentication every request is let through, so a client certificate would not restrict anything.
+	if clientAuth := cfg.RestConfig.ClientAuth; clientAuth != "" && clientAuth != config.ClientAuthNone && !cfg.RestConfig.EnableTokenAuth {
+		return fmt.Errorf("REST client certificates require EnableTokenAuth")
+	}
+
+	if cfg.RestConfig.EnableDashboard && !cfg.RestConfig.EnableTokenAuth {
+		return fmt.Errorf("the dashboard requires EnableTokenAuth")
+	}
+
 	if cfg.RestConfig.EnableTokenAuth {
-		tokenCfg := ReadTokenConfigFile(cfg.RestConfig.TokenAuthFile)
-		tokenCfg.Validate()
+		if tokens, err = newTokenSet(cfg.RestConfig.TokenAuthFile); err != nil {
+			return fmt.Errorf("error while loading API tokens: %s", err)
+		}
 	}
 
 	RestServiceInstance = &RestService{
@@ -128,91 +210,137 @@ func NewRestService(cfg *config.Config) error {
 		federatedServerStore:  federatedServerStore,
 		staticServerStore:     staticServerStore,
 		registeredServerStore: registeredServerStore,
+		candidateTrackerStore: candidateTrackerStore,
+		banStore:              banStore,
+		serverOverrideStore:   serverOverrideStore,
+		listingBuilder:        listingBuilder,
+		webhookService:        webhook.WebhookServiceInstance,
+		historyService:        history.HistoryServiceInstance,
+		tokens:                tokens,
+		directory:             publicDirectory,
 	}
 
 	return nil
 }
 
-func (r *RestService) BearerTokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// BearerTokenMiddleware lets requests through whose token is granted the scope the route requires. A request without
+// a bearer token is authenticated by its client certificate instead, when the certificate's subject is mapped to a
+// token entry.
+func (r *RestService) BearerTokenMiddleware(scope Scope, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
 
-		if !r.cfg.RestConfig.EnableTokenAuth {
-			next.ServeHTTP(w, request)
+		subject := clientCertificateSubject(request)
+		if principal, ok := request.Context().Value(principalContextKey{}).(*requestPrincipal); ok {
+			principal.certificateSubject = subject
 		}
 
-		bearerTokenHeaderValue := request.Header.Get("Authorization")
-
-		if bearerTokenHeaderValue == "" {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		if !r.cfg.RestConfig.EnableTokenAuth {
+			next.ServeHTTP(w, request)
 			return
 		}
 
-		authValue := strings.Split(bearerTokenHeaderValue, " ")
-
-		if len(authValue) != 2 {
+		var tokenEntry TokenEntry
+		if suppliedToken, ok := bearerToken(request); ok {
+			if tokenEntry, ok = r.tokens.authenticate(suppliedToken); !ok {
+				auditDenial(request, scope, "", "unknown or expired token")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		} else if subject != "" {
+			if tokenEntry, ok = r.tokens.authenticateCertificate(subject); !ok {
+				auditDenial(request, scope, "", "client certificate not mapped to a token entry or expired")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		} else {
+			auditDenial(request, scope, "", "missing bearer token")
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
 
-		if authValue[0] != "Bearer" {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
+		if principal, ok := request.Context().Value(principalContextKey{}).(*requestPrincipal); ok {
+			principal.tokenName = tokenEntry.Name
 		}
 
-		suppliedToken := authValue[1]
-
-		tokenCfg := ReadTokenConfigFile(r.cfg.RestConfig.TokenAuthFile)
-		tokenCfg.Validate()
-
-		for _, tokenEntry := range tokenCfg.TokenEntries {
-			if suppliedToken == tokenEntry.Token {
-				if tokenEntry.Expiry.After(time.Now()) {
-					ctx := context.WithValue(request.Context(), "token", suppliedToken)
-					next.ServeHTTP(w, request.WithContext(ctx))
-					return
-				}
-
-			}
+		if !tokenEntry.Grants(scope) {
+			auditDenial(request, scope, tokenEntry.Name, "missing scope")
+			http.Error(w, fmt.Sprintf("token is not granted the %s scope", scope), http.StatusForbidden)
+			return
 		}
 
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		ctx := context.WithValue(request.Context(), tokenContextKey{}, tokenEntry)
+		next.ServeHTTP(w, request.WithContext(ctx))
 	})
 }
 
-func (r *RestService) validateBearerToken(request *http.Request) bool {
-	bearerTokenHeaderValue := request.Header.Get("Authorization")
-
-	if bearerTokenHeaderValue == "" {
-		return false
+// configureClientAuth makes a TLS configuration ask for client certificates signed by the configured CAs.
+func configureClientAuth(tlsConfig *tls.Config, restCfg config.RestConfig) error {
+	switch restCfg.ClientAuth {
+	case "", config.ClientAuthNone:
+		return nil
+	case config.ClientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.ClientAuthRequired:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("unknown client auth mode %s, expected none, optional or required", restCfg.ClientAuth)
 	}
 
-	authValue := strings.Split(bearerTokenHeaderValue, " ")
-
-	if len(authValue) != 2 {
-		return false
+	bundle, err := os.ReadFile(restCfg.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("could not read client CA file: %w", err)
 	}
 
-	if authValue[0] != "Bearer" {
-		return false
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("no certificates found in client CA file %s", restCfg.ClientCAFile)
 	}
 
-	suppliedToken := authValue[1]
+	return nil
+}
 
-	tokenCfg := ReadTokenConfigFile(r.cfg.RestConfig.TokenAuthFile)
-	tokenCfg.Validate()
+// auditDenial records a request refused by token authentication, so that misuse of tokens can be traced.
+func auditDenial(request *http.Request, scope Scope, tokenName string, reason string) {
+	logger.Warn("Denied REST request",
+		"audit", true,
+		"reason", reason,
+		"token_name", tokenName,
+		"certificate_subject", clientCertificateSubject(request),
+		"scope", scope,
+		"method", request.Method,
+		"path", request.URL.Path,
+		logging.RemoteAddr(request.RemoteAddr))
+}
 
-	for _, tokenEntry := range tokenCfg.TokenEntries {
-		if suppliedToken == tokenEntry.Token {
-			return tokenEntry.Expiry.After(time.Now())
-		}
+// clientCertificateSubject returns the subject of the verified client certificate of a request, e.g.
+// CN=backup,O=Acme, or an empty string when the client presented none.
+func clientCertificateSubject(request *http.Request) string {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return ""
 	}
 
-	return false
+	return request.TLS.VerifiedChains[0][0].Subject.String()
+}
 
+// bearerToken returns the token of an "Authorization: Bearer <token>" header.
+func bearerToken(request *http.Request) (string, bool) {
+	authValue := strings.Split(request.Header.Get("Authorization"), " ")
+
+	if len(authValue) != 2 || authValue[0] != "Bearer" || authValue[1] == "" {
+		return "", false
+	}
+
+	return authValue[1], true
 }
 
 func (r *RestService) getStaticServers(w http.ResponseWriter, request *http.Request) {
 
+	query, err := parseListQuery(request, staticServerListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	servers, err := r.staticServerStore.GetStaticServers()
 
 	if err != nil {
@@ -223,6 +351,7 @@ func (r *RestService) getStaticServers(w http.ResponseWriter, request *http.Requ
 
 	for _, server := range servers {
 		serverDocument := StaticServerDocument{
+			ID:          server.ID,
 			Name:        server.Name,
 			Host:        server.Host,
 			Port:        server.Port,
@@ -233,8 +362,11 @@ func (r *RestService) getStaticServers(w http.ResponseWriter, request *http.Requ
 		serverDocuments = append(serverDocuments, serverDocument)
 	}
 
+	serverDocuments, page := applyListQuery(serverDocuments, query, staticServerListSpec)
+
 	responseDocument := StaticServersDocument{
-		serverDocuments,
+		Servers:      serverDocuments,
+		PageDocument: page,
 	}
 
 	jsonResponse, err := json.Marshal(responseDocument)
@@ -255,30 +387,54 @@ func (r *RestService) getStaticServers(w http.ResponseWriter, request *http.Requ
 
 func (r *RestService) getRegisteredServers(w http.ResponseWriter, request *http.Request) {
 
+	query, err := parseListQuery(request, registeredServerListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	servers, err := r.registeredServerStore.GetAllRegisteredServers()
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	servers, overrides, err := r.serverOverrideStore.ApplyOverrides(servers)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	serverDocuments := make([]RegisteredServerDocument, 0, len(servers))
 
 	for _, server := range servers {
+		override, overridden := overrides[server.PassID]
+
 		serverDocument := RegisteredServerDocument{
-			Name:        server.Name,
-			Host:        server.Host,
-			Port:        server.Port,
-			Description: server.Description,
-			UserCount:   server.UserCount,
-			FirstSeen:   server.FirstSeen,
-			LastSeen:    server.LastSeen,
+			PassID:        server.PassID,
+			Name:          server.Name,
+			Host:          server.Host,
+			Port:          server.Port,
+			Description:   server.Description,
+			UserCount:     server.UserCount,
+			FirstSeen:     server.FirstSeen,
+			LastSeen:      server.LastSeen,
+			Pinned:        override.Pinned,
+			Overridden:    overridden && (override.Name != "" || override.Description != ""),
+			PasswordEntry: server.PasswordEntry,
+			Verified:      server.Verified,
 		}
 
 		serverDocuments = append(serverDocuments, serverDocument)
 	}
 
+	serverDocuments, page := applyListQuery(serverDocuments, query, registeredServerListSpec)
+
 	responseDocument := RegisteredServersDocument{
-		serverDocuments,
+		Servers:      serverDocuments,
+		PageDocument: page,
 	}
 
 	jsonResponse, err := json.Marshal(responseDocument)
@@ -293,6 +449,12 @@ func (r *RestService) getRegisteredServers(w http.ResponseWriter, request *http.
 
 func (r *RestService) getFederatedServers(w http.ResponseWriter, request *http.Request) {
 
+	query, err := parseListQuery(request, federatedServerListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	trackers, err := r.federatedTrackerStore.GetFederatedTrackers()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -324,8 +486,11 @@ func (r *RestService) getFederatedServers(w http.ResponseWriter, request *http.R
 		}
 	}
 
+	serverDocuments, page := applyListQuery(serverDocuments, query, federatedServerListSpec)
+
 	responseDocument := FederatedServersDocument{
-		serverDocuments,
+		Servers:      serverDocuments,
+		PageDocument: page,
 	}
 
 	jsonResponse, err := json.Marshal(responseDocument)
@@ -340,6 +505,12 @@ func (r *RestService) getFederatedServers(w http.ResponseWriter, request *http.R
 
 func (r *RestService) getFederatedTrackers(w http.ResponseWriter, request *http.Request) {
 
+	query, err := parseListQuery(request, federatedTrackerListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	trackers, err := r.federatedTrackerStore.GetFederatedTrackers()
 
 	if err != nil {
@@ -349,21 +520,14 @@ func (r *RestService) getFederatedTrackers(w http.ResponseWriter, request *http.
 	trackerDocuments := make([]FederatedTrackerDocument, 0)
 
 	for _, tracker := range trackers {
-		trackerDocument := FederatedTrackerDocument{
-			Name:        tracker.Name,
-			Host:        tracker.Host,
-			Port:        tracker.Port,
-			Description: tracker.Description,
-			UserCount:   tracker.UserCount,
-			FirstSeen:   tracker.FirstSeen,
-			LastSeen:    tracker.LastSeen,
-		}
-
-		trackerDocuments = append(trackerDocuments, trackerDocument)
+		trackerDocuments = append(trackerDocuments, newFederatedTrackerDocument(tracker))
 	}
 
+	trackerDocuments, page := applyListQuery(trackerDocuments, query, federatedTrackerListSpec)
+
 	responseDocument := FederatedTrackersDocument{
-		trackerDocuments,
+		Trackers:     trackerDocuments,
+		PageDocument: page,
 	}
 
 	jsonResponse, err := json.Marshal(responseDocument)
@@ -375,30 +539,144 @@ func (r *RestService) getFederatedTrackers(w http.ResponseWriter, request *http.
 	_, err = w.Write(jsonResponse)
 }
 
+type route struct {
+	pattern string // Method and path, as accepted by http.HandleFunc
+	scope   Scope  // Scope a token must be granted to call the route
+	handler http.HandlerFunc
+}
+
+// routes lists every REST endpoint with the scope it requires. The OpenAPI document served by the API must describe
+// exactly these routes, and the scopes they require.
+func (r *RestService) routes() []route {
+	return []route{
+		{"GET /metrics", ScopeReadMetrics, r.getMetrics},
+		{"GET /api/v1/openapi.yaml", ScopeAnyToken, r.getOpenApiYaml},
+		{"GET /api/v1/openapi.json", ScopeAnyToken, r.getOpenApiJson},
+		{"GET /api/v1/session", ScopeAnyToken, r.getSession},
+		{"GET /api/v1/tokens/", ScopeReadTokens, r.getTokens},
+		{"GET /api/v1/listing", ScopeReadServers, r.getListing},
+		{"GET /api/v1/events", ScopeReadEvents, r.streamEvents},
+		{"GET /api/v1/servers/static/", ScopeReadServers, r.getStaticServers},
+		{"POST /api/v1/servers/static/", ScopeAdminServers, r.createStaticServer},
+		{"PUT /api/v1/servers/static/order", ScopeAdminServers, r.reorderStaticServers},
+		{"PUT /api/v1/servers/static/{id}", ScopeAdminServers, r.updateStaticServer},
+		{"DELETE /api/v1/servers/static/{id}", ScopeAdminServers, r.deleteStaticServer},
+		{"GET /api/v1/servers/registered/", ScopeReadServers, r.getRegisteredServers},
+		{"DELETE /api/v1/servers/registered/{passId}", ScopeAdminServers, r.expireRegisteredServer},
+		{"PUT /api/v1/servers/registered/{passId}/override", ScopeAdminServers, r.setOverride},
+		{"PATCH /api/v1/servers/registered/{passId}/override", ScopeAdminServers, r.patchOverride},
+		{"DELETE /api/v1/servers/registered/{passId}/override", ScopeAdminServers, r.removeOverride},
+		{"GET /api/v1/overrides/", ScopeReadServers, r.getOverrides},
+		{"GET /api/v1/bans/", ScopeReadBans, r.getBans},
+		{"POST /api/v1/bans/", ScopeAdminBans, r.createBan},
+		{"DELETE /api/v1/bans/{id}", ScopeAdminBans, r.removeBan},
+		{"GET /api/v1/servers/federated/", ScopeReadServers, r.getFederatedServers},
+		{"GET /api/v1/trackers/federated/", ScopeReadServers, r.getFederatedTrackers},
+		{"POST /api/v1/trackers/federated/", ScopeAdminConfig, r.createFederatedTracker},
+		{"PUT /api/v1/trackers/federated/order", ScopeAdminConfig, r.reorderFederatedTrackers},
+		{"PUT /api/v1/trackers/federated/{id}", ScopeAdminConfig, r.updateFederatedTracker},
+		{"DELETE /api/v1/trackers/federated/{id}", ScopeAdminConfig, r.deleteFederatedTracker},
+		{"GET /api/v1/history/servers/{passId}", ScopeReadHistory, r.getServerHistory},
+		{"GET /api/v1/history/uptime/", ScopeReadHistory, r.getServerUptimes},
+		{"GET /api/v1/history/tracker", ScopeReadHistory, r.getTrackerHistory},
+		{"GET /api/v1/webhooks/", ScopeReadWebhooks, r.getWebhooks},
+		{"GET /api/v1/webhooks/deliveries/", ScopeReadWebhooks, r.getWebhookDeliveries},
+		{"POST /api/v1/webhooks/deliveries/{id}/retry", ScopeAdminWebhooks, r.retryWebhookDelivery},
+		{"GET /api/v1/trackers/candidates/", ScopeReadServers, r.getCandidateTrackers},
+		{"POST /api/v1/trackers/candidates/promote", ScopeAdminConfig, r.promoteCandidateTracker},
+	}
+}
+
 func (r *RestService) Serve() {
 
 	if !r.cfg.RestConfig.Enabled {
 		return
 	}
 
-	log.Printf("Serving REST clients from %s", r.cfg.RestConfig.Host)
+	logger.Info("Serving REST clients", "host", r.cfg.RestConfig.Host)
+
+	routes := r.routes()
+
+	if r.cfg.RestConfig.EnableTokenAuth {
+		go r.tokens.watch()
+	}
+
+	// Browsers send client certificates along with requests from any page, so changes from other origins are
+	// refused. Safe methods and clients that are not browsers pass.
+	crossOriginProtection := http.NewCrossOriginProtection()
+
+	// Token authentication is applied to every route; the middleware lets requests through when it is disabled.
+	// Routes that change the tracker are then not served at all, as anyone reaching the port could call them.
+	var unprotected int
+	for _, route := range routes {
+		if route.scope.IsAdmin() && !r.cfg.RestConfig.EnableTokenAuth {
+			unprotected++
+			continue
+		}
+
+		handler := r.BearerTokenMiddleware(route.scope, route.handler)
+		http.HandleFunc(route.pattern, logRequests(crossOriginProtection.Handler(handler).ServeHTTP))
+	}
+
+	if unprotected > 0 {
+		logger.Warn("Token authentication is disabled, routes that change the tracker are not served", "routes", unprotected)
+	}
+
+	// The dashboard is not part of the REST API and is not described by the OpenAPI document.
+	if r.cfg.RestConfig.EnableDashboard {
+		http.HandleFunc("GET "+dashboardPath, logRequests(dashboardHandler()))
+		logger.Info("Serving web dashboard", "path", dashboardPath)
+	}
+
+	// The directory and its feeds are public, and are served without token authentication.
+	if r.directory != nil {
+		path := r.directory.Path()
+		http.HandleFunc("GET "+path+"{$}", logRequests(r.directory.ServePage))
+		http.HandleFunc("GET "+path+"feed.rss", logRequests(r.directory.ServeRSS))
+		http.HandleFunc("GET "+path+"feed.atom", logRequests(r.directory.ServeAtom))
+		http.HandleFunc("GET "+path+"feed.json", logRequests(r.directory.ServeJSONFeed))
+		go r.directory.Watch()
+		logger.Info("Serving public directory", "path", path)
+	}
+
+	if !r.cfg.RestConfig.EnableTls && r.cfg.RestConfig.ClientAuth != "" && r.cfg.RestConfig.ClientAuth != config.ClientAuthNone {
+		logger.Error("REST client certificates require EnableTls")
+		os.Exit(1)
+	}
 
 	if r.cfg.RestConfig.EnableTls {
 
-		http.HandleFunc("GET /api/v1/servers/static/", r.BearerTokenMiddleware(r.getStaticServers))
-		http.HandleFunc("GET /api/v1/servers/registered/", r.BearerTokenMiddleware(r.getRegisteredServers))
-		http.HandleFunc("GET /api/v1/servers/federated/", r.BearerTokenMiddleware(r.getFederatedServers))
-		http.HandleFunc("GET /api/v1/trackers/federated/", r.BearerTokenMiddleware(r.getFederatedTrackers))
+		restCfg := r.cfg.RestConfig
+		if err := certs.EnsureCertificate(restCfg.CertFile, restCfg.KeyFile, restCfg.Host, logger); err != nil {
+			logger.Error("Could not generate REST certificate", logging.Err(err))
+			os.Exit(1)
+		}
+
+		// The certificate is served through GetCertificate, so that a renewed one is used without a restart.
+		reloader, err := certs.NewReloader(restCfg.CertFile, restCfg.KeyFile, logger)
+		if err != nil {
+			logger.Error("Could not load REST certificate", logging.Err(err))
+			os.Exit(1)
+		}
+		go reloader.Watch()
 
 		tlsConfig := &tls.Config{
 			MinVersion:       tls.VersionTLS12,
 			CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
 				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
 			},
+			GetCertificate: reloader.GetCertificate,
+		}
+
+		if err := configureClientAuth(tlsConfig, r.cfg.RestConfig); err != nil {
+			logger.Error("Could not configure REST client certificates", logging.Err(err))
+			os.Exit(1)
 		}
 
 		server := &http.Server{
@@ -408,18 +686,12 @@ func (r *RestService) Serve() {
 			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
 		}
 
-		err := server.ListenAndServeTLS(r.cfg.RestConfig.CertFile, r.cfg.RestConfig.KeyFile)
-		if err != nil {
+		if err := server.ListenAndServeTLS("", ""); err != nil {
 			panic(err)
 		}
 
 	} else {
 
-		http.HandleFunc("GET /api/v1/servers/static/", r.getStaticServers)
-		http.HandleFunc("GET /api/v1/servers/registered/", r.getRegisteredServers)
-		http.HandleFunc("GET /api/v1/servers/federated/", r.getFederatedServers)
-		http.HandleFunc("GET /api/v1/trackers/federated/", r.getFederatedTrackers)
-
 		err := http.ListenAndServe(r.cfg.RestConfig.Host, nil)
 
 		if err != nil {
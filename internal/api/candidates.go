@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"magnetron/internal/db"
+	"magnetron/internal/logging"
+	"net/http"
+	"time"
+)
+
+type CandidateTrackersDocument struct {
+	Trackers []CandidateTrackerDocument `json:"trackers"`
+	PageDocument
+}
+
+type CandidateTrackerDocument struct {
+	Host          string    `json:"host"`
+	Port          uint16    `json:"port"`
+	ListedName    string    `json:"listedName"`
+	ServerCount   uint16    `json:"serverCount"`
+	Depth         uint16    `json:"depth"`
+	DiscoveredVia string    `json:"discoveredVia"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+}
+
+type PromoteCandidateRequest struct {
+	Host        string `json:"host"`
+	Port        uint16 `json:"port"`
+	Name        string `json:"name"`        // Defaults to the name the candidate was listed under
+	Description string `json:"description"` // Optional description shown in the listing
+	Persist     bool   `json:"persist"`     // Write the promoted tracker back to the configuration file
+}
+
+func (r *RestService) getCandidateTrackers(w http.ResponseWriter, request *http.Request) {
+
+	query, err := parseListQuery(request, candidateTrackerListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candidates, err := r.candidateTrackerStore.GetCandidateTrackers()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	candidateDocuments := make([]CandidateTrackerDocument, 0, len(candidates))
+
+	for _, candidate := range candidates {
+		candidateDocument := CandidateTrackerDocument{
+			Host:          candidate.Host,
+			Port:          candidate.Port,
+			ListedName:    candidate.ListedName,
+			ServerCount:   candidate.ServerCount,
+			Depth:         candidate.Depth,
+			DiscoveredVia: candidate.DiscoveredVia,
+			FirstSeen:     candidate.FirstSeen,
+			LastSeen:      candidate.LastSeen,
+		}
+
+		candidateDocuments = append(candidateDocuments, candidateDocument)
+	}
+
+	candidateDocuments, page := applyListQuery(candidateDocuments, query, candidateTrackerListSpec)
+
+	responseDocument := CandidateTrackersDocument{
+		Trackers:     candidateDocuments,
+		PageDocument: page,
+	}
+
+	jsonResponse, err := json.Marshal(responseDocument)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(jsonResponse)
+}
+
+// promoteCandidateTracker moves a discovered tracker into the federation. The tracker is polled from the next
+// federation poll onwards, and is optionally written back to the configuration file.
+func (r *RestService) promoteCandidateTracker(w http.ResponseWriter, request *http.Request) {
+
+	var promoteRequest PromoteCandidateRequest
+
+	if err := json.NewDecoder(request.Body).Decode(&promoteRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candidate, err := r.candidateTrackerStore.GetCandidateTracker(promoteRequest.Host, promoteRequest.Port)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := promoteRequest.Name
+	if name == "" {
+		name = candidate.ListedName
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	trackers, err := r.federatedTrackerStore.GetFederatedTrackers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tracker, err := r.federatedTrackerStore.RegisterFederatedTracker(candidate.Host, candidate.Port, name, promoteRequest.Description, 0, uint16(len(trackers)), db.TrackerTls{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.syncTrackerEntries(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.candidateTrackerStore.RemoveCandidateTracker(candidate.Host, candidate.Port); err != nil {
+		logger.Error("Could not remove promoted candidate tracker", logging.Tracker(trackerAddress(candidate.Host, candidate.Port)), logging.Err(err))
+	}
+
+	if promoteRequest.Persist {
+		if err := r.cfg.Save(); err != nil {
+			http.Error(w, "tracker was promoted but the configuration could not be saved: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	logger.Info("Promoted candidate tracker", logging.Tracker(trackerAddress(candidate.Host, candidate.Port)), "name", name, logging.RemoteAddr(request.RemoteAddr))
+
+	trackerDocument := newFederatedTrackerDocument(tracker)
+
+	jsonResponse, err := json.Marshal(trackerDocument)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(jsonResponse)
+}
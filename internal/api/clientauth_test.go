@@ -0,0 +1,132 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"magnetron/internal/certs"
+	"magnetron/internal/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestClientCertificateAuth serves routes behind token authentication over TLS with client certificates, and checks
+// that a certificate authenticates as the token entry its subject is mapped to, and only with that entry's scopes.
+func TestClientCertificateAuth(t *testing.T) {
+	directory := t.TempDir()
+
+	caPEM, caKeyPEM, err := certs.GenerateSelfSigned([]string{"Test CA"}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := tls.X509KeyPair(caPEM, caKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(directory, "clients.pem")
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenFile := filepath.Join(directory, "tokens.yml")
+	tokenCfg := TokenConfig{TokenEntries: []TokenEntry{
+		{Name: "backup", Scopes: []string{string(ScopeReadServers)}, CertificateSubjects: []string{"CN=backup,O=Acme"}},
+	}}
+	if err := SaveTokenConfig(tokenCfg, tokenFile); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := newTokenSet(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restCfg := config.RestConfig{EnableTls: true, EnableTokenAuth: true, ClientAuth: config.ClientAuthOptional, ClientCAFile: caFile}
+	service := &RestService{cfg: &config.Config{RestConfig: restCfg}, tokens: tokens}
+
+	ok := func(w http.ResponseWriter, request *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /servers", service.BearerTokenMiddleware(ScopeReadServers, ok))
+	mux.HandleFunc("GET /bans", service.BearerTokenMiddleware(ScopeAdminBans, ok))
+
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = &tls.Config{}
+	if err := configureClientAuth(server.TLS, restCfg); err != nil {
+		t.Fatal(err)
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		subject *pkix.Name
+		path    string
+		want    int
+	}{
+		{"mapped subject", &pkix.Name{CommonName: "backup", Organization: []string{"Acme"}}, "/servers", http.StatusOK},
+		{"unmapped subject", &pkix.Name{CommonName: "stranger", Organization: []string{"Acme"}}, "/servers", http.StatusUnauthorized},
+		{"missing scope", &pkix.Name{CommonName: "backup", Organization: []string{"Acme"}}, "/bans", http.StatusForbidden},
+		{"no certificate", nil, "/servers", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := server.Client().Transport.(*http.Transport).Clone()
+			client := &http.Client{Transport: transport}
+
+			if test.subject != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{newClientCertificate(t, ca, *test.subject)}
+			}
+
+			response, err := client.Get(server.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != test.want {
+				t.Errorf("GET %s answered %d, want %d", test.path, response.StatusCode, test.want)
+			}
+		})
+	}
+}
+
+// newClientCertificate issues a client certificate for the subject, signed by the CA.
+func newClientCertificate(t *testing.T, ca tls.Certificate, subject pkix.Name) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca.Leaf, &key.PublicKey, ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
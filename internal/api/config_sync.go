@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"magnetron/internal/config"
+	"magnetron/internal/db"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+type ReorderRequest struct {
+	IDs []uint `json:"ids"` // Every ID of the collection, in the new order
+}
+
+// syncStaticEntries rebuilds the configured static entries from the store so the configuration can be saved.
+// Entries that did not change are kept as they were read, preserving how their addresses were written.
+func (r *RestService) syncStaticEntries() error {
+	servers, err := r.staticServerStore.GetStaticServers()
+	if err != nil {
+		return err
+	}
+
+	remaining := slices.Clone(r.cfg.StaticEntries)
+	entries := make([]config.StaticEntry, 0, len(servers))
+
+	for _, server := range servers {
+		entry := config.StaticEntry{
+			Name:        server.Name,
+			Description: server.Description,
+			Address:     net.JoinHostPort(server.Host, strconv.Itoa(int(server.Port))),
+			UserCount:   server.UserCount,
+		}
+
+		for i, existing := range remaining {
+			if staticEntryMatches(existing, server) {
+				entry = existing
+				remaining = slices.Delete(remaining, i, i+1)
+				break
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	r.cfg.StaticEntries = entries
+	return nil
+}
+
+// syncTrackerEntries rebuilds the configured federated trackers from the store so the configuration can be saved.
+func (r *RestService) syncTrackerEntries() error {
+	trackers, err := r.federatedTrackerStore.GetFederatedTrackers()
+	if err != nil {
+		return err
+	}
+
+	remaining := slices.Clone(r.cfg.TrackerFederation.TrackerEntries)
+	entries := make([]config.TrackerEntry, 0, len(trackers))
+
+	for _, tracker := range trackers {
+		entry := config.TrackerEntry{
+			Address:       net.JoinHostPort(tracker.Host, strconv.Itoa(int(tracker.Port))),
+			Name:          tracker.Name,
+			Description:   tracker.Description,
+			UserCount:     tracker.UserCount,
+			Tls:           tracker.Tls.Enabled,
+			TlsServerName: tracker.Tls.ServerName,
+			TlsCAFile:     tracker.Tls.CAFile,
+		}
+
+		for i, existing := range remaining {
+			if trackerEntryMatches(existing, tracker) {
+				entry = existing
+				remaining = slices.Delete(remaining, i, i+1)
+				break
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	r.cfg.TrackerFederation.TrackerEntries = entries
+	return nil
+}
+
+func staticEntryMatches(entry config.StaticEntry, server db.StaticServer) bool {
+	host, hostErr := entry.GetHost()
+	port, portErr := entry.GetPort()
+
+	return hostErr == nil && portErr == nil && host == server.Host && port == server.Port &&
+		entry.Name == server.Name && entry.Description == server.Description && entry.UserCount == server.UserCount
+}
+
+func trackerEntryMatches(entry config.TrackerEntry, tracker db.FederatedTracker) bool {
+	host, hostErr := entry.GetHost()
+	port, portErr := entry.GetPort()
+
+	return hostErr == nil && portErr == nil && host == tracker.Host && port == tracker.Port &&
+		entry.Name == tracker.Name && entry.Description == tracker.Description && entry.UserCount == tracker.UserCount &&
+		entry.Tls == tracker.Tls.Enabled && entry.TlsServerName == tracker.Tls.ServerName && entry.TlsCAFile == tracker.Tls.CAFile
+}
+
+// persistRequested reports whether the caller asked for a change to be written back to the configuration file.
+func persistRequested(request *http.Request) (bool, error) {
+	persist := request.URL.Query().Get("persist")
+
+	if persist == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(persist)
+}
+
+func parseID(request *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(request.PathValue("id"), 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id: %s", request.PathValue("id"))
+	}
+
+	return uint(id), nil
+}
+
+// isPermutation reports whether ids contains every existing ID exactly once.
+func isPermutation(ids []uint, existingIDs []uint) bool {
+	if len(ids) != len(existingIDs) {
+		return false
+	}
+
+	sortedIDs := slices.Clone(ids)
+	slices.Sort(sortedIDs)
+
+	sortedExistingIDs := slices.Clone(existingIDs)
+	slices.Sort(sortedExistingIDs)
+
+	return slices.Equal(sortedIDs, sortedExistingIDs)
+}
+
+func writeJson(w http.ResponseWriter, status int, document any) {
+	jsonResponse, err := json.Marshal(document)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(jsonResponse)
+}
+
+// commitConfigChange mirrors a change made to the stores into the configuration and, when requested, saves it.
+// It writes an error response and returns false when the change could not be committed.
+func (r *RestService) commitConfigChange(w http.ResponseWriter, request *http.Request, sync func() error) bool {
+	persist, err := persistRequested(request)
+	if err != nil {
+		http.Error(w, "invalid persist parameter: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if err := sync(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if persist {
+		if err := r.cfg.Save(); err != nil {
+			http.Error(w, "change was applied but the configuration could not be saved: "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardPath is where the web admin dashboard is served when RestApi.EnableDashboard is set.
+const dashboardPath = "/admin/"
+
+var (
+	//go:embed dashboard
+	dashboardResources embed.FS
+)
+
+// dashboardHandler serves the dashboard's static files. They hold no data: the dashboard calls the REST API with
+// the token the operator logs in with, so the files themselves are served without authentication.
+func dashboardHandler() http.HandlerFunc {
+	assets, err := fs.Sub(dashboardResources, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	files := http.StripPrefix(dashboardPath, http.FileServerFS(assets))
+
+	return func(w http.ResponseWriter, request *http.Request) {
+		header := w.Header()
+		header.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'; form-action 'self'")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Cache-Control", "no-cache")
+
+		files.ServeHTTP(w, request)
+	}
+}
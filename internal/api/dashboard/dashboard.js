@@ -0,0 +1,541 @@
+// Magnetron web dashboard. Everything shown is fetched from the REST API with the token the operator logs in with;
+// server names and descriptions come from the network and are only ever inserted as text.
+"use strict";
+
+const tokenKey = "magnetron-token";
+const pollInterval = 10000;
+
+let session = null;
+let activeTab = null;
+let pollTimer = null;
+let eventStream = null;
+let refreshTimer = null;
+
+const $ = (id) => document.getElementById(id);
+
+// el creates an element with the given properties and children; strings become text nodes.
+function el(tag, props = {}, ...children) {
+  const element = document.createElement(tag);
+  for (const [key, value] of Object.entries(props)) {
+    if (key.startsWith("on")) {
+      element.addEventListener(key.slice(2), value);
+    } else if (key === "class") {
+      element.className = value;
+    } else {
+      element[key] = value;
+    }
+  }
+  for (const child of children) {
+    if (child !== null && child !== undefined) {
+      element.append(child instanceof Node ? child : String(child));
+    }
+  }
+  return element;
+}
+
+function button(label, onclick, title = "") {
+  return el("button", { type: "button", title, onclick }, label);
+}
+
+function can(scope) {
+  return session !== null && session.scopes.includes(scope);
+}
+
+function formatTime(value) {
+  if (!value || value.startsWith("0001-")) {
+    return "never";
+  }
+  const time = new Date(value);
+  return el("time", { dateTime: value, title: time.toLocaleString() }, ago(time));
+}
+
+function ago(time) {
+  const seconds = Math.round((Date.now() - time.getTime()) / 1000);
+  const future = seconds < 0;
+  let amount = Math.abs(seconds);
+  let unit = "s";
+  for (const [size, name] of [[60, "m"], [60, "h"], [24, "d"]]) {
+    if (amount < size) {
+      break;
+    }
+    amount = Math.floor(amount / size);
+    unit = name;
+  }
+  return future ? `in ${amount}${unit}` : `${amount}${unit} ago`;
+}
+
+function setStatus(message, isError = false) {
+  const status = $("status");
+  status.textContent = message;
+  status.className = isError ? "error" : "";
+}
+
+class UnauthorizedError extends Error {}
+
+// api calls the REST API, returning the decoded JSON response or null for responses without a body.
+async function api(method, path, body = undefined, persist = false) {
+  const headers = { Accept: "application/json" };
+  const token = sessionStorage.getItem(tokenKey);
+  if (token) {
+    headers.Authorization = `Bearer ${token}`;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  if (persist && $("persist").checked) {
+    path += (path.includes("?") ? "&" : "?") + "persist=true";
+  }
+
+  const response = await fetch(path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+    credentials: "same-origin",
+    cache: "no-store",
+  });
+
+  if (response.status === 401) {
+    throw new UnauthorizedError("Unknown or expired token");
+  }
+  if (!response.ok) {
+    throw new Error((await response.text()).trim() || response.statusText);
+  }
+  if (response.status === 204) {
+    return null;
+  }
+  return response.json();
+}
+
+// act runs an admin action and refreshes the active tab, reporting the outcome.
+async function act(description, action) {
+  try {
+    await action();
+    setStatus(description);
+    await refresh();
+  } catch (error) {
+    handleError(error);
+  }
+}
+
+function handleError(error) {
+  if (error instanceof UnauthorizedError) {
+    showLogin(error.message);
+    return;
+  }
+  setStatus(error.message, true);
+}
+
+function fillTable(tab, rows) {
+  const body = $(`tab-${tab}`).querySelector("tbody");
+  body.replaceChildren(...rows);
+  if (rows.length === 0) {
+    const columns = $(`tab-${tab}`).querySelectorAll("thead th").length;
+    body.append(el("tr", {}, el("td", { colSpan: columns, class: "empty" }, "Nothing to show")));
+  }
+}
+
+const loaders = {
+  async listing() {
+    const listing = await api("GET", "/api/v1/listing");
+    fillTable("listing", listing.rows.map((row) => {
+      if (row.kind === "header") {
+        return el("tr", { class: "header" }, el("td", { colSpan: 4 }, row.name));
+      }
+      return el("tr", { class: row.kind },
+        el("td", {}, row.name),
+        el("td", {}, row.description),
+        el("td", {}, `${row.host}:${row.port}`),
+        el("td", { class: "number" }, row.userCount));
+    }));
+  },
+
+  async registered() {
+    const [registered, overrides] = await Promise.all([
+      api("GET", "/api/v1/servers/registered/"),
+      api("GET", "/api/v1/overrides/"),
+    ]);
+    const overridesByPassID = new Map(overrides.overrides.map((override) => [override.passId, override]));
+
+    fillTable("registered", registered.servers.map((server) => {
+      const override = overridesByPassID.get(server.passId);
+      const actions = el("td", { class: "actions" });
+
+      if (can("admin:servers")) {
+        actions.append(
+          button("Expire", () => {
+            if (confirm(`Delist ${server.name} until it registers again?`)) {
+              act(`Expired ${server.name}`, () => api("DELETE", `/api/v1/servers/registered/${server.passId}`));
+            }
+          }, "Delist until the server registers again"),
+          button(server.pinned ? "Unpin" : "Pin", () =>
+            act(server.pinned ? `Unpinned ${server.name}` : `Pinned ${server.name}`, () =>
+              api("PATCH", `/api/v1/servers/registered/${server.passId}/override`, { pinned: !server.pinned }, true)),
+          "Pinned servers are listed before the other registered servers"),
+          button("Rename", () => {
+            const name = prompt("Listed name, empty to keep the server's own:", override ? override.name : server.name);
+            if (name === null) {
+              return;
+            }
+            const description = prompt("Listed description, empty to keep the server's own:", override ? override.description : server.description);
+            if (description === null) {
+              return;
+            }
+            act(`Set the override of ${server.name}`, () =>
+              api("PATCH", `/api/v1/servers/registered/${server.passId}/override`, { name, description }, true));
+          }, "Replace the listed name and description"));
+
+        if (override) {
+          actions.append(button("Clear override", () =>
+            act(`Removed the override of ${server.name}`, () =>
+              api("DELETE", `/api/v1/servers/registered/${server.passId}/override`, undefined, true))));
+        }
+      }
+
+      if (can("admin:bans")) {
+        actions.append(button("Ban", () => {
+          const reason = prompt(`Ban pass ID ${server.passId} (${server.name}). Reason:`, "");
+          if (reason !== null) {
+            act(`Banned ${server.name}`, () => api("POST", "/api/v1/bans/", { passId: server.passId, reason }, true));
+          }
+        }, "Ban the server's pass ID and delist it"));
+      }
+
+      const flags = [];
+      if (server.pinned) {
+        flags.push("pinned");
+      }
+      if (server.overridden) {
+        flags.push("overridden");
+      }
+
+      return el("tr", {},
+        el("td", { title: `Pass ID ${server.passId}` }, server.name, flags.length ? el("span", { class: "flags" }, ` ${flags.join(", ")}`) : null),
+        el("td", {}, server.description),
+        el("td", {}, `${server.host}:${server.port}`),
+        el("td", { class: "number" }, server.userCount),
+        el("td", {}, formatTime(server.firstSeen)),
+        el("td", {}, formatTime(server.lastSeen)),
+        el("td", {}, server.verified ? server.passwordEntry || "verified" : "none"),
+        actions);
+    }));
+  },
+
+  async static() {
+    const statics = await api("GET", "/api/v1/servers/static/");
+    const ids = statics.servers.map((server) => server.id);
+
+    $("static-form").hidden = !can("admin:servers");
+
+    fillTable("static", statics.servers.map((server, index) => {
+      const actions = el("td", { class: "actions" });
+
+      if (can("admin:servers")) {
+        const move = (offset) => {
+          const order = [...ids];
+          [order[index], order[index + offset]] = [order[index + offset], order[index]];
+          act("Reordered static entries", () => api("PUT", "/api/v1/servers/static/order", { ids: order }, true));
+        };
+
+        actions.append(
+          button("↑", () => move(-1), "Move up"),
+          button("↓", () => move(1), "Move down"),
+          button("Edit", () => editStatic(server)),
+          button("Delete", () => {
+            if (confirm(`Remove the static entry ${server.name}?`)) {
+              act(`Removed ${server.name}`, () => api("DELETE", `/api/v1/servers/static/${server.id}`, undefined, true));
+            }
+          }));
+        actions.children[0].disabled = index === 0;
+        actions.children[1].disabled = index === ids.length - 1;
+      }
+
+      return el("tr", {},
+        el("td", {}, server.name),
+        el("td", {}, server.description),
+        el("td", {}, `${server.host}:${server.port}`),
+        el("td", { class: "number" }, server.userCount),
+        actions);
+    }));
+  },
+
+  async trackers() {
+    const trackers = await api("GET", "/api/v1/trackers/federated/");
+    fillTable("trackers", trackers.trackers.map((tracker) => {
+      let status = el("span", { class: "unknown" }, "not polled yet");
+      if (tracker.up) {
+        status = el("span", { class: "up" }, "up");
+      } else if (!tracker.lastPolled.startsWith("0001-")) {
+        status = el("span", { class: "down" }, "down");
+      }
+
+      return el("tr", {},
+        el("td", {}, tracker.name),
+        el("td", {}, `${tracker.host}:${tracker.port}`),
+        el("td", {}, tracker.tls ? "yes" : "no"),
+        el("td", {}, status),
+        el("td", {}, formatTime(tracker.lastPolled)),
+        el("td", {}, formatTime(tracker.lastSeen)),
+        el("td", { class: "error" }, tracker.lastPollError || ""));
+    }));
+  },
+
+  async bans() {
+    const bans = await api("GET", "/api/v1/bans/");
+
+    $("ban-form").hidden = !can("admin:bans");
+
+    fillTable("bans", bans.bans.map((ban) => {
+      const matches = [];
+      if (ban.passId) {
+        matches.push(`pass ID ${ban.passId}`);
+      }
+      if (ban.address) {
+        matches.push(ban.address);
+      }
+      if (ban.ip) {
+        matches.push(ban.ip);
+      }
+
+      const actions = el("td", { class: "actions" });
+      if (can("admin:bans")) {
+        actions.append(button("Remove", () =>
+          act("Removed the ban", () => api("DELETE", `/api/v1/bans/${ban.id}`, undefined, true))));
+      }
+
+      return el("tr", {},
+        el("td", {}, matches.join(", ")),
+        el("td", {}, ban.reason),
+        el("td", {}, formatTime(ban.createdAt)),
+        actions);
+    }));
+  },
+
+  async tokens() {
+    const tokens = await api("GET", "/api/v1/tokens/");
+
+    fillTable("tokens", tokens.tokens.map((token) => {
+      let expiry = token.expiry ? formatTime(token.expiry) : "never";
+      if (token.expired) {
+        expiry = el("span", { class: "down" }, "expired");
+      }
+
+      return el("tr", { class: token.expired ? "expired" : "" },
+        el("td", {}, token.name),
+        el("td", {}, token.description),
+        el("td", {}, token.scopes.length ? token.scopes.join(", ") : "every scope"),
+        el("td", {}, (token.certificateSubjects || []).join("; "), token.certificateOnly ? el("span", { class: "flags" }, " certificate only") : null),
+        el("td", {}, token.createdAt ? formatTime(token.createdAt) : ""),
+        el("td", {}, expiry));
+    }));
+  },
+};
+
+function editStatic(server) {
+  $("static-id").value = server.id;
+  $("static-name").value = server.name;
+  $("static-host").value = server.host;
+  $("static-port").value = server.port;
+  $("static-description").value = server.description;
+  $("static-users").value = server.userCount;
+  $("static-submit").textContent = "Save";
+  $("static-cancel").hidden = false;
+}
+
+function resetStaticForm() {
+  $("static-form").reset();
+  $("static-id").value = "";
+  $("static-submit").textContent = "Add";
+  $("static-cancel").hidden = true;
+}
+
+async function refresh() {
+  if (activeTab === null) {
+    return;
+  }
+  try {
+    await loaders[activeTab]();
+  } catch (error) {
+    handleError(error);
+  }
+}
+
+// scheduleRefresh coalesces the refreshes triggered by a burst of events.
+function scheduleRefresh() {
+  clearTimeout(refreshTimer);
+  refreshTimer = setTimeout(refresh, 500);
+}
+
+function selectTab(tab) {
+  activeTab = tab;
+  for (const tabButton of $("tabs").querySelectorAll("button")) {
+    tabButton.classList.toggle("active", tabButton.dataset.tab === tab);
+  }
+  for (const section of document.querySelectorAll("section")) {
+    section.hidden = section.id !== `tab-${tab}`;
+  }
+  setStatus("");
+  refresh();
+}
+
+// watchEvents refreshes the active tab whenever the tracker publishes an event. EventSource cannot send the token,
+// so the stream is read through fetch.
+async function watchEvents() {
+  const controller = new AbortController();
+  eventStream = controller;
+
+  try {
+    const headers = {};
+    const token = sessionStorage.getItem(tokenKey);
+    if (token) {
+      headers.Authorization = `Bearer ${token}`;
+    }
+
+    const response = await fetch("/api/v1/events", { headers, signal: controller.signal, cache: "no-store" });
+    if (!response.ok) {
+      throw new Error(response.statusText);
+    }
+
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        break;
+      }
+      if (decoder.decode(value, { stream: true }).includes("data:")) {
+        scheduleRefresh();
+      }
+    }
+  } catch (error) {
+    if (controller.signal.aborted) {
+      return;
+    }
+  }
+
+  if (eventStream === controller) {
+    setTimeout(() => eventStream === controller && watchEvents(), 5000);
+  }
+}
+
+function stopUpdates() {
+  clearInterval(pollTimer);
+  pollTimer = null;
+  if (eventStream) {
+    eventStream.abort();
+    eventStream = null;
+  }
+}
+
+function showLogin(message = "") {
+  stopUpdates();
+  session = null;
+  activeTab = null;
+  sessionStorage.removeItem(tokenKey);
+  $("app").hidden = true;
+  $("session").hidden = true;
+  $("login").hidden = false;
+  $("login-error").textContent = message;
+  $("login-token").focus();
+}
+
+function showApp() {
+  $("login").hidden = true;
+  $("app").hidden = false;
+  $("session").hidden = false;
+
+  let name = session.name || "";
+  if (session.certificateSubject && !sessionStorage.getItem(tokenKey)) {
+    name = `${name} (${session.certificateSubject})`;
+  }
+  $("session-name").textContent = name;
+  $("logout").hidden = !sessionStorage.getItem(tokenKey);
+
+  let firstTab = null;
+  for (const tabButton of $("tabs").querySelectorAll("button")) {
+    tabButton.hidden = !can(tabButton.dataset.scope);
+    if (!tabButton.hidden && firstTab === null) {
+      firstTab = tabButton.dataset.tab;
+    }
+  }
+
+  if (firstTab === null) {
+    setStatus("This token is not granted any scope the dashboard can show.", true);
+    return;
+  }
+
+  stopUpdates();
+  // Events make updates immediate; polling also catches what events do not announce, e.g. user count refreshes.
+  if (can("read:events")) {
+    watchEvents();
+  }
+  pollTimer = setInterval(refresh, pollInterval);
+
+  selectTab(firstTab);
+}
+
+async function start() {
+  try {
+    session = await api("GET", "/api/v1/session");
+    showApp();
+  } catch (error) {
+    showLogin(error instanceof UnauthorizedError && sessionStorage.getItem(tokenKey) ? error.message : "");
+    if (!(error instanceof UnauthorizedError)) {
+      $("login-error").textContent = error.message;
+    }
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("login").addEventListener("submit", (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(tokenKey, $("login-token").value.trim());
+    $("login-token").value = "";
+    start();
+  });
+
+  $("logout").addEventListener("click", () => showLogin());
+
+  for (const tabButton of $("tabs").querySelectorAll("button")) {
+    tabButton.addEventListener("click", () => selectTab(tabButton.dataset.tab));
+  }
+
+  $("static-cancel").addEventListener("click", resetStaticForm);
+
+  $("static-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const id = $("static-id").value;
+    const server = {
+      name: $("static-name").value,
+      host: $("static-host").value.trim(),
+      port: Number($("static-port").value),
+      description: $("static-description").value,
+      userCount: Number($("static-users").value),
+    };
+
+    act(id ? `Updated ${server.name}` : `Added ${server.name}`, async () => {
+      if (id) {
+        await api("PUT", `/api/v1/servers/static/${id}`, server, true);
+      } else {
+        await api("POST", "/api/v1/servers/static/", server, true);
+      }
+      resetStaticForm();
+    });
+  });
+
+  $("ban-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const ban = {
+      passId: Number($("ban-pass-id").value) || 0,
+      address: $("ban-address").value.trim(),
+      ip: $("ban-ip").value.trim(),
+      reason: $("ban-reason").value,
+    };
+
+    act("Added the ban", async () => {
+      await api("POST", "/api/v1/bans/", ban, true);
+      $("ban-form").reset();
+    });
+  });
+
+  start();
+});
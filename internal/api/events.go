@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"magnetron/internal/events"
+	"magnetron/internal/logging"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventStreamBuffer    = 256
+	eventStreamKeepAlive = 15 * time.Second
+)
+
+// Refreshes happen every few minutes per server without changing anything, so they are only streamed on request.
+var defaultStreamedEventTypes = []events.EventType{
+	events.ServerRegistered, events.ServerUpdated, events.ServerExpired, events.ServerRejected, events.TrackerUp, events.TrackerDown,
+}
+
+func parseEventTypes(request *http.Request) ([]events.EventType, error) {
+	typesParam := request.URL.Query().Get("types")
+	if typesParam == "" {
+		return defaultStreamedEventTypes, nil
+	}
+
+	var eventTypes []events.EventType
+
+	for _, typeName := range strings.Split(typesParam, ",") {
+		eventType := events.EventType(strings.TrimSpace(typeName))
+		if !slices.Contains(events.AllTypes, eventType) {
+			return nil, fmt.Errorf("unknown event type %q", typeName)
+		}
+		eventTypes = append(eventTypes, eventType)
+	}
+
+	return eventTypes, nil
+}
+
+// parseLastEventID reads the ID of the last event a reconnecting client received. Browsers send it in the
+// Last-Event-ID header; clients that cannot set headers may use the lastEventId query parameter instead.
+func parseLastEventID(request *http.Request) (uint64, bool, error) {
+	lastEventID := request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = request.URL.Query().Get("lastEventId")
+	}
+
+	if lastEventID == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid last event ID %q", lastEventID)
+	}
+
+	return id, true, nil
+}
+
+// streamEvents pushes registry and federation events to the client as Server-Sent Events. Each event carries its
+// ID so that a reconnecting client resumes where it left off. When events cannot be replayed, because they are no
+// longer retained or the client fell behind, a resync event tells the client to reload its state.
+func (r *RestService) streamEvents(w http.ResponseWriter, request *http.Request) {
+
+	eventTypes, err := parseEventTypes(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lastEventID, resuming, err := parseLastEventID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var subscription *events.Subscription
+	var missed []events.Event
+	complete := true
+
+	if resuming {
+		subscription, missed, complete = events.SubscribeSince(lastEventID, eventStreamBuffer)
+	} else {
+		subscription = events.Subscribe(eventStreamBuffer)
+	}
+	defer subscription.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &eventStream{w: w, flusher: flusher, eventTypes: eventTypes, lastEventID: lastEventID}
+
+	if !complete {
+		// The client's ID may come from before a restart, so IDs are no longer compared against it.
+		stream.lastEventID = 0
+		stream.writeResync()
+	}
+
+	for _, event := range missed {
+		stream.write(event)
+	}
+	stream.flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+
+			// Every subscriber sees every event, so a gap in the IDs means the bus dropped some for this client.
+			if stream.lastEventID != 0 && event.ID > stream.lastEventID+1 {
+				stream.writeResync()
+			}
+
+			if err := stream.write(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+type eventStream struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	eventTypes  []events.EventType
+	lastEventID uint64
+}
+
+func (s *eventStream) write(event events.Event) error {
+	if event.ID <= s.lastEventID {
+		return nil
+	}
+	s.lastEventID = event.ID
+
+	if !slices.Contains(s.eventTypes, event.Type) {
+		return nil
+	}
+
+	eventJson, err := json.Marshal(event.Document())
+	if err != nil {
+		logger.Error("Could not encode event", "event_type", event.Type, logging.Err(err))
+		return nil
+	}
+
+	_, err = fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, eventJson)
+	return err
+}
+
+func (s *eventStream) writeResync() {
+	_, _ = fmt.Fprint(s.w, "event: resync\ndata: {}\n\n")
+}
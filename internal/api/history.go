@@ -0,0 +1,259 @@
+package api
+
+import (
+	"fmt"
+	"magnetron/internal/history"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ServerHistoryDocument struct {
+	PassID        uint32                 `json:"passId"`
+	Name          string                 `json:"name"` // Name of the server when it was last sampled
+	Since         time.Time              `json:"since"`
+	UptimePercent float64                `json:"uptimePercent"`
+	Points        []HistoryPointDocument `json:"points"`
+	Transitions   []TransitionDocument   `json:"transitions"`
+}
+
+type HistoryPointDocument struct {
+	Time       time.Time `json:"time"`
+	Resolution string    `json:"resolution"` // Length of the period the point covers, e.g. 5m0s or 1h0m0s
+	MinUsers   uint16    `json:"minUsers"`
+	MaxUsers   uint16    `json:"maxUsers"`
+	AvgUsers   float64   `json:"avgUsers"`
+}
+
+type TransitionDocument struct {
+	Time   time.Time `json:"time"`
+	Online bool      `json:"online"`
+}
+
+type ServerUptimesDocument struct {
+	Since   time.Time              `json:"since"`
+	Servers []ServerUptimeDocument `json:"servers"`
+	PageDocument
+}
+
+type ServerUptimeDocument struct {
+	PassID          uint32  `json:"passId"`
+	Name            string  `json:"name"`
+	UptimePercent   float64 `json:"uptimePercent"`
+	OnlineSeconds   int64   `json:"onlineSeconds"`
+	ObservedSeconds int64   `json:"observedSeconds"` // Part of the period the server was known for
+	AvgUsers        float64 `json:"avgUsers"`
+	MaxUsers        uint16  `json:"maxUsers"`
+}
+
+type TrackerHistoryDocument struct {
+	Since  time.Time               `json:"since"`
+	Peak   TrackerPeriodDocument   `json:"peak"`
+	Daily  []TrackerPeriodDocument `json:"daily"`
+	Weekly []TrackerPeriodDocument `json:"weekly"` // Weeks start on Monday, UTC
+}
+
+type TrackerPeriodDocument struct {
+	Start       time.Time  `json:"start"`
+	MaxUsers    uint64     `json:"maxUsers"`
+	AvgUsers    float64    `json:"avgUsers"`
+	MaxServers  uint32     `json:"maxServers"`
+	AvgServers  float64    `json:"avgServers"`
+	PeakUsersAt *time.Time `json:"peakUsersAt,omitempty"`
+}
+
+func newTrackerPeriodDocument(period history.TrackerPeriod) TrackerPeriodDocument {
+	return TrackerPeriodDocument{
+		Start:       period.Start,
+		MaxUsers:    period.MaxUsers,
+		AvgUsers:    period.AvgUsers,
+		MaxServers:  period.MaxServers,
+		AvgServers:  period.AvgServers,
+		PeakUsersAt: optionalTime(period.PeakUsersAt),
+	}
+}
+
+// parseDuration accepts Go durations such as 36h as well as a number of days such as 7d.
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid number of days: %s", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	return duration, nil
+}
+
+// parsePeriod returns the start of the period given by the period parameter, e.g. 24h or 30d.
+func parsePeriod(request *http.Request, defaultPeriod time.Duration) (time.Time, error) {
+	period := defaultPeriod
+
+	if value := request.URL.Query().Get("period"); value != "" {
+		parsed, err := parseDuration(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("period: %s", err)
+		}
+		period = parsed
+	}
+
+	return time.Now().Add(-period), nil
+}
+
+// historyEnabled answers requests for history with not found while it is not recorded.
+func (r *RestService) historyEnabled(w http.ResponseWriter) bool {
+	if !r.historyService.Enabled() {
+		http.Error(w, "history is not enabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func (r *RestService) getServerHistory(w http.ResponseWriter, request *http.Request) {
+
+	if !r.historyEnabled(w) {
+		return
+	}
+
+	passID, err := parsePassID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	since, err := parsePeriod(request, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resolution time.Duration
+	if value := request.URL.Query().Get("resolution"); value != "" {
+		if resolution, err = parseDuration(value); err != nil {
+			http.Error(w, "resolution: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	serverHistory, err := r.historyService.ServerHistory(passID, since, resolution)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(serverHistory.Points) == 0 && len(serverHistory.Transitions) == 0 && serverHistory.Uptime.Observed == 0 {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	document := ServerHistoryDocument{
+		PassID:        serverHistory.PassID,
+		Name:          serverHistory.Name,
+		Since:         since,
+		UptimePercent: serverHistory.Uptime.Percent(),
+		Points:        make([]HistoryPointDocument, 0, len(serverHistory.Points)),
+		Transitions:   make([]TransitionDocument, 0, len(serverHistory.Transitions)),
+	}
+
+	for _, point := range serverHistory.Points {
+		document.Points = append(document.Points, HistoryPointDocument{
+			Time:       point.Time,
+			Resolution: point.Resolution.String(),
+			MinUsers:   point.MinUsers,
+			MaxUsers:   point.MaxUsers,
+			AvgUsers:   point.AvgUsers,
+		})
+	}
+
+	for _, transition := range serverHistory.Transitions {
+		document.Transitions = append(document.Transitions, TransitionDocument{Time: transition.Time, Online: transition.Online})
+	}
+
+	writeJson(w, http.StatusOK, document)
+}
+
+func (r *RestService) getServerUptimes(w http.ResponseWriter, request *http.Request) {
+
+	if !r.historyEnabled(w) {
+		return
+	}
+
+	since, err := parsePeriod(request, 7*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query, err := parseListQuery(request, serverUptimeListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ranking, err := r.historyService.UptimeRanking(since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	uptimeDocuments := make([]ServerUptimeDocument, 0, len(ranking))
+
+	for _, serverUptime := range ranking {
+		uptimeDocuments = append(uptimeDocuments, ServerUptimeDocument{
+			PassID:          serverUptime.PassID,
+			Name:            serverUptime.Name,
+			UptimePercent:   serverUptime.Uptime.Percent(),
+			OnlineSeconds:   int64(serverUptime.Uptime.Online.Seconds()),
+			ObservedSeconds: int64(serverUptime.Uptime.Observed.Seconds()),
+			AvgUsers:        serverUptime.AvgUsers,
+			MaxUsers:        serverUptime.MaxUsers,
+		})
+	}
+
+	uptimeDocuments, page := applyListQuery(uptimeDocuments, query, serverUptimeListSpec)
+
+	writeJson(w, http.StatusOK, ServerUptimesDocument{Since: since, Servers: uptimeDocuments, PageDocument: page})
+}
+
+func (r *RestService) getTrackerHistory(w http.ResponseWriter, request *http.Request) {
+
+	if !r.historyEnabled(w) {
+		return
+	}
+
+	since, err := parsePeriod(request, 30*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	summary, err := r.historyService.TrackerSummary(since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	document := TrackerHistoryDocument{
+		Since:  since,
+		Peak:   newTrackerPeriodDocument(summary.Peak),
+		Daily:  make([]TrackerPeriodDocument, 0, len(summary.Daily)),
+		Weekly: make([]TrackerPeriodDocument, 0, len(summary.Weekly)),
+	}
+
+	for _, period := range summary.Daily {
+		document.Daily = append(document.Daily, newTrackerPeriodDocument(period))
+	}
+
+	for _, period := range summary.Weekly {
+		document.Weekly = append(document.Weekly, newTrackerPeriodDocument(period))
+	}
+
+	writeJson(w, http.StatusOK, document)
+}
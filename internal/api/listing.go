@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/binary"
+	"magnetron/internal/listing"
+	"magnetron/internal/logging"
+	"net"
+	"net/http"
+)
+
+type ListingDocument struct {
+	Rows []ListingRowDocument `json:"rows"`
+}
+
+type ListingRowDocument struct {
+	Kind        listing.RowKind `json:"kind"` // static, registered, header, federated-tracker or federated-server
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Host        string          `json:"host"`
+	Port        uint16          `json:"port"`
+	UserCount   uint16          `json:"userCount"`
+}
+
+// getListing returns the listing exactly as a Hotline client receives it, either as JSON rows or, with
+// format=raw, as the bytes the tracker writes to the client.
+func (r *RestService) getListing(w http.ResponseWriter, request *http.Request) {
+
+	format := request.URL.Query().Get("format")
+
+	if format != "" && format != "json" && format != "raw" {
+		http.Error(w, "format must be json or raw", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := r.listingBuilder.Build()
+	if err != nil {
+		logger.Error("Could not build listing", logging.Err(err))
+	}
+
+	if format == "raw" {
+		rawListing, err := listing.Encode(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(rawListing)
+		return
+	}
+
+	rowDocuments := make([]ListingRowDocument, 0, len(rows))
+
+	for _, row := range rows {
+		msg := row.Message
+
+		rowDocuments = append(rowDocuments, ListingRowDocument{
+			Kind:        row.Kind,
+			Name:        string(msg.Name),
+			Description: string(msg.Description),
+			Host:        net.IPv4(msg.IPAddr[0], msg.IPAddr[1], msg.IPAddr[2], msg.IPAddr[3]).String(),
+			Port:        binary.BigEndian.Uint16(msg.Port[:]),
+			UserCount:   binary.BigEndian.Uint16(msg.NumUsers[:]),
+		})
+	}
+
+	writeJson(w, http.StatusOK, ListingDocument{rowDocuments})
+}
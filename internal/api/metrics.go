@@ -0,0 +1,17 @@
+package api
+
+import (
+	"magnetron/internal/metrics"
+	"net/http"
+)
+
+// getMetrics serves the Prometheus metrics, unless they are disabled or have a listener of their own.
+func (r *RestService) getMetrics(w http.ResponseWriter, request *http.Request) {
+
+	if !r.cfg.Metrics.Enabled || r.cfg.Metrics.Host != "" {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	metrics.Handler().ServeHTTP(w, request)
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"magnetron/internal/config"
+	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"magnetron/internal/logging"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type BansDocument struct {
+	Bans []BanDocument `json:"bans"`
+}
+
+type BanDocument struct {
+	ID        uint      `json:"id"`
+	PassID    uint32    `json:"passId,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type OverridesDocument struct {
+	Overrides []OverrideDocument `json:"overrides"`
+}
+
+type OverrideDocument struct {
+	PassID      uint32 `json:"passId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Pinned      bool   `json:"pinned"`
+}
+
+// OverridePatchDocument changes the fields of an override it sets, and keeps the others.
+type OverridePatchDocument struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Pinned      *bool   `json:"pinned,omitempty"`
+}
+
+func newBanDocument(ban db.Ban) BanDocument {
+	return BanDocument{
+		ID:        ban.ID,
+		PassID:    ban.PassID,
+		Address:   ban.Address,
+		IP:        ban.IP,
+		Reason:    ban.Reason,
+		CreatedAt: ban.CreatedAt,
+	}
+}
+
+func newOverrideDocument(override db.ServerOverride) OverrideDocument {
+	return OverrideDocument{
+		PassID:      override.PassID,
+		Name:        override.Name,
+		Description: override.Description,
+		Pinned:      override.Pinned,
+	}
+}
+
+func parsePassID(request *http.Request) (uint32, error) {
+	passID, err := strconv.ParseUint(request.PathValue("passId"), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pass id: %s", request.PathValue("passId"))
+	}
+
+	return uint32(passID), nil
+}
+
+// syncModeration rebuilds the configured bans and overrides from the stores so the configuration can be saved.
+func (r *RestService) syncModeration() error {
+	bans, err := r.banStore.GetBans()
+	if err != nil {
+		return err
+	}
+
+	overrides, err := r.serverOverrideStore.GetOverrides()
+	if err != nil {
+		return err
+	}
+
+	banEntries := make([]config.BanEntry, 0, len(bans))
+	for _, ban := range bans {
+		banEntries = append(banEntries, config.BanEntry{
+			PassID:  ban.PassID,
+			Address: ban.Address,
+			IP:      ban.IP,
+			Reason:  ban.Reason,
+		})
+	}
+
+	overrideEntries := make([]config.OverrideEntry, 0, len(overrides))
+	for _, override := range overrides {
+		overrideEntries = append(overrideEntries, config.OverrideEntry{
+			PassID:      override.PassID,
+			Name:        override.Name,
+			Description: override.Description,
+			Pinned:      override.Pinned,
+		})
+	}
+
+	r.cfg.Moderation.Bans = banEntries
+	r.cfg.Moderation.Overrides = overrideEntries
+	return nil
+}
+
+// expireRegisteredServer delists a server immediately. The server reappears with its next registration unless
+// it is also banned.
+func (r *RestService) expireRegisteredServer(w http.ResponseWriter, request *http.Request) {
+
+	passID, err := parsePassID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server, err := r.registeredServerStore.RemoveServer(passID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Force expired server", logging.ServerName(server.Name), logging.PassID(server.PassID), logging.RemoteAddr(request.RemoteAddr))
+	events.Publish(events.Event{Type: events.ServerExpired, Origin: server.Origin, Server: server})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *RestService) getOverrides(w http.ResponseWriter, request *http.Request) {
+
+	overrides, err := r.serverOverrideStore.GetOverrides()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	overrideDocuments := make([]OverrideDocument, 0, len(overrides))
+	for _, override := range overrides {
+		overrideDocuments = append(overrideDocuments, newOverrideDocument(override))
+	}
+
+	writeJson(w, http.StatusOK, OverridesDocument{overrideDocuments})
+}
+
+func (r *RestService) setOverride(w http.ResponseWriter, request *http.Request) {
+
+	passID, err := parsePassID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var document OverrideDocument
+
+	if err := json.NewDecoder(request.Body).Decode(&document); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(document.Name) > 255 || len(document.Description) > 255 {
+		http.Error(w, "name and description must be at most 255 bytes", http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	override, err := r.serverOverrideStore.SetOverride(passID, document.Name, document.Description, document.Pinned)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncModeration) {
+		return
+	}
+
+	logger.Info("Set listing override", logging.PassID(passID), logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusOK, newOverrideDocument(override))
+}
+
+// patchOverride changes some fields of the override of a server, creating it when the server has none.
+func (r *RestService) patchOverride(w http.ResponseWriter, request *http.Request) {
+
+	passID, err := parsePassID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var document OverridePatchDocument
+
+	if err := json.NewDecoder(request.Body).Decode(&document); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if (document.Name != nil && len(*document.Name) > 255) || (document.Description != nil && len(*document.Description) > 255) {
+		http.Error(w, "name and description must be at most 255 bytes", http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	override, err := r.serverOverrideStore.GetOverride(passID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if document.Name != nil {
+		override.Name = *document.Name
+	}
+	if document.Description != nil {
+		override.Description = *document.Description
+	}
+	if document.Pinned != nil {
+		override.Pinned = *document.Pinned
+	}
+
+	override, err = r.serverOverrideStore.SetOverride(passID, override.Name, override.Description, override.Pinned)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncModeration) {
+		return
+	}
+
+	logger.Info("Changed listing override", logging.PassID(passID), logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusOK, newOverrideDocument(override))
+}
+
+func (r *RestService) removeOverride(w http.ResponseWriter, request *http.Request) {
+
+	passID, err := parsePassID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	if _, err := r.serverOverrideStore.GetOverride(passID); errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.serverOverrideStore.RemoveOverride(passID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncModeration) {
+		return
+	}
+
+	logger.Info("Removed listing override", logging.PassID(passID), logging.RemoteAddr(request.RemoteAddr))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *RestService) getBans(w http.ResponseWriter, request *http.Request) {
+
+	bans, err := r.banStore.GetBans()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	banDocuments := make([]BanDocument, 0, len(bans))
+	for _, ban := range bans {
+		banDocuments = append(banDocuments, newBanDocument(ban))
+	}
+
+	writeJson(w, http.StatusOK, BansDocument{banDocuments})
+}
+
+// createBan bans servers from registering and delists the registered servers the ban matches.
+func (r *RestService) createBan(w http.ResponseWriter, request *http.Request) {
+
+	var document BanDocument
+
+	if err := json.NewDecoder(request.Body).Decode(&document); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	banEntry := config.BanEntry{
+		PassID:  document.PassID,
+		Address: document.Address,
+		IP:      document.IP,
+		Reason:  document.Reason,
+	}
+
+	if banErrors := banEntry.Validate(); len(banErrors) > 0 {
+		messages := make([]string, 0, len(banErrors))
+		for _, banError := range banErrors {
+			messages = append(messages, banError.Error())
+		}
+
+		http.Error(w, strings.Join(messages, "; "), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	ban, err := r.banStore.AddBan(banEntry.PassID, banEntry.Address, banEntry.IP, banEntry.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if servers, err := r.registeredServerStore.GetAllRegisteredServers(); err != nil {
+		logger.Error("Could not get registered servers to expire banned ones", logging.Err(err))
+	} else {
+		for _, server := range servers {
+			if !ban.Matches(server.PassID, server.Host, server.Port) {
+				continue
+			}
+
+			if removed, err := r.registeredServerStore.RemoveServer(server.PassID); err != nil {
+				logger.Error("Could not expire banned server", logging.PassID(server.PassID), logging.Err(err))
+			} else {
+				events.Publish(events.Event{Type: events.ServerExpired, Origin: removed.Origin, Server: removed})
+			}
+		}
+	}
+
+	if !r.commitConfigChange(w, request, r.syncModeration) {
+		return
+	}
+
+	logger.Info("Added ban", "ban_id", ban.ID, "reason", ban.Reason, logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusCreated, newBanDocument(ban))
+}
+
+func (r *RestService) removeBan(w http.ResponseWriter, request *http.Request) {
+
+	id, err := parseID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	if _, err := r.banStore.GetBan(id); errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.banStore.RemoveBan(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncModeration) {
+		return
+	}
+
+	logger.Info("Removed ban", "ban_id", id, logging.RemoteAddr(request.RemoteAddr))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"net/http"
+)
+
+var (
+	//go:embed openapi.yaml
+	openApiResource string
+)
+
+func (r *RestService) getOpenApiYaml(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(openApiResource))
+}
+
+func (r *RestService) getOpenApiJson(w http.ResponseWriter, request *http.Request) {
+	var document any
+
+	if err := yaml.Unmarshal([]byte(openApiResource), &document); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(document)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(jsonResponse)
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"magnetron/internal/events"
+	"magnetron/internal/listing"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exampleSchemas names the schema each example document in docs/api follows.
+var exampleSchemas = map[string]string{
+	"registered_servers_response.json": "RegisteredServers",
+}
+
+func loadOpenApiSchemas(t *testing.T) map[string]any {
+	t.Helper()
+
+	var document struct {
+		Components struct {
+			Schemas map[string]any `yaml:"schemas"`
+		} `yaml:"components"`
+	}
+
+	if err := yaml.Unmarshal([]byte(openApiResource), &document); err != nil {
+		t.Fatalf("could not parse OpenAPI document: %s", err)
+	}
+
+	return document.Components.Schemas
+}
+
+// TestOpenApiDescribesRoutes verifies that the OpenAPI document describes every route and nothing else, along with
+// the scope each route requires.
+func TestOpenApiDescribesRoutes(t *testing.T) {
+	var document struct {
+		Paths map[string]map[string]struct {
+			Scope Scope `yaml:"x-required-scope"`
+		} `yaml:"paths"`
+	}
+
+	if err := yaml.Unmarshal([]byte(openApiResource), &document); err != nil {
+		t.Fatalf("could not parse OpenAPI document: %s", err)
+	}
+
+	documented := make(map[string]Scope)
+
+	for path, operations := range document.Paths {
+		for method, operation := range operations {
+			documented[strings.ToUpper(method)+" "+path] = operation.Scope
+		}
+	}
+
+	served := make(map[string]bool)
+
+	for _, route := range (&RestService{}).routes() {
+		served[route.pattern] = true
+
+		if scope, ok := documented[route.pattern]; !ok {
+			t.Errorf("undocumented route %s", route.pattern)
+		} else if scope != route.scope {
+			t.Errorf("route %s requires scope %s but is documented with %q", route.pattern, route.scope, scope)
+		}
+	}
+
+	for pattern := range documented {
+		if !served[pattern] {
+			t.Errorf("documented route without handler %s", pattern)
+		}
+	}
+}
+
+// TestOpenApiExamples validates the example documents in docs/api against the schemas they follow.
+func TestOpenApiExamples(t *testing.T) {
+	schemas := loadOpenApiSchemas(t)
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "docs", "api", "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no example documents found: %v", err)
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+
+		schema, ok := exampleSchemas[name]
+		if !ok {
+			t.Errorf("example %s does not name its schema in exampleSchemas", name)
+			continue
+		}
+
+		example, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, problem := range validateDocument(schemas, schema, example) {
+			t.Errorf("%s: %s", name, problem)
+		}
+	}
+}
+
+// TestOpenApiResponseDocuments validates the documents the handlers respond with against the schemas describing
+// them, so that fields added on either side are noticed. Every field is set so that none is omitted.
+func TestOpenApiResponseDocuments(t *testing.T) {
+	schemas := loadOpenApiSchemas(t)
+
+	now := time.Date(2025, 4, 15, 12, 0, 0, 0, time.UTC)
+	page := PageDocument{Total: 1, Offset: 0, Limit: 50}
+
+	documents := map[string]any{
+		"Listing": ListingDocument{Rows: []ListingRowDocument{
+			{Kind: listing.RowKind("registered"), Name: "Server", Description: "Description", Host: "192.0.2.10", Port: 5500, UserCount: 2},
+		}},
+		"StaticServers": StaticServersDocument{Servers: []StaticServerDocument{
+			{ID: 1, Name: "Server", Host: "192.0.2.10", Port: 5500, Description: "Description", UserCount: 2},
+		}, PageDocument: page},
+		"RegisteredServers": RegisteredServersDocument{Servers: []RegisteredServerDocument{
+			{PassID: 1, Name: "Server", Host: "192.0.2.10", Port: 5500, Description: "Description", UserCount: 2,
+				FirstSeen: now, LastSeen: now, Pinned: true, Overridden: true, PasswordEntry: "Acme", Verified: true},
+		}, PageDocument: page},
+		"FederatedServers": FederatedServersDocument{Servers: []FederatedServerDocument{
+			{Name: "Server", Host: "192.0.2.10", Port: 5500, Description: "Description", UserCount: 2,
+				TrackerHost: "192.0.2.20", TrackerPort: 5498, FirstSeen: now, LastSeen: now},
+		}, PageDocument: page},
+		"FederatedTrackers": FederatedTrackersDocument{Trackers: []FederatedTrackerDocument{
+			{ID: 1, Name: "Tracker", Host: "192.0.2.20", Port: 5498, Description: "Description", UserCount: 2,
+				FirstSeen: now, LastSeen: now, Tls: true, TlsServerName: "tracker.example.com", TlsCAFile: "ca.pem"},
+		}, PageDocument: page},
+		"CandidateTrackers": CandidateTrackersDocument{Trackers: []CandidateTrackerDocument{
+			{Host: "192.0.2.20", Port: 5498, ListedName: "Tracker", ServerCount: 3, Depth: 1,
+				DiscoveredVia: "192.0.2.30:5498", FirstSeen: now, LastSeen: now},
+		}, PageDocument: page},
+		"Bans": BansDocument{Bans: []BanDocument{
+			{ID: 1, PassID: 1, Address: "192.0.2.10:5500", IP: "192.0.2.10", Reason: "Spam", CreatedAt: now},
+		}},
+		"Overrides": OverridesDocument{Overrides: []OverrideDocument{
+			{PassID: 1, Name: "Server", Description: "Description", Pinned: true},
+		}},
+		"ServerHistory": ServerHistoryDocument{PassID: 1, Name: "Server", Since: now, UptimePercent: 99.5,
+			Points:      []HistoryPointDocument{{Time: now, Resolution: "5m0s", MinUsers: 1, MaxUsers: 3, AvgUsers: 2}},
+			Transitions: []TransitionDocument{{Time: now, Online: true}}},
+		"ServerUptimes": ServerUptimesDocument{Since: now, Servers: []ServerUptimeDocument{
+			{PassID: 1, Name: "Server", UptimePercent: 99.5, OnlineSeconds: 3600, ObservedSeconds: 3700, AvgUsers: 2, MaxUsers: 3},
+		}, PageDocument: page},
+		"TrackerHistory": TrackerHistoryDocument{Since: now,
+			Peak:   TrackerPeriodDocument{Start: now, MaxUsers: 3, AvgUsers: 2, MaxServers: 2, AvgServers: 1.5, PeakUsersAt: &now},
+			Daily:  []TrackerPeriodDocument{{Start: now, MaxUsers: 3, AvgUsers: 2, MaxServers: 2, AvgServers: 1.5, PeakUsersAt: &now}},
+			Weekly: []TrackerPeriodDocument{{Start: now, MaxUsers: 3, AvgUsers: 2, MaxServers: 2, AvgServers: 1.5, PeakUsersAt: &now}}},
+		"Session": SessionDocument{TokenAuth: true, Name: "admin", CertificateSubject: "CN=admin",
+			Scopes: []Scope{ScopeReadServers}, Expiry: &now},
+		"Tokens": TokensDocument{Enabled: true, Tokens: []TokenDocument{
+			{Name: "admin", Description: "Description", Scopes: []string{"*"}, CertificateSubjects: []string{"CN=admin"},
+				CertificateOnly: true, CreatedAt: &now, Expiry: &now, Expired: true},
+		}},
+		"Webhooks": WebhooksDocument{Enabled: true, Webhooks: []WebhookDocument{
+			{Name: "hook", URL: "https://example.com/hook", Events: []events.EventType{events.ServerRegistered},
+				MinUsers: 1, Signed: true, Pending: 1, Delivered: 2, Failed: 3},
+		}},
+		"WebhookDeliveries": WebhookDeliveriesDocument{Deliveries: []WebhookDeliveryDocument{
+			{ID: 1, Hook: "hook", EventID: 1, EventType: string(events.ServerRegistered), Status: "pending", Attempts: 1,
+				CreatedAt: now, NextAttempt: &now, LastAttempt: &now, LastStatusCode: 500, LastError: "error",
+				DeliveredAt: &now, Payload: "{}"},
+		}, PageDocument: page},
+	}
+
+	for schema, document := range documents {
+		encoded, err := json.Marshal(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, problem := range validateDocument(schemas, schema, encoded) {
+			t.Errorf("%s: %s", schema, problem)
+		}
+	}
+}
+
+// validateDocument checks a JSON document against a schema of the OpenAPI document. It understands the subset of
+// JSON Schema the document uses: $ref, allOf, type, format date-time, enum, required, properties and items.
+// Properties that are not described are reported too.
+func validateDocument(schemas map[string]any, name string, document []byte) []string {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("could not parse document: %s", err)}
+	}
+
+	v := schemaValidator{schemas: schemas}
+	v.validate(map[string]any{"$ref": "#/components/schemas/" + name}, value, "$")
+
+	return v.problems
+}
+
+type schemaValidator struct {
+	schemas  map[string]any
+	problems []string
+}
+
+func (v *schemaValidator) report(path string, format string, args ...any) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// resolve follows $ref and merges allOf into a single schema.
+func (v *schemaValidator) resolve(schema map[string]any) map[string]any {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, _ := v.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any)
+		if resolved == nil {
+			v.report(ref, "unknown schema")
+			return map[string]any{}
+		}
+		return v.resolve(resolved)
+	}
+
+	parts, ok := schema["allOf"].([]any)
+	if !ok {
+		return schema
+	}
+
+	merged := map[string]any{"type": "object"}
+	properties := map[string]any{}
+	var required []any
+
+	for _, part := range parts {
+		partSchema, _ := part.(map[string]any)
+		resolved := v.resolve(partSchema)
+
+		if partProperties, ok := resolved["properties"].(map[string]any); ok {
+			for name, property := range partProperties {
+				properties[name] = property
+			}
+		}
+		if partRequired, ok := resolved["required"].([]any); ok {
+			required = append(required, partRequired...)
+		}
+	}
+
+	merged["properties"] = properties
+	merged["required"] = required
+
+	return merged
+}
+
+func (v *schemaValidator) validate(schema map[string]any, value any, path string) {
+	schema = v.resolve(schema)
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			v.report(path, "expected an object")
+			return
+		}
+
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				v.report(path, "missing required property %s", name)
+			}
+		}
+
+		properties, ok := schema["properties"].(map[string]any)
+		if !ok {
+			return
+		}
+
+		for name, property := range object {
+			propertySchema, ok := properties[name].(map[string]any)
+			if !ok {
+				v.report(path, "undocumented property %s", name)
+				continue
+			}
+			v.validate(propertySchema, property, path+"."+name)
+		}
+
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			v.report(path, "expected an array")
+			return
+		}
+
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range array {
+			if items != nil {
+				v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			v.report(path, "expected a string")
+			return
+		}
+
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				v.report(path, "expected a date-time: %s", err)
+			}
+		}
+
+	case "integer":
+		number, ok := value.(json.Number)
+		if _, err := number.Int64(); !ok || err != nil {
+			v.report(path, "expected an integer")
+		}
+
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			v.report(path, "expected a number")
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.report(path, "expected a boolean")
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		v.report(path, "%v is not one of %v", value, enum)
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxListLimit = 1000
+)
+
+// PageDocument describes which part of a filtered collection a list response contains.
+type PageDocument struct {
+	Total  int `json:"total"`           // Number of items that matched the filters
+	Offset int `json:"offset"`          // Index of the first returned item amongst the matches
+	Limit  int `json:"limit,omitempty"` // Maximum number of items returned, omitted when unlimited
+}
+
+// ListQuery holds the filtering, sorting and pagination parameters accepted by the list endpoints.
+type ListQuery struct {
+	Search   string // q: case-insensitive substring of the name or description
+	MinUsers uint16 // minUsers: lowest user count to include
+	Tracker  string // tracker: host or host:port of the federated tracker the item belongs to
+	Sort     string // sort: field to sort by, empty to keep the listing order
+	Desc     bool   // order: "asc" (default) or "desc"
+	Limit    int    // limit: maximum number of items, zero for no limit
+	Offset   int    // offset: number of matching items to skip
+}
+
+// listSpec describes how a document type is searched, filtered and sorted.
+type listSpec[T any] struct {
+	text    func(T) []string
+	users   func(T) uint16
+	tracker func(T) (string, uint16)
+	sorts   map[string]func(a T, b T) int
+}
+
+func parseListQuery[T any](request *http.Request, spec listSpec[T]) (ListQuery, error) {
+	values := request.URL.Query()
+
+	query := ListQuery{
+		Search:  strings.ToLower(values.Get("q")),
+		Tracker: values.Get("tracker"),
+		Sort:    values.Get("sort"),
+	}
+
+	if minUsers := values.Get("minUsers"); minUsers != "" {
+		parsed, err := strconv.ParseUint(minUsers, 10, 16)
+		if err != nil {
+			return query, fmt.Errorf("invalid minUsers: %s", minUsers)
+		}
+		query.MinUsers = uint16(parsed)
+	}
+
+	if query.Tracker != "" && spec.tracker == nil {
+		return query, fmt.Errorf("tracker filter is not supported by this endpoint")
+	}
+
+	if query.Sort != "" {
+		if _, ok := spec.sorts[query.Sort]; !ok {
+			return query, fmt.Errorf("invalid sort field: %s", query.Sort)
+		}
+	}
+
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("invalid order: %s", order)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 0 || parsed > maxListLimit {
+			return query, fmt.Errorf("limit must be between 0 and %d: %s", maxListLimit, limit)
+		}
+		query.Limit = parsed
+	}
+
+	if offset := values.Get("offset"); offset != "" {
+		parsed, err := strconv.Atoi(offset)
+		if err != nil || parsed < 0 {
+			return query, fmt.Errorf("invalid offset: %s", offset)
+		}
+		query.Offset = parsed
+	}
+
+	return query, nil
+}
+
+// applyListQuery filters, sorts and paginates items, returning the requested page and a description of it.
+func applyListQuery[T any](items []T, query ListQuery, spec listSpec[T]) ([]T, PageDocument) {
+	matches := make([]T, 0, len(items))
+
+	for _, item := range items {
+		if query.Search != "" && !containsSearch(spec.text(item), query.Search) {
+			continue
+		}
+
+		if spec.users != nil && spec.users(item) < query.MinUsers {
+			continue
+		}
+
+		if query.Tracker != "" && !matchesTracker(spec.tracker, item, query.Tracker) {
+			continue
+		}
+
+		matches = append(matches, item)
+	}
+
+	if query.Sort != "" {
+		compare := spec.sorts[query.Sort]
+
+		slices.SortStableFunc(matches, func(a T, b T) int {
+			if query.Desc {
+				return compare(b, a)
+			}
+			return compare(a, b)
+		})
+	}
+
+	page := PageDocument{
+		Total:  len(matches),
+		Offset: query.Offset,
+		Limit:  query.Limit,
+	}
+
+	start := min(query.Offset, len(matches))
+	end := len(matches)
+
+	if query.Limit > 0 {
+		end = min(start+query.Limit, end)
+	}
+
+	return matches[start:end], page
+}
+
+func containsSearch(texts []string, search string) bool {
+	for _, text := range texts {
+		if strings.Contains(strings.ToLower(text), search) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesTracker[T any](tracker func(T) (string, uint16), item T, filter string) bool {
+	host, port := tracker(item)
+
+	if filterHost, filterPort, err := net.SplitHostPort(filter); err == nil {
+		return filterHost == host && filterPort == strconv.Itoa(int(port))
+	}
+
+	return filter == host
+}
+
+func compareStrings(a string, b string) int {
+	return cmp.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	return a.Compare(b)
+}
+
+var staticServerListSpec = listSpec[StaticServerDocument]{
+	text:  func(d StaticServerDocument) []string { return []string{d.Name, d.Description} },
+	users: func(d StaticServerDocument) uint16 { return d.UserCount },
+	sorts: map[string]func(a StaticServerDocument, b StaticServerDocument) int{
+		"name":      func(a, b StaticServerDocument) int { return compareStrings(a.Name, b.Name) },
+		"host":      func(a, b StaticServerDocument) int { return compareStrings(a.Host, b.Host) },
+		"userCount": func(a, b StaticServerDocument) int { return cmp.Compare(a.UserCount, b.UserCount) },
+	},
+}
+
+var registeredServerListSpec = listSpec[RegisteredServerDocument]{
+	text:  func(d RegisteredServerDocument) []string { return []string{d.Name, d.Description} },
+	users: func(d RegisteredServerDocument) uint16 { return d.UserCount },
+	sorts: map[string]func(a RegisteredServerDocument, b RegisteredServerDocument) int{
+		"name":      func(a, b RegisteredServerDocument) int { return compareStrings(a.Name, b.Name) },
+		"host":      func(a, b RegisteredServerDocument) int { return compareStrings(a.Host, b.Host) },
+		"userCount": func(a, b RegisteredServerDocument) int { return cmp.Compare(a.UserCount, b.UserCount) },
+		"firstSeen": func(a, b RegisteredServerDocument) int { return compareTimes(a.FirstSeen, b.FirstSeen) },
+		"lastSeen":  func(a, b RegisteredServerDocument) int { return compareTimes(a.LastSeen, b.LastSeen) },
+	},
+}
+
+var federatedServerListSpec = listSpec[FederatedServerDocument]{
+	text:    func(d FederatedServerDocument) []string { return []string{d.Name, d.Description} },
+	users:   func(d FederatedServerDocument) uint16 { return d.UserCount },
+	tracker: func(d FederatedServerDocument) (string, uint16) { return d.TrackerHost, d.TrackerPort },
+	sorts: map[string]func(a FederatedServerDocument, b FederatedServerDocument) int{
+		"name":      func(a, b FederatedServerDocument) int { return compareStrings(a.Name, b.Name) },
+		"host":      func(a, b FederatedServerDocument) int { return compareStrings(a.Host, b.Host) },
+		"userCount": func(a, b FederatedServerDocument) int { return cmp.Compare(a.UserCount, b.UserCount) },
+		"firstSeen": func(a, b FederatedServerDocument) int { return compareTimes(a.FirstSeen, b.FirstSeen) },
+		"lastSeen":  func(a, b FederatedServerDocument) int { return compareTimes(a.LastSeen, b.LastSeen) },
+	},
+}
+
+var federatedTrackerListSpec = listSpec[FederatedTrackerDocument]{
+	text:    func(d FederatedTrackerDocument) []string { return []string{d.Name, d.Description} },
+	users:   func(d FederatedTrackerDocument) uint16 { return d.UserCount },
+	tracker: func(d FederatedTrackerDocument) (string, uint16) { return d.Host, d.Port },
+	sorts: map[string]func(a FederatedTrackerDocument, b FederatedTrackerDocument) int{
+		"name":      func(a, b FederatedTrackerDocument) int { return compareStrings(a.Name, b.Name) },
+		"host":      func(a, b FederatedTrackerDocument) int { return compareStrings(a.Host, b.Host) },
+		"userCount": func(a, b FederatedTrackerDocument) int { return cmp.Compare(a.UserCount, b.UserCount) },
+		"firstSeen": func(a, b FederatedTrackerDocument) int { return compareTimes(a.FirstSeen, b.FirstSeen) },
+		"lastSeen":  func(a, b FederatedTrackerDocument) int { return compareTimes(a.LastSeen, b.LastSeen) },
+	},
+}
+
+var candidateTrackerListSpec = listSpec[CandidateTrackerDocument]{
+	text: func(d CandidateTrackerDocument) []string { return []string{d.ListedName, d.Host} },
+	sorts: map[string]func(a CandidateTrackerDocument, b CandidateTrackerDocument) int{
+		"name":        func(a, b CandidateTrackerDocument) int { return compareStrings(a.ListedName, b.ListedName) },
+		"host":        func(a, b CandidateTrackerDocument) int { return compareStrings(a.Host, b.Host) },
+		"serverCount": func(a, b CandidateTrackerDocument) int { return cmp.Compare(a.ServerCount, b.ServerCount) },
+		"depth":       func(a, b CandidateTrackerDocument) int { return cmp.Compare(a.Depth, b.Depth) },
+		"firstSeen":   func(a, b CandidateTrackerDocument) int { return compareTimes(a.FirstSeen, b.FirstSeen) },
+		"lastSeen":    func(a, b CandidateTrackerDocument) int { return compareTimes(a.LastSeen, b.LastSeen) },
+	},
+}
+
+// webhookDeliveryListSpec only validates delivery queries, as deliveries are searched, sorted and paged by the
+// database. Searches match the webhook, event type and last error.
+var webhookDeliveryListSpec = listSpec[WebhookDeliveryDocument]{
+	sorts: map[string]func(a WebhookDeliveryDocument, b WebhookDeliveryDocument) int{
+		"createdAt": nil,
+		"attempts":  nil,
+	},
+}
+
+// deliverySortColumns names the column each sort field of webhookDeliveryListSpec sorts deliveries by.
+var deliverySortColumns = map[string]string{
+	"createdAt": "created_at",
+	"attempts":  "attempts",
+}
+
+var serverUptimeListSpec = listSpec[ServerUptimeDocument]{
+	text:  func(d ServerUptimeDocument) []string { return []string{d.Name} },
+	users: func(d ServerUptimeDocument) uint16 { return d.MaxUsers },
+	sorts: map[string]func(a ServerUptimeDocument, b ServerUptimeDocument) int{
+		"name":     func(a, b ServerUptimeDocument) int { return compareStrings(a.Name, b.Name) },
+		"uptime":   func(a, b ServerUptimeDocument) int { return cmp.Compare(a.UptimePercent, b.UptimePercent) },
+		"avgUsers": func(a, b ServerUptimeDocument) int { return cmp.Compare(a.AvgUsers, b.AvgUsers) },
+		"maxUsers": func(a, b ServerUptimeDocument) int { return cmp.Compare(a.MaxUsers, b.MaxUsers) },
+	},
+}
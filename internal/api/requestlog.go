@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"magnetron/internal/logging"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var logger = logging.For(logging.API)
+
+// statusRecorder remembers the status a handler responded with, for the request log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps event streams working through the recorder.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+type principalContextKey struct{}
+
+// requestPrincipal records who a request was authenticated as. The authentication middleware fills it in, so that
+// the request log, which wraps the middleware, can name them.
+type requestPrincipal struct {
+	tokenName          string
+	certificateSubject string
+}
+
+// logRequests logs every request at debug level, including those refused by authentication. Requests that may
+// change the tracker are also logged as audit records, naming the token or client certificate they were made with.
+func logRequests(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		startedAt := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		principal := &requestPrincipal{}
+
+		next.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), principalContextKey{}, principal)))
+
+		attrs := []any{
+			"method", request.Method,
+			"path", request.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(startedAt),
+			"token_name", principal.tokenName,
+			"certificate_subject", principal.certificateSubject,
+			logging.RemoteAddr(request.RemoteAddr),
+		}
+
+		logger.Debug("Served request", attrs...)
+
+		if request.Method != http.MethodGet && request.Method != http.MethodHead && recorder.status < http.StatusBadRequest {
+			logger.Info("Changed tracker through REST", append([]any{"audit", true}, attrs...)...)
+		}
+	}
+}
+
+func trackerAddress(host string, port uint16) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
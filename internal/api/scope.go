@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Scope is a permission a token must be granted to call a route.
+type Scope string
+
+const (
+	ScopeReadServers   Scope = "read:servers"   // Listings and every server and tracker collection
+	ScopeReadHistory   Scope = "read:history"   // User count and uptime history
+	ScopeReadEvents    Scope = "read:events"    // The event stream
+	ScopeReadMetrics   Scope = "read:metrics"   // Prometheus metrics served by the REST listener
+	ScopeReadBans      Scope = "read:bans"      // Bans
+	ScopeReadWebhooks  Scope = "read:webhooks"  // Webhooks and their deliveries
+	ScopeReadTokens    Scope = "read:tokens"    // Names, scopes and expiry of API tokens, never the tokens themselves
+	ScopeAdminServers  Scope = "admin:servers"  // Static entries, expiring registered servers and listing overrides
+	ScopeAdminBans     Scope = "admin:bans"     // Adding and removing bans
+	ScopeAdminConfig   Scope = "admin:config"   // Federated trackers and promoting candidates into the federation
+	ScopeAdminWebhooks Scope = "admin:webhooks" // Retrying webhook deliveries
+
+	// ScopeAnyToken is declared by routes that any valid token may call. It cannot be granted.
+	ScopeAnyToken Scope = "any"
+)
+
+// Scopes lists every scope a token can be granted, besides the wildcards *, read:* and admin:*.
+var Scopes = []Scope{
+	ScopeReadServers, ScopeReadHistory, ScopeReadEvents, ScopeReadMetrics, ScopeReadBans, ScopeReadWebhooks,
+	ScopeReadTokens, ScopeAdminServers, ScopeAdminBans, ScopeAdminConfig, ScopeAdminWebhooks,
+}
+
+var scopeWildcards = []string{"*", "read:*", "admin:*"}
+
+// IsAdmin reports whether the scope allows changing the tracker.
+func (s Scope) IsAdmin() bool {
+	return strings.HasPrefix(string(s), "admin:")
+}
+
+func validateScope(scope string) error {
+	if slices.Contains(Scopes, Scope(scope)) || slices.Contains(scopeWildcards, scope) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown scope %s", scope)
+}
+
+// grants reports whether the granted scopes allow a route requiring the given scope. Wildcards grant every scope
+// of their kind, and an admin scope also grants reading what it administers.
+func grants(granted []string, required Scope) bool {
+	if required == ScopeAnyToken {
+		return true
+	}
+
+	kind, resource, _ := strings.Cut(string(required), ":")
+
+	for _, scope := range granted {
+		switch scope {
+		case "*", kind + ":*", string(required):
+			return true
+		case "admin:" + resource:
+			if kind == "read" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestRouteScopes verifies that every route declares a scope it can be granted, and that only admin scopes allow
+// changing the tracker.
+func TestRouteScopes(t *testing.T) {
+	for _, route := range (&RestService{}).routes() {
+		method, _, _ := strings.Cut(route.pattern, " ")
+
+		switch {
+		case route.scope == "":
+			t.Errorf("route without scope %s", route.pattern)
+		case route.scope != ScopeAnyToken && !slices.Contains(Scopes, route.scope):
+			t.Errorf("route with unknown scope %s %s", route.scope, route.pattern)
+		case method != http.MethodGet && !route.scope.IsAdmin():
+			t.Errorf("route %s changes the tracker but requires %s instead of an admin scope", route.pattern, route.scope)
+		}
+	}
+}
+
+func TestGrants(t *testing.T) {
+	tests := []struct {
+		granted  []string
+		required Scope
+		want     bool
+	}{
+		{nil, ScopeAnyToken, true},
+		{nil, ScopeReadServers, false},
+		{[]string{"*"}, ScopeAdminConfig, true},
+		{[]string{"read:*"}, ScopeReadBans, true},
+		{[]string{"read:*"}, ScopeAdminBans, false},
+		{[]string{"admin:*"}, ScopeAdminWebhooks, true},
+		{[]string{"admin:bans"}, ScopeReadBans, true},
+		{[]string{"read:bans"}, ScopeAdminBans, false},
+		{[]string{"admin:bans"}, ScopeReadServers, false},
+		{[]string{"read:servers"}, ScopeReadServers, true},
+	}
+
+	for _, test := range tests {
+		if got := grants(test.granted, test.required); got != test.want {
+			t.Errorf("grants(%v, %s) = %v, want %v", test.granted, test.required, got, test.want)
+		}
+	}
+}
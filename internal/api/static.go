@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"magnetron/internal/db"
+	"magnetron/internal/logging"
+	"net"
+	"net/http"
+)
+
+func newStaticServerDocument(server db.StaticServer) StaticServerDocument {
+	return StaticServerDocument{
+		ID:          server.ID,
+		Name:        server.Name,
+		Host:        server.Host,
+		Port:        server.Port,
+		Description: server.Description,
+		UserCount:   server.UserCount,
+	}
+}
+
+func decodeStaticServerDocument(request *http.Request) (StaticServerDocument, error) {
+	var document StaticServerDocument
+
+	if err := json.NewDecoder(request.Body).Decode(&document); err != nil {
+		return document, err
+	}
+
+	// Hotline listings only carry IPv4 addresses, so static entries cannot use host names.
+	if ip := net.ParseIP(document.Host); ip == nil || ip.To4() == nil {
+		return document, fmt.Errorf("host must be a valid IPv4 address: %s", document.Host)
+	}
+
+	if len(document.Name) > 255 || len(document.Description) > 255 {
+		return document, fmt.Errorf("name and description must be at most 255 bytes")
+	}
+
+	return document, nil
+}
+
+func (r *RestService) createStaticServer(w http.ResponseWriter, request *http.Request) {
+
+	document, err := decodeStaticServerDocument(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	servers, err := r.staticServerStore.GetStaticServers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	server, err := r.staticServerStore.RegisterStaticServer(document.Host, document.Port, document.Name, document.Description, document.UserCount, uint16(len(servers)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncStaticEntries) {
+		return
+	}
+
+	logger.Info("Added static server", logging.ServerName(server.Name), "host", server.Host, "port", server.Port, logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusCreated, newStaticServerDocument(server))
+}
+
+func (r *RestService) updateStaticServer(w http.ResponseWriter, request *http.Request) {
+
+	id, err := parseID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	document, err := decodeStaticServerDocument(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	if _, err := r.staticServerStore.GetStaticServer(id); errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.staticServerStore.UpdateStaticServer(id, document.Host, document.Port, document.Name, document.Description, document.UserCount); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncStaticEntries) {
+		return
+	}
+
+	server, err := r.staticServerStore.GetStaticServer(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Updated static server", logging.ServerName(server.Name), "host", server.Host, "port", server.Port, logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusOK, newStaticServerDocument(server))
+}
+
+func (r *RestService) deleteStaticServer(w http.ResponseWriter, request *http.Request) {
+
+	id, err := parseID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	server, err := r.staticServerStore.GetStaticServer(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.staticServerStore.RemoveStaticServer(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	remainingServers, err := r.staticServerStore.GetStaticServers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	remainingIDs := make([]uint, 0, len(remainingServers))
+	for _, remainingServer := range remainingServers {
+		remainingIDs = append(remainingIDs, remainingServer.ID)
+	}
+
+	if err := r.staticServerStore.SetStaticServerOrder(remainingIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncStaticEntries) {
+		return
+	}
+
+	logger.Info("Removed static server", logging.ServerName(server.Name), "host", server.Host, "port", server.Port, logging.RemoteAddr(request.RemoteAddr))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *RestService) reorderStaticServers(w http.ResponseWriter, request *http.Request) {
+
+	var reorderRequest ReorderRequest
+
+	if err := json.NewDecoder(request.Body).Decode(&reorderRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	servers, err := r.staticServerStore.GetStaticServers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	existingIDs := make([]uint, 0, len(servers))
+	for _, server := range servers {
+		existingIDs = append(existingIDs, server.ID)
+	}
+
+	if !isPermutation(reorderRequest.IDs, existingIDs) {
+		http.Error(w, "ids must list every static server exactly once", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.staticServerStore.SetStaticServerOrder(reorderRequest.IDs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncStaticEntries) {
+		return
+	}
+
+	r.getStaticServers(w, request)
+}
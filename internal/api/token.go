@@ -1,19 +1,33 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"gopkg.in/yaml.v3"
-	"log"
 	"os"
+	"strings"
 	"time"
 )
 
+const (
+	tokenPrefix     = "mgt_"    // Makes tokens recognisable, e.g. to secret scanners
+	tokenHashPrefix = "sha256:" // Algorithm of TokenEntry.TokenHash
+	tokenBytes      = 32
+)
+
 type TokenEntry struct {
-	Name        string    `yaml:"Name"`
-	Description string    `yaml:"description"`
-	Token       string    `yaml:"Token"`
-	Expiry      time.Time `yaml:"Expiry"`
+	Name                string    `yaml:"Name"`                          // Unique name, used to revoke or rotate the token
+	Description         string    `yaml:"Description"`                   // A helpful description
+	TokenHash           string    `yaml:"TokenHash,omitempty"`           // sha256: followed by the hex SHA-256 of the token
+	Scopes              []string  `yaml:"Scopes,omitempty"`              // Scopes granted to the token, every scope when empty for older token files
+	Token               string    `yaml:"Token,omitempty"`               // Deprecated plain text token, replaced by TokenHash when rotated
+	CertificateSubjects []string  `yaml:"CertificateSubjects,omitempty"` // Subjects of client certificates authenticated as this entry, e.g. CN=backup,O=Acme
+	CreatedAt           time.Time `yaml:"CreatedAt,omitempty"`           // When the token was created or last rotated
+	Expiry              time.Time `yaml:"Expiry,omitempty"`              // When the token stops being accepted, never when empty
 }
 
 var (
@@ -25,64 +39,170 @@ type TokenConfig struct {
 	TokenEntries []TokenEntry `yaml:"TokenEntries"`
 }
 
-func GetDefaultTokenConfig() *TokenConfig {
+func GetDefaultTokenConfig() (*TokenConfig, error) {
 	var cfg TokenConfig
 
-	err := yaml.Unmarshal([]byte(defaultTokensResource), &cfg)
-	if err != nil {
-		log.Fatal("error while getting default token configuration.", err)
+	if err := yaml.Unmarshal([]byte(defaultTokensResource), &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse default token file: %s", err)
 	}
 
-	return &cfg
+	return &cfg, nil
 }
 
-func ReadTokenConfigFile(path string) *TokenConfig {
+// LoadTokenConfigFile reads and validates a token file.
+func LoadTokenConfigFile(path string) (*TokenConfig, error) {
 	var cfg TokenConfig
 
 	configYaml, err := os.ReadFile(path)
-
 	if err != nil {
-		log.Fatal("Could not load yaml token config file: ", path, err)
+		return nil, fmt.Errorf("could not load token file: %s", err)
 	}
 
-	err = yaml.Unmarshal(configYaml, &cfg)
-	if err != nil {
-		log.Fatal("Error while getting parsing yaml token configuration", err)
+	if err := yaml.Unmarshal(configYaml, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse token file %s: %s", path, err)
+	}
+
+	if errs := cfg.Validate(); len(errs) > 0 {
+		messages := make([]string, 0, len(errs))
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return nil, fmt.Errorf("invalid token file %s: %s", path, strings.Join(messages, "; "))
 	}
-	return &cfg
+
+	return &cfg, nil
 }
 
-func (c *TokenConfig) Validate() {
+func (c *TokenConfig) Validate() []error {
 	var errors []error
 
-	if len(c.TokenEntries) == 0 {
-		errors = append(errors, fmt.Errorf("token configuration is missing token entries"))
-	}
+	names := make(map[string]bool)
+	subjects := make(map[string]bool)
 
 	for _, entry := range c.TokenEntries {
-		if entry.Token == "" {
-			errors = append(errors, fmt.Errorf("token entry is missing token (%s)", entry.Name))
+		if entry.Name == "" {
+			errors = append(errors, fmt.Errorf("token entry is missing a name"))
+		} else if names[entry.Name] {
+			errors = append(errors, fmt.Errorf("token name is used more than once (%s)", entry.Name))
+		}
+		names[entry.Name] = true
+
+		for _, scope := range entry.Scopes {
+			if err := validateScope(scope); err != nil {
+				errors = append(errors, fmt.Errorf("%s (%s)", err, entry.Name))
+			}
+		}
+
+		for _, subject := range entry.CertificateSubjects {
+			if subject == "" {
+				errors = append(errors, fmt.Errorf("token entry has an empty certificate subject (%s)", entry.Name))
+			} else if subjects[subject] {
+				errors = append(errors, fmt.Errorf("certificate subject is used by more than one token entry (%s)", subject))
+			}
+			subjects[subject] = true
+		}
+
+		// Entries only used with client certificates have no token.
+		if entry.TokenHash == "" && entry.Token == "" && len(entry.CertificateSubjects) == 0 {
+			errors = append(errors, fmt.Errorf("token entry is missing a token hash or certificate subject (%s)", entry.Name))
+		} else if entry.TokenHash != "" {
+			if _, err := decodeTokenHash(entry.TokenHash); err != nil {
+				errors = append(errors, fmt.Errorf("%s (%s)", err, entry.Name))
+			}
 		}
 	}
 
-	if len(errors) > 0 {
-		for _, err := range errors {
-			log.Println(err)
+	return errors
+}
+
+// FindEntry returns the index of the named token entry, or -1.
+func (c *TokenConfig) FindEntry(name string) int {
+	for i, entry := range c.TokenEntries {
+		if entry.Name == name {
+			return i
 		}
+	}
+	return -1
+}
 
-		log.Fatal("Errors were found while validating the token configuration")
+// Expired reports whether the token is no longer accepted at the given time.
+func (e *TokenEntry) Expired(now time.Time) bool {
+	return !e.Expiry.IsZero() && !e.Expiry.After(now)
+}
+
+// Grants reports whether the token may call routes requiring the scope. Tokens from before scopes existed carry
+// none and keep the access they had, which was to every route.
+func (e *TokenEntry) Grants(scope Scope) bool {
+	return len(e.Scopes) == 0 || grants(e.Scopes, scope)
+}
+
+// HasToken reports whether the entry can be used as a bearer token, rather than only with client certificates.
+func (e *TokenEntry) HasToken() bool {
+	return e.TokenHash != "" || e.Token != ""
+}
+
+// hash returns the SHA-256 of the token, hashing a deprecated plain text token on the fly.
+func (e *TokenEntry) hash() [sha256.Size]byte {
+	if e.TokenHash == "" {
+		return sha256.Sum256([]byte(e.Token))
+	}
+
+	hash, _ := decodeTokenHash(e.TokenHash)
+	return hash
+}
+
+// GenerateToken returns a new random token and the hash stored for it. The token itself is never stored.
+func GenerateToken() (string, string, error) {
+	random := make([]byte, tokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(hash[:])
+}
+
+func decodeTokenHash(tokenHash string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+
+	encoded, ok := strings.CutPrefix(tokenHash, tokenHashPrefix)
+	if !ok {
+		return hash, fmt.Errorf("token hash must start with %s", tokenHashPrefix)
+	}
+
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil || len(decoded) != sha256.Size {
+		return hash, fmt.Errorf("token hash must be %d hex encoded bytes", sha256.Size)
 	}
+
+	copy(hash[:], decoded)
+	return hash, nil
 }
 
-func WriteTokenConfig(config TokenConfig, path string) {
+// SaveTokenConfig writes a token file that only its owner can read, replacing it in one step so that a tracker
+// reloading the file never reads it half written.
+func SaveTokenConfig(config TokenConfig, path string) error {
 	configYaml, err := yaml.Marshal(&config)
 	if err != nil {
-		log.Fatal("Could not marshal token config data.", err)
+		return fmt.Errorf("could not marshal token config data: %s", err)
 	}
 
-	err = os.WriteFile(path, configYaml, 0644)
+	temporary := path + ".tmp"
 
-	if err != nil {
-		log.Fatal("Could not write token config yaml to file:", path, err)
+	if err := os.WriteFile(temporary, configYaml, 0600); err != nil {
+		return fmt.Errorf("could not write token file %s: %s", path, err)
 	}
+
+	if err := os.Rename(temporary, path); err != nil {
+		os.Remove(temporary)
+		return fmt.Errorf("could not write token file %s: %s", path, err)
+	}
+
+	return nil
 }
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"magnetron/internal/logging"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const tokenReloadInterval = 5 * time.Second
+
+type tokenContextKey struct{}
+
+// tokenSet keeps the token file in memory and reloads it when it changes, so that tokens created or revoked with
+// the token command apply without a restart and requests never read the file.
+type tokenSet struct {
+	path     string
+	mu       sync.RWMutex
+	entries  []TokenEntry
+	hashes   [][sha256.Size]byte
+	subjects map[string]int // Index of the entry of every client certificate subject
+	modTime  time.Time
+	size     int64
+}
+
+func newTokenSet(path string) (*tokenSet, error) {
+	set := &tokenSet{path: path}
+
+	if err := set.reload(); err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// watch reloads the token file whenever its modification time or size changes. A file that fails to load leaves
+// the previous tokens in place.
+func (s *tokenSet) watch() {
+	ticker := time.NewTicker(tokenReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.reload(); err != nil {
+			logger.Error("Could not reload API tokens, keeping the previous ones", logging.Err(err))
+		}
+	}
+}
+
+func (s *tokenSet) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	unchanged := info.ModTime().Equal(s.modTime) && info.Size() == s.size
+	// A file that failed to load is only retried once it changes again.
+	s.modTime, s.size = info.ModTime(), info.Size()
+	s.mu.Unlock()
+
+	if unchanged {
+		return nil
+	}
+
+	tokenCfg, err := LoadTokenConfigFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]TokenEntry, 0, len(tokenCfg.TokenEntries))
+	hashes := make([][sha256.Size]byte, 0, len(tokenCfg.TokenEntries))
+	subjects := make(map[string]int)
+
+	for _, entry := range tokenCfg.TokenEntries {
+		for _, subject := range entry.CertificateSubjects {
+			subjects[subject] = len(entries)
+		}
+
+		if entry.TokenHash == "" && entry.Token != "" {
+			logger.Warn("API token is stored in plain text, rotate it to store its hash instead", "token_name", entry.Name)
+		}
+		if len(entry.Scopes) == 0 {
+			logger.Warn("API token has no scopes and is granted every scope", "token_name", entry.Name)
+		}
+
+		entries = append(entries, entry)
+		hashes = append(hashes, entry.hash())
+	}
+
+	s.mu.Lock()
+	s.entries, s.hashes, s.subjects = entries, hashes, subjects
+	s.mu.Unlock()
+
+	logger.Info("Loaded API tokens", "tokens", len(entries), "file", s.path)
+
+	return nil
+}
+
+// authenticate returns the entry of an unexpired token. Every entry is compared in constant time, so the time
+// taken reveals neither the token nor which entry it matched.
+func (s *tokenSet) authenticate(token string) (TokenEntry, bool) {
+	supplied := sha256.Sum256([]byte(token))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	match := -1
+	for i := range s.hashes {
+		if subtle.ConstantTimeCompare(supplied[:], s.hashes[i][:]) == 1 && s.entries[i].HasToken() {
+			match = i
+		}
+	}
+
+	if match < 0 || s.entries[match].Expired(time.Now()) {
+		return TokenEntry{}, false
+	}
+
+	return s.entries[match], true
+}
+
+// authenticateCertificate returns the unexpired entry a verified client certificate subject is mapped to.
+func (s *tokenSet) authenticateCertificate(subject string) (TokenEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	match, ok := s.subjects[subject]
+	if !ok || s.entries[match].Expired(time.Now()) {
+		return TokenEntry{}, false
+	}
+
+	return s.entries[match], true
+}
+
+// list returns the loaded entries in the order of the token file.
+func (s *tokenSet) list() []TokenEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.entries)
+}
+
+// tokenFromContext returns the entry of the token a request was authenticated with.
+func tokenFromContext(ctx context.Context) (TokenEntry, bool) {
+	entry, ok := ctx.Value(tokenContextKey{}).(TokenEntry)
+	return entry, ok
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"magnetron/internal/db"
+	"magnetron/internal/logging"
+	"net/http"
+)
+
+func newFederatedTrackerDocument(tracker db.FederatedTracker) FederatedTrackerDocument {
+	return FederatedTrackerDocument{
+		ID:            tracker.ID,
+		Name:          tracker.Name,
+		Host:          tracker.Host,
+		Port:          tracker.Port,
+		Description:   tracker.Description,
+		UserCount:     tracker.UserCount,
+		FirstSeen:     tracker.FirstSeen,
+		LastSeen:      tracker.LastSeen,
+		Tls:           tracker.Tls.Enabled,
+		TlsServerName: tracker.Tls.ServerName,
+		TlsCAFile:     tracker.Tls.CAFile,
+		Up:            !tracker.LastPolled.IsZero() && tracker.LastPollError == "",
+		LastPolled:    tracker.LastPolled,
+		LastPollError: tracker.LastPollError,
+	}
+}
+
+func (document FederatedTrackerDocument) trackerTls() db.TrackerTls {
+	return db.TrackerTls{Enabled: document.Tls, ServerName: document.TlsServerName, CAFile: document.TlsCAFile}
+}
+
+func decodeFederatedTrackerDocument(request *http.Request) (FederatedTrackerDocument, error) {
+	var document FederatedTrackerDocument
+
+	if err := json.NewDecoder(request.Body).Decode(&document); err != nil {
+		return document, err
+	}
+
+	if document.Host == "" {
+		return document, fmt.Errorf("host is required")
+	}
+
+	if document.Port == 0 {
+		document.Port = 5498
+	}
+
+	if len(document.Name) > 255 || len(document.Description) > 255 {
+		return document, fmt.Errorf("name and description must be at most 255 bytes")
+	}
+
+	if !document.Tls && (document.TlsServerName != "" || document.TlsCAFile != "") {
+		return document, fmt.Errorf("tlsServerName and tlsCAFile require tls")
+	}
+
+	return document, nil
+}
+
+func (r *RestService) createFederatedTracker(w http.ResponseWriter, request *http.Request) {
+
+	document, err := decodeFederatedTrackerDocument(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	if _, err := r.federatedTrackerStore.GetFederatedTracker(document.Host, document.Port); err == nil {
+		http.Error(w, "tracker is already federated", http.StatusConflict)
+		return
+	}
+
+	trackers, err := r.federatedTrackerStore.GetFederatedTrackers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tracker, err := r.federatedTrackerStore.RegisterFederatedTracker(document.Host, document.Port, document.Name, document.Description, document.UserCount, uint16(len(trackers)), document.trackerTls())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncTrackerEntries) {
+		return
+	}
+
+	logger.Info("Added federated tracker", logging.Tracker(trackerAddress(tracker.Host, tracker.Port)), "name", tracker.Name, logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusCreated, newFederatedTrackerDocument(tracker))
+}
+
+func (r *RestService) updateFederatedTracker(w http.ResponseWriter, request *http.Request) {
+
+	id, err := parseID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	document, err := decodeFederatedTrackerDocument(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	existingTracker, err := r.federatedTrackerStore.GetFederatedTrackerByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.federatedTrackerStore.UpdateFederatedTracker(id, document.Host, document.Port, document.Name, document.Description, document.UserCount, document.trackerTls()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Servers listed by the old address are no longer refreshed once the tracker moves.
+	if existingTracker.Host != document.Host || existingTracker.Port != document.Port {
+		if err := r.federatedServerStore.RemoveFederatedServers(existingTracker.Host, existingTracker.Port); err != nil {
+			logger.Error("Could not remove servers of moved tracker", logging.Tracker(trackerAddress(existingTracker.Host, existingTracker.Port)), logging.Err(err))
+		}
+	}
+
+	if !r.commitConfigChange(w, request, r.syncTrackerEntries) {
+		return
+	}
+
+	tracker, err := r.federatedTrackerStore.GetFederatedTrackerByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Updated federated tracker", logging.Tracker(trackerAddress(tracker.Host, tracker.Port)), "name", tracker.Name, logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusOK, newFederatedTrackerDocument(tracker))
+}
+
+func (r *RestService) deleteFederatedTracker(w http.ResponseWriter, request *http.Request) {
+
+	id, err := parseID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	tracker, err := r.federatedTrackerStore.GetFederatedTrackerByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.federatedTrackerStore.RemoveFederatedTracker(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.federatedServerStore.RemoveFederatedServers(tracker.Host, tracker.Port); err != nil {
+		logger.Error("Could not remove servers of removed tracker", logging.Tracker(trackerAddress(tracker.Host, tracker.Port)), logging.Err(err))
+	}
+
+	remainingTrackers, err := r.federatedTrackerStore.GetFederatedTrackers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	remainingIDs := make([]uint, 0, len(remainingTrackers))
+	for _, remainingTracker := range remainingTrackers {
+		remainingIDs = append(remainingIDs, remainingTracker.ID)
+	}
+
+	if err := r.federatedTrackerStore.SetFederatedTrackerOrder(remainingIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncTrackerEntries) {
+		return
+	}
+
+	logger.Info("Removed federated tracker", logging.Tracker(trackerAddress(tracker.Host, tracker.Port)), "name", tracker.Name, logging.RemoteAddr(request.RemoteAddr))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *RestService) reorderFederatedTrackers(w http.ResponseWriter, request *http.Request) {
+
+	var reorderRequest ReorderRequest
+
+	if err := json.NewDecoder(request.Body).Decode(&reorderRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.cfgMutex.Lock()
+	defer r.cfgMutex.Unlock()
+
+	trackers, err := r.federatedTrackerStore.GetFederatedTrackers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	existingIDs := make([]uint, 0, len(trackers))
+	for _, tracker := range trackers {
+		existingIDs = append(existingIDs, tracker.ID)
+	}
+
+	if !isPermutation(reorderRequest.IDs, existingIDs) {
+		http.Error(w, "ids must list every federated tracker exactly once", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.federatedTrackerStore.SetFederatedTrackerOrder(reorderRequest.IDs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.commitConfigChange(w, request, r.syncTrackerEntries) {
+		return
+	}
+
+	r.getFederatedTrackers(w, request)
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"magnetron/internal/logging"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type WebhooksDocument struct {
+	Enabled  bool              `json:"enabled"`
+	Webhooks []WebhookDocument `json:"webhooks"`
+}
+
+type WebhookDocument struct {
+	Name      string             `json:"name"`
+	URL       string             `json:"url"` // Scheme and host only, as paths and queries often carry secrets
+	Events    []events.EventType `json:"events"`
+	MinUsers  uint16             `json:"minUsers"`
+	Signed    bool               `json:"signed"`    // Deliveries carry an HMAC signature
+	Pending   int64              `json:"pending"`   // Deliveries waiting for their first or next attempt
+	Delivered int64              `json:"delivered"` // Deliveries accepted by the endpoint, within the retention period
+	Failed    int64              `json:"failed"`    // Deliveries given up on, within the retention period
+}
+
+type WebhookDeliveriesDocument struct {
+	Deliveries []WebhookDeliveryDocument `json:"deliveries"`
+	PageDocument
+}
+
+type WebhookDeliveryDocument struct {
+	ID             uint              `json:"id"`
+	Hook           string            `json:"hook"`
+	EventID        uint64            `json:"eventId"`
+	EventType      string            `json:"eventType"`
+	Status         db.DeliveryStatus `json:"status"` // pending, delivered or failed
+	Attempts       int               `json:"attempts"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	NextAttempt    *time.Time        `json:"nextAttempt,omitempty"` // Set while the delivery is pending
+	LastAttempt    *time.Time        `json:"lastAttempt,omitempty"`
+	LastStatusCode int               `json:"lastStatusCode,omitempty"`
+	LastError      string            `json:"lastError,omitempty"`
+	DeliveredAt    *time.Time        `json:"deliveredAt,omitempty"`
+	Payload        string            `json:"payload"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func newWebhookDeliveryDocument(delivery db.WebhookDelivery) WebhookDeliveryDocument {
+	document := WebhookDeliveryDocument{
+		ID:             delivery.ID,
+		Hook:           delivery.Hook,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		CreatedAt:      delivery.CreatedAt,
+		LastAttempt:    optionalTime(delivery.LastAttempt),
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		DeliveredAt:    optionalTime(delivery.DeliveredAt),
+		Payload:        delivery.Payload,
+	}
+
+	if delivery.Status == db.DeliveryPending {
+		document.NextAttempt = optionalTime(delivery.NextAttempt)
+	}
+
+	return document
+}
+
+// redactURL shortens a webhook URL to its scheme and host, leaving out the credentials, path and query that services
+// such as Discord and Slack embed their tokens in.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+func (r *RestService) getWebhooks(w http.ResponseWriter, request *http.Request) {
+
+	webhookDocuments := make([]WebhookDocument, 0)
+
+	for _, hook := range r.webhookService.Hooks() {
+		counts, err := r.webhookService.DeliveryCounts(hook.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		webhookDocuments = append(webhookDocuments, WebhookDocument{
+			Name:      hook.Name,
+			URL:       redactURL(hook.URL),
+			Events:    r.webhookService.EventTypes(hook.Name),
+			MinUsers:  hook.MinUsers,
+			Signed:    hook.Secret != "",
+			Pending:   counts[db.DeliveryPending],
+			Delivered: counts[db.DeliveryDelivered],
+			Failed:    counts[db.DeliveryFailed],
+		})
+	}
+
+	writeJson(w, http.StatusOK, WebhooksDocument{Enabled: r.webhookService.Enabled(), Webhooks: webhookDocuments})
+}
+
+func (r *RestService) getWebhookDeliveries(w http.ResponseWriter, request *http.Request) {
+
+	query, err := parseListQuery(request, webhookDeliveryListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := db.DeliveryStatus(request.URL.Query().Get("status"))
+
+	switch status {
+	case "", db.DeliveryPending, db.DeliveryDelivered, db.DeliveryFailed:
+	default:
+		http.Error(w, "status must be pending, delivered or failed", http.StatusBadRequest)
+		return
+	}
+
+	// Deliveries pile up with every event, so they are searched and paged by the database.
+	deliveries, total, err := r.webhookService.Deliveries(db.DeliveryQuery{
+		Hook:   request.URL.Query().Get("hook"),
+		Status: status,
+		Search: query.Search,
+		Sort:   deliverySortColumns[query.Sort],
+		Desc:   query.Desc,
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deliveryDocuments := make([]WebhookDeliveryDocument, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		deliveryDocuments = append(deliveryDocuments, newWebhookDeliveryDocument(delivery))
+	}
+
+	page := PageDocument{Total: int(total), Offset: query.Offset, Limit: query.Limit}
+
+	writeJson(w, http.StatusOK, WebhookDeliveriesDocument{deliveryDocuments, page})
+}
+
+func (r *RestService) retryWebhookDelivery(w http.ResponseWriter, request *http.Request) {
+
+	id, err := parseID(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := r.webhookService.Retry(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Queued webhook delivery for another attempt", "delivery_id", delivery.ID, "webhook", delivery.Hook, logging.RemoteAddr(request.RemoteAddr))
+
+	writeJson(w, http.StatusOK, newWebhookDeliveryDocument(delivery))
+}
@@ -0,0 +1,110 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"magnetron/internal/logging"
+	"os"
+	"sync"
+	"time"
+)
+
+const reloadInterval = 10 * time.Second
+
+// Reloader serves a certificate and its key from disk, reloading them when either file changes so that a renewed
+// certificate is used without a restart.
+type Reloader struct {
+	certFile       string
+	keyFile        string
+	logger         *slog.Logger
+	mu             sync.RWMutex
+	cert           *tls.Certificate
+	loadedModTimes [2]time.Time // Modification times of the certificate and key files when they were last loaded
+}
+
+// NewReloader loads the certificate and key, failing when they cannot be read.
+func NewReloader(certFile string, keyFile string, logger *slog.Logger) (*Reloader, error) {
+	reloader := &Reloader{certFile: certFile, keyFile: keyFile, logger: logger}
+
+	modTimes, err := reloader.modTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	reloader.loadedModTimes = modTimes
+
+	return reloader, nil
+}
+
+func (r *Reloader) modTimes() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+
+	for i, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load certificate %s: %w", r.certFile, err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate whenever the certificate or key file changes. A pair that fails to load, e.g. because
+// only one of the files has been replaced yet, leaves the previous certificate in use until either file changes again.
+func (r *Reloader) Watch() {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		modTimes, err := r.modTimes()
+		if err != nil {
+			r.logger.Warn("Could not check certificate for changes", "file", r.certFile, logging.Err(err))
+			continue
+		}
+
+		if modTimes == r.loadedModTimes {
+			continue
+		}
+		r.loadedModTimes = modTimes
+
+		if err := r.load(); err != nil {
+			r.logger.Error("Could not reload certificate, keeping the previous one", "file", r.certFile, logging.Err(err))
+			continue
+		}
+
+		r.logger.Info("Reloaded certificate", "file", r.certFile)
+	}
+}
+
+// ServerConfig returns the TLS configuration of a listener serving the reloader's certificate.
+func ServerConfig(reloader *Reloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+}
@@ -0,0 +1,151 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// DefaultValidity is how long generated certificates are valid for.
+const DefaultValidity = 365 * 24 * time.Hour
+
+// GenerateSelfSigned returns a PEM encoded self-signed ECDSA P-256 certificate for the given host names and
+// addresses, and its private key.
+func GenerateSelfSigned(hosts []string, validFor time.Duration) ([]byte, []byte, error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("a certificate needs at least one host")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"Magnetron self-signed"}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, // Client usage lets it sign client certificates
+		BasicConstraintsValid: true,
+		IsCA:                  true, // Lets clients trust the certificate itself as their CA
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// WriteSelfSigned generates a self-signed certificate for the hosts and writes it and its key, the key readable by
+// its owner only.
+func WriteSelfSigned(certFile string, keyFile string, hosts []string, validFor time.Duration) error {
+	certPEM, keyPEM, err := GenerateSelfSigned(hosts, validFor)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("could not write key file: %w", err)
+	}
+
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("could not write certificate file: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureCertificate generates and persists a self-signed certificate when neither the certificate nor the key file
+// exists, so that TLS can be enabled without first obtaining a certificate. It refuses to replace only one of them.
+func EnsureCertificate(certFile string, keyFile string, listenHost string, logger *slog.Logger) error {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+
+	if !errors.Is(certErr, os.ErrNotExist) || !errors.Is(keyErr, os.ErrNotExist) {
+		return fmt.Errorf("certificate %s and key %s must either both exist or both be missing", certFile, keyFile)
+	}
+
+	hosts := DefaultHosts(listenHost)
+	if err := WriteSelfSigned(certFile, keyFile, hosts, DefaultValidity); err != nil {
+		return err
+	}
+
+	logger.Warn("Generated a self-signed certificate because none was found. Clients will not trust it unless told "+
+		"to; replace it with a certificate from a trusted CA, it is reloaded without a restart",
+		"file", certFile, "key_file", keyFile, "hosts", hosts, "expires", time.Now().Add(DefaultValidity).Format(time.DateOnly))
+
+	return nil
+}
+
+// DefaultHosts returns the names a certificate for a listener on listenHost is generated for: the listener's own
+// host, or the machine's host name when it listens on every interface, and the loopback names.
+func DefaultHosts(listenHost string) []string {
+	var hosts []string
+
+	host, _, err := net.SplitHostPort(listenHost)
+	if err != nil {
+		host = listenHost
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+	} else {
+		hosts = append(hosts, host)
+	}
+
+	for _, loopback := range []string{"localhost", "127.0.0.1", "::1"} {
+		if !slices.Contains(hosts, loopback) {
+			hosts = append(hosts, loopback)
+		}
+	}
+
+	return hosts
+}
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"log"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -20,11 +24,23 @@ type Config struct {
 	ClientHost        string                  `yaml:"ClientHost" validate:"required"`       // Interface/host and port the clients will connect to the tracker on
 	ServerHost        string                  `yaml:"ServerHost" validate:"required"`       // Interface/host and port the servers will connect to the tracker on
 	ServerExpiration  time.Duration           `yaml:"ServerExpiration" validate:"required"` // How long a server can be inactive before it is removed from the list
+	ClientTls         ClientTlsConfig         `yaml:"ClientTls"`                            // Listener serving listings to clients over TLS
 	StaticEntries     []StaticEntry           `yaml:"StaticEntries"`                        // Static entries are placed in order at the top of the server list
 	EnablePasswords   bool                    `yaml:"EnablePasswords"`                      // Enable password authentication
 	PasswordFile      string                  `yaml:"PasswordFile"`                         // Path to the password file
+	Verification      VerificationConfig      `yaml:"Verification"`                         // How passwords are enforced and verified servers listed
+	Registration      RegistrationConfig      `yaml:"Registration"`                         // Queueing and processing of server registrations
 	TrackerFederation TrackerFederationConfig `yaml:"TrackerFederation"`                    // Tracker federation configuration
 	RestConfig        RestConfig              `yaml:"RestApi"`                              // Rest API configuration
+	Peering           PeeringConfig           `yaml:"Peering"`                              // Real-time replication of registrations between trackers
+	Relay             RelayConfig             `yaml:"Relay"`                                // Forwarding of registrations to upstream trackers
+	Moderation        ModerationConfig        `yaml:"Moderation"`                           // Bans and listing overrides for registered servers
+	Webhooks          WebhookConfig           `yaml:"Webhooks"`                             // HTTP notifications of registry and federation events
+	Metrics           MetricsConfig           `yaml:"Metrics"`                              // Prometheus metrics endpoint
+	History           HistoryConfig           `yaml:"History"`                              // User count and uptime history of registered servers
+	Directory         DirectoryConfig         `yaml:"Directory"`                            // Public HTML directory of the listing and feeds of new servers
+	Logging           LoggingConfig           `yaml:"Logging"`                              // Log level, format and destination
+	path              string                  // Path the configuration was read from
 }
 
 type StaticEntry struct {
@@ -34,18 +50,182 @@ type StaticEntry struct {
 	UserCount   uint16 `yaml:"UserCount"`   // Number of users on the server
 }
 
+// ClientTlsConfig controls a second client listener that serves the same listings as ClientHost over TLS.
+type ClientTlsConfig struct {
+	Enabled  bool   `yaml:"Enabled"`  // Enable the TLS client listener
+	Host     string `yaml:"Host"`     // Interface/host and port the clients will connect to the TLS listener on
+	CertFile string `yaml:"CertFile"` // Certificate of the listener, reloaded when it changes
+	KeyFile  string `yaml:"KeyFile"`  // Private key of the certificate
+}
+
 type TrackerFederationConfig struct {
-	Enabled        bool           `yaml:"Enabled"`
-	PollFrequency  time.Duration  `yaml:"PollFrequency"`
-	Header         string         `yaml:"Header"`
-	TrackerEntries []TrackerEntry `yaml:"Trackers"`
+	Enabled        bool            `yaml:"Enabled"`
+	PollFrequency  time.Duration   `yaml:"PollFrequency"`
+	Header         string          `yaml:"Header"`
+	TrackerEntries []TrackerEntry  `yaml:"Trackers"`
+	Discovery      DiscoveryConfig `yaml:"Discovery"`
+}
+
+type DiscoveryConfig struct {
+	Enabled       bool          `yaml:"Enabled"`       // Probe hosts seen in federated listings for trackers
+	MaxDepth      uint16        `yaml:"MaxDepth"`      // How many listings away from a configured tracker to look for candidates
+	MaxCandidates uint16        `yaml:"MaxCandidates"` // Maximum number of candidate trackers to record, 50 when 0
+	ProbeTimeout  time.Duration `yaml:"ProbeTimeout"`  // How long to wait for a probed host to answer, 5s when 0
+	ProbeInterval time.Duration `yaml:"ProbeInterval"` // How long to wait before probing the same host again
 }
 
 type TrackerEntry struct {
-	Address     string `yaml:"Address"`
-	Name        string `yaml:"Name"`
-	Description string `yaml:"Description"`
-	UserCount   uint16 `yaml:"UserCount"`
+	Address       string `yaml:"Address"`
+	Name          string `yaml:"Name"`
+	Description   string `yaml:"Description"`
+	UserCount     uint16 `yaml:"UserCount"`
+	Tls           bool   `yaml:"Tls,omitempty"`           // Poll the tracker's TLS client listener at Address
+	TlsServerName string `yaml:"TlsServerName,omitempty"` // Name the tracker's certificate is verified for, its host when empty
+	TlsCAFile     string `yaml:"TlsCAFile,omitempty"`     // PEM bundle the tracker's certificate is verified with, the system roots when empty
+}
+
+type PeeringConfig struct {
+	Enabled  bool        `yaml:"Enabled"`  // Enable real-time replication of registered servers
+	NodeName string      `yaml:"NodeName"` // Unique name of this tracker amongst its peers
+	Host     string      `yaml:"Host"`     // Interface/host and port peers connect to this tracker on
+	Secret   string      `yaml:"Secret"`   // Shared secret used to authenticate peers that do not have their own
+	Peers    []PeerEntry `yaml:"Peers"`    // Trackers this tracker streams its changes to
+}
+
+type PeerEntry struct {
+	Name    string `yaml:"Name"`    // Node name of the peer tracker
+	Address string `yaml:"Address"` // Host and port of the peer's peering listener
+	Secret  string `yaml:"Secret"`  // Shared secret for this peer, overrides the global secret
+}
+
+type ModerationConfig struct {
+	Bans      []BanEntry      `yaml:"Bans"`      // Servers that may not register
+	Overrides []OverrideEntry `yaml:"Overrides"` // Changes to how registered servers are listed
+}
+
+type BanEntry struct {
+	PassID  uint32 `yaml:"PassID,omitempty"`  // Pass ID of the banned server
+	Address string `yaml:"Address,omitempty"` // Host and port of the banned server in the form "ip:port"
+	IP      string `yaml:"IP,omitempty"`      // IP address or CIDR range of the banned servers
+	Reason  string `yaml:"Reason,omitempty"`  // Why the server was banned
+}
+
+type OverrideEntry struct {
+	PassID      uint32 `yaml:"PassID"`                // Pass ID of the registered server
+	Name        string `yaml:"Name,omitempty"`        // Name shown instead of the registered name
+	Description string `yaml:"Description,omitempty"` // Description shown instead of the registered description
+	Pinned      bool   `yaml:"Pinned,omitempty"`      // List the server before the other registered servers
+}
+
+// RelayConfig controls forwarding of accepted registrations to other trackers. Hotline registrations carry no
+// address, so upstream trackers list relayed servers under the address the datagram came from; relaying is
+// therefore most useful between trackers that share an address or that are told to trust the relay.
+type RelayConfig struct {
+	Enabled     bool            `yaml:"Enabled"`     // Enable forwarding of registrations upstream
+	MinInterval time.Duration   `yaml:"MinInterval"` // Minimum time between two forwards of the same server to the same upstream, 1m when 0
+	Upstreams   []UpstreamEntry `yaml:"Upstreams"`   // Trackers registrations are forwarded to
+}
+
+// DefaultRelayMinInterval is the MinInterval of configurations that do not set one, so that relayed servers are not
+// forwarded with every registration.
+const DefaultRelayMinInterval = 1 * time.Minute
+
+type UpstreamEntry struct {
+	Address         string   `yaml:"Address"`         // Host and port of the upstream tracker's server listener
+	Password        string   `yaml:"Password"`        // Plain text password sent upstream
+	ForwardPassword bool     `yaml:"ForwardPassword"` // Forward the server's own password when Password is empty, no password is sent otherwise
+	PasswordEntries []string `yaml:"PasswordEntries"` // Only forward servers that authenticated with one of these password entry names
+	Servers         []string `yaml:"Servers"`         // Only forward servers whose name or host:port matches one of these patterns
+	SigningKey      string   `yaml:"SigningKey"`      // Password entry name on the upstream that forwarded registrations are signed for
+	SigningSecret   string   `yaml:"SigningSecret"`   // Signing secret of that entry, forwarded registrations are not signed when empty
+}
+
+type WebhookConfig struct {
+	Enabled     bool           `yaml:"Enabled"`     // Enable delivery of webhooks
+	QueueFile   string         `yaml:"QueueFile"`   // SQLite file pending deliveries are kept in so that they survive restarts
+	MaxAttempts int            `yaml:"MaxAttempts"` // Deliveries are given up after this many failed attempts
+	Timeout     time.Duration  `yaml:"Timeout"`     // How long to wait for a webhook endpoint to answer
+	Retention   time.Duration  `yaml:"Retention"`   // How long finished deliveries are kept for the delivery status
+	Hooks       []WebhookEntry `yaml:"Hooks"`       // Endpoints notified of events
+}
+
+type WebhookEntry struct {
+	Name     string            `yaml:"Name"`     // Unique name shown in the delivery status
+	URL      string            `yaml:"URL"`      // Endpoint the events are posted to
+	Events   []string          `yaml:"Events"`   // Event types to deliver, every type except server.refreshed when empty
+	MinUsers uint16            `yaml:"MinUsers"` // Only deliver server events for servers with at least this many users
+	Secret   string            `yaml:"Secret"`   // Key of the HMAC-SHA256 signature sent with each delivery, empty to not sign
+	Template string            `yaml:"Template"` // Go template producing the JSON body, the event document when empty
+	Headers  map[string]string `yaml:"Headers"`  // Additional request headers, e.g. for authentication
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"Enabled"` // Expose /metrics in the Prometheus text format
+	Host    string `yaml:"Host"`    // Interface/host and port of a separate metrics listener, empty to use the REST listener
+}
+
+type HistoryConfig struct {
+	Enabled        bool          `yaml:"Enabled"`        // Record user counts and online/offline transitions of registered servers
+	File           string        `yaml:"File"`           // SQLite file the history is kept in, empty to keep it in memory only
+	SampleInterval time.Duration `yaml:"SampleInterval"` // How often user counts are sampled
+	RawRetention   time.Duration `yaml:"RawRetention"`   // How long samples are kept at full resolution before being merged hourly
+	Retention      time.Duration `yaml:"Retention"`      // How long history is kept at all
+}
+
+// DirectoryConfig controls a public HTML directory of the listing and feeds of newly registered servers, served by
+// the REST API without authentication.
+type DirectoryConfig struct {
+	Enabled     bool   `yaml:"Enabled"`     // Serve the directory and its feeds, needs the REST API
+	Path        string `yaml:"Path"`        // URL path the directory is served at, e.g. /directory/ or /
+	Title       string `yaml:"Title"`       // Title of the page and the feeds
+	Description string `yaml:"Description"` // Introduction shown on the page and description of the feeds
+	BaseURL     string `yaml:"BaseURL"`     // Public URL of the REST listener used in feeds, e.g. https://tracker.example.com, taken from requests and not cached publicly when empty
+	TemplateDir string `yaml:"TemplateDir"` // Directory of html/template files replacing the built-in ones, reloaded when they change
+	FeedItems   int    `yaml:"FeedItems"`   // Number of the most recently registered servers in the feeds
+}
+
+// Defaults of the directory, used when the configuration leaves them empty.
+const (
+	DefaultDirectoryPath      = "/directory/"
+	DefaultDirectoryTitle     = "Hotline Servers"
+	DefaultDirectoryFeedItems = 20
+)
+
+// Password modes, chosen with VerificationConfig.Mode when EnablePasswords is set.
+const (
+	PasswordsOff      = ""         // EnablePasswords is not set, passwords are ignored
+	PasswordsRequired = "required" // Servers without a valid password are rejected
+	PasswordsMixed    = "mixed"    // Servers without a valid password are listed as unverified
+	PasswordsDryRun   = "dry-run"  // Every server is accepted, and the ones that would be rejected are logged
+)
+
+// Orders of the verified and unverified sections of the listing.
+var VerificationOrders = []string{"listing", "name", "users", "firstSeen"}
+
+type VerificationConfig struct {
+	Mode             string        `yaml:"Mode"`             // required (default), mixed or dry-run
+	VerifiedHeader   string        `yaml:"VerifiedHeader"`   // Header listed above verified servers in mixed mode, none when empty
+	VerifiedOrder    string        `yaml:"VerifiedOrder"`    // listing (default), name, users or firstSeen
+	UnverifiedHeader string        `yaml:"UnverifiedHeader"` // Header listed above unverified servers in mixed mode, none when empty
+	UnverifiedOrder  string        `yaml:"UnverifiedOrder"`  // listing (default), name, users or firstSeen
+	SignatureWindow  time.Duration `yaml:"SignatureWindow"`  // How far the time of a signed registration may be from the tracker's clock, 5m when 0
+}
+
+type RegistrationConfig struct {
+	Workers          int           `yaml:"Workers"`          // Registrations processed at the same time, 4 when 0
+	QueueSize        int           `yaml:"QueueSize"`        // Registrations waiting for a worker before new ones are dropped, 1024 when 0
+	ReadBuffer       int           `yaml:"ReadBuffer"`       // Size of the UDP receive buffer in bytes, the system default when 0
+	PasswordCacheTTL time.Duration `yaml:"PasswordCacheTTL"` // How long a password check is reused, 10m when 0 and never when negative
+}
+
+// LogSubsystems lists the subsystems whose log level can be set on their own.
+var LogSubsystems = []string{"registry", "federation", "api", "db"}
+
+type LoggingConfig struct {
+	Level      string            `yaml:"Level"`      // debug, info (default), warn or error
+	Format     string            `yaml:"Format"`     // text (default) or json
+	File       string            `yaml:"File"`       // File log records are appended to, empty for standard error
+	Subsystems map[string]string `yaml:"Subsystems"` // Levels of single subsystems, overriding Level
 }
 
 type RestConfig struct {
@@ -54,10 +234,20 @@ type RestConfig struct {
 	EnableTls       bool   `yaml:"EnableTls"`
 	KeyFile         string `yaml:"KeyFile"`
 	CertFile        string `yaml:"CertFile"`
+	ClientAuth      string `yaml:"ClientAuth"`   // Client certificates: none (default), optional or required, needs EnableTls and EnableTokenAuth
+	ClientCAFile    string `yaml:"ClientCAFile"` // PEM bundle of the CAs client certificates are verified with
 	EnableTokenAuth bool   `yaml:"EnableTokenAuth"`
 	TokenAuthFile   string `yaml:"TokenAuthFile"`
+	EnableDashboard bool   `yaml:"EnableDashboard"` // Serve the web admin dashboard at /admin/, needs EnableTokenAuth
 }
 
+// Client certificate verification modes of the REST API.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequired = "required"
+)
+
 func LoadConfig(configPath string) (*Config, error) {
 
 	config := &Config{}
@@ -75,9 +265,18 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, err
 	}
 
+	config.applyDefaults()
+
 	return config, nil
 }
 
+// applyDefaults sets the values that a configuration file may leave out but the tracker cannot run without.
+func (c *Config) applyDefaults() {
+	if c.Relay.MinInterval == 0 {
+		c.Relay.MinInterval = DefaultRelayMinInterval
+	}
+}
+
 func getHost(address string) (string, error) {
 	parts := strings.Split(address, ":")
 
@@ -124,6 +323,12 @@ func (c *Config) Validate() {
 		errors = append(errors, err)
 	}
 
+	if c.ClientTls.Enabled {
+		for _, tlsError := range c.ClientTls.Validate() {
+			errors = append(errors, tlsError)
+		}
+	}
+
 	for _, entry := range c.StaticEntries {
 
 		for _, entryError := range entry.Validate() {
@@ -131,6 +336,86 @@ func (c *Config) Validate() {
 		}
 	}
 
+	for _, tracker := range c.TrackerFederation.TrackerEntries {
+		for _, trackerError := range tracker.Validate() {
+			errors = append(errors, trackerError)
+		}
+	}
+
+	for _, ban := range c.Moderation.Bans {
+		for _, banError := range ban.Validate() {
+			errors = append(errors, banError)
+		}
+	}
+
+	if c.TrackerFederation.Discovery.Enabled {
+		for _, discoveryError := range c.TrackerFederation.Discovery.Validate() {
+			errors = append(errors, discoveryError)
+		}
+	}
+
+	if c.Relay.Enabled {
+		if c.Relay.MinInterval < 0 {
+			errors = append(errors, fmt.Errorf("relay min interval must not be negative (%s)", c.Relay.MinInterval))
+		}
+
+		for _, upstream := range c.Relay.Upstreams {
+			for _, upstreamError := range upstream.Validate() {
+				errors = append(errors, upstreamError)
+			}
+		}
+	}
+
+	if c.Webhooks.Enabled {
+		for _, webhookError := range c.Webhooks.Validate() {
+			errors = append(errors, webhookError)
+		}
+	}
+
+	if c.RestConfig.Enabled {
+		for _, restError := range c.RestConfig.Validate() {
+			errors = append(errors, restError)
+		}
+	}
+
+	if c.Directory.Enabled {
+		if !c.RestConfig.Enabled {
+			errors = append(errors, fmt.Errorf("the directory needs the REST API to be enabled"))
+		}
+
+		for _, directoryError := range c.Directory.Validate() {
+			errors = append(errors, directoryError)
+		}
+	}
+
+	if c.Metrics.Enabled && c.Metrics.Host == "" && !c.RestConfig.Enabled {
+		errors = append(errors, fmt.Errorf("metrics need either their own Host or the REST API to be enabled"))
+	}
+
+	for _, registrationError := range c.Registration.Validate() {
+		errors = append(errors, registrationError)
+	}
+
+	if c.EnablePasswords {
+		for _, verificationError := range c.Verification.Validate() {
+			errors = append(errors, verificationError)
+		}
+	}
+
+	for _, loggingError := range c.Logging.Validate() {
+		errors = append(errors, loggingError)
+	}
+
+	if c.Peering.Enabled {
+		for _, peeringError := range c.Peering.Validate() {
+			errors = append(errors, peeringError)
+		}
+	}
+
+	for _, listenerError := range c.validateListeners() {
+		errors = append(errors, listenerError)
+	}
+
 	if len(errors) > 0 {
 		for _, err := range errors {
 			log.Println(err)
@@ -162,6 +447,68 @@ func (e *StaticEntry) Validate() []error {
 	return errors
 }
 
+func (e *UpstreamEntry) GetHost() (string, error) {
+	return getHost(e.Address)
+}
+
+func (e *UpstreamEntry) GetPort() (uint16, error) {
+	return getPort(e.Address, 5499)
+}
+
+func (e *UpstreamEntry) Validate() []error {
+	var errors []error
+
+	if _, err := e.GetHost(); err != nil {
+		errors = append(errors, err)
+	}
+
+	if _, err := e.GetPort(); err != nil {
+		errors = append(errors, err)
+	}
+
+	if (e.SigningKey == "") != (e.SigningSecret == "") {
+		errors = append(errors, fmt.Errorf("upstream signing key and signing secret must be set together (%s)", e.Address))
+	}
+
+	if len(e.SigningKey) > 255 {
+		errors = append(errors, fmt.Errorf("upstream signing key must be at most 255 bytes (%s)", e.Address))
+	}
+
+	return errors
+}
+
+func (e *BanEntry) Validate() []error {
+	var errors []error
+
+	criteria := 0
+
+	if e.PassID != 0 {
+		criteria++
+	}
+
+	if e.Address != "" {
+		criteria++
+
+		if _, _, err := net.SplitHostPort(e.Address); err != nil {
+			errors = append(errors, fmt.Errorf("ban address must be in the form ip:port: %s", e.Address))
+		}
+	}
+
+	if e.IP != "" {
+		criteria++
+
+		if _, _, err := net.ParseCIDR(e.IP); err != nil && net.ParseIP(e.IP) == nil {
+			errors = append(errors, fmt.Errorf("ban IP must be an IP address or CIDR range: %s", e.IP))
+		}
+	}
+
+	if criteria != 1 {
+		errors = append(errors, fmt.Errorf("ban must have exactly one of PassID, Address or IP"))
+	}
+
+	return errors
+}
+
 func (e *TrackerEntry) GetHost() (string, error) {
 	return getHost(e.Address)
 }
@@ -181,9 +528,301 @@ func (e *TrackerEntry) Validate() []error {
 		errors = append(errors, err)
 	}
 
+	if !e.Tls && (e.TlsServerName != "" || e.TlsCAFile != "") {
+		errors = append(errors, fmt.Errorf("tracker TLS server name and CA file require Tls (%s)", e.Address))
+	}
+
 	return errors
 }
 
+func (c *RestConfig) Validate() []error {
+	var errors []error
+
+	if !slices.Contains([]string{"", ClientAuthNone, ClientAuthOptional, ClientAuthRequired}, c.ClientAuth) {
+		errors = append(errors, fmt.Errorf("REST client auth must be none, optional or required (%s)", c.ClientAuth))
+	}
+
+	if c.ClientAuth != "" && c.ClientAuth != ClientAuthNone {
+		if !c.EnableTls {
+			errors = append(errors, fmt.Errorf("REST client certificates require EnableTls"))
+		}
+
+		if c.ClientCAFile == "" {
+			errors = append(errors, fmt.Errorf("REST client certificates require a ClientCAFile"))
+		}
+
+		// Certificates authenticate as the token entry their subject is mapped to, which needs the token file.
+		if !c.EnableTokenAuth {
+			errors = append(errors, fmt.Errorf("REST client certificates require EnableTokenAuth"))
+		}
+	}
+
+	// The dashboard changes the tracker, which is only allowed to authenticated clients.
+	if c.EnableDashboard && !c.EnableTokenAuth {
+		errors = append(errors, fmt.Errorf("REST dashboard requires EnableTokenAuth"))
+	}
+
+	return errors
+}
+
+func (c *DirectoryConfig) Validate() []error {
+	var errors []error
+
+	if c.Path != "" {
+		if !strings.HasPrefix(c.Path, "/") || !strings.HasSuffix(c.Path, "/") {
+			errors = append(errors, fmt.Errorf("directory path must start and end with a slash: %s", c.Path))
+		}
+
+		for _, reserved := range []string{"/api/", "/admin/", "/metrics/"} {
+			if strings.HasPrefix(c.Path, reserved) {
+				errors = append(errors, fmt.Errorf("directory path must not be within %s: %s", reserved, c.Path))
+			}
+		}
+	}
+
+	if c.BaseURL != "" {
+		if baseURL, err := url.Parse(c.BaseURL); err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+			errors = append(errors, fmt.Errorf("directory base URL must be an absolute http or https URL: %s", c.BaseURL))
+		}
+	}
+
+	if c.FeedItems < 0 {
+		errors = append(errors, fmt.Errorf("directory feed items must not be negative: %d", c.FeedItems))
+	}
+
+	return errors
+}
+
+func (c *DiscoveryConfig) Validate() []error {
+	var errors []error
+
+	if c.ProbeTimeout < 0 {
+		errors = append(errors, fmt.Errorf("discovery probe timeout must not be negative (%s)", c.ProbeTimeout))
+	}
+
+	if c.ProbeInterval < 0 {
+		errors = append(errors, fmt.Errorf("discovery probe interval must not be negative (%s)", c.ProbeInterval))
+	}
+
+	return errors
+}
+
+// validateListeners reports enabled TCP listeners that share an address, which only the first of them could listen on.
+// ServerHost is left out as it listens on UDP.
+func (c *Config) validateListeners() []error {
+	type listener struct {
+		name    string
+		address string
+	}
+
+	listeners := []listener{{"ClientHost", c.ClientHost}}
+
+	if c.ClientTls.Enabled {
+		listeners = append(listeners, listener{"ClientTls.Host", c.ClientTls.Host})
+	}
+	if c.Peering.Enabled {
+		listeners = append(listeners, listener{"Peering.Host", c.Peering.Host})
+	}
+	if c.RestConfig.Enabled {
+		listeners = append(listeners, listener{"RestApi.Host", c.RestConfig.Host})
+	}
+	if c.Metrics.Enabled && c.Metrics.Host != "" {
+		listeners = append(listeners, listener{"Metrics.Host", c.Metrics.Host})
+	}
+
+	var errors []error
+
+	for i, first := range listeners {
+		for _, second := range listeners[i+1:] {
+			if sameListenAddress(first.address, second.address) {
+				errors = append(errors, fmt.Errorf("%s (%s) and %s (%s) cannot listen on the same address", first.name, first.address,
+					second.name, second.address))
+			}
+		}
+	}
+
+	return errors
+}
+
+// sameListenAddress reports whether two listen addresses clash: they share a port, and either share a host or one of
+// them listens on all interfaces.
+func sameListenAddress(first string, second string) bool {
+	firstHost, firstPort, err := net.SplitHostPort(first)
+	if err != nil {
+		return false
+	}
+
+	secondHost, secondPort, err := net.SplitHostPort(second)
+	if err != nil {
+		return false
+	}
+
+	if firstPort != secondPort || firstPort == "0" {
+		return false
+	}
+
+	anyHost := func(host string) bool {
+		return host == "" || host == "0.0.0.0" || host == "::"
+	}
+
+	return firstHost == secondHost || anyHost(firstHost) || anyHost(secondHost)
+}
+
+func (c *ClientTlsConfig) Validate() []error {
+	var errors []error
+
+	if _, _, err := net.SplitHostPort(c.Host); err != nil {
+		errors = append(errors, fmt.Errorf("client TLS host must be in the form host:port: %s", c.Host))
+	}
+
+	if c.CertFile == "" || c.KeyFile == "" {
+		errors = append(errors, fmt.Errorf("client TLS requires a certificate and key file"))
+	}
+
+	return errors
+}
+
+func (c *PeeringConfig) Validate() []error {
+	var errors []error
+
+	if c.NodeName == "" {
+		errors = append(errors, fmt.Errorf("peering requires a node name"))
+	}
+
+	for _, peer := range c.Peers {
+		if peer.Name == "" {
+			errors = append(errors, fmt.Errorf("peer is missing a node name (%s)", peer.Address))
+		}
+
+		if peer.Name == c.NodeName {
+			errors = append(errors, fmt.Errorf("peer has the same node name as this tracker (%s)", peer.Name))
+		}
+
+		if c.GetSecret(peer.Name) == "" {
+			errors = append(errors, fmt.Errorf("peer is missing a shared secret (%s)", peer.Name))
+		}
+	}
+
+	return errors
+}
+
+func (c *WebhookConfig) Validate() []error {
+	var errors []error
+
+	names := make(map[string]bool)
+
+	for _, hook := range c.Hooks {
+		if hook.Name == "" {
+			errors = append(errors, fmt.Errorf("webhook is missing a name (%s)", hook.URL))
+		} else if names[hook.Name] {
+			errors = append(errors, fmt.Errorf("webhook name is used more than once (%s)", hook.Name))
+		}
+		names[hook.Name] = true
+
+		if hookURL, err := url.Parse(hook.URL); err != nil || (hookURL.Scheme != "http" && hookURL.Scheme != "https") || hookURL.Host == "" {
+			errors = append(errors, fmt.Errorf("webhook URL must be an absolute http or https URL (%s)", hook.Name))
+		}
+	}
+
+	return errors
+}
+
+// PasswordMode returns how passwords are enforced, PasswordsOff when they are not enabled.
+func (c *Config) PasswordMode() string {
+	if !c.EnablePasswords {
+		return PasswordsOff
+	}
+
+	if c.Verification.Mode == "" {
+		return PasswordsRequired
+	}
+
+	return c.Verification.Mode
+}
+
+func (c *RegistrationConfig) Validate() []error {
+	var errors []error
+
+	if c.Workers < 0 {
+		errors = append(errors, fmt.Errorf("registration workers must not be negative (%d)", c.Workers))
+	}
+
+	if c.QueueSize < 0 {
+		errors = append(errors, fmt.Errorf("registration queue size must not be negative (%d)", c.QueueSize))
+	}
+
+	if c.ReadBuffer < 0 {
+		errors = append(errors, fmt.Errorf("registration read buffer must not be negative (%d)", c.ReadBuffer))
+	}
+
+	return errors
+}
+
+func (c *VerificationConfig) Validate() []error {
+	var errors []error
+
+	if !slices.Contains([]string{"", PasswordsRequired, PasswordsMixed, PasswordsDryRun}, c.Mode) {
+		errors = append(errors, fmt.Errorf("verification mode must be required, mixed or dry-run (%s)", c.Mode))
+	}
+
+	for _, order := range []string{c.VerifiedOrder, c.UnverifiedOrder} {
+		if order != "" && !slices.Contains(VerificationOrders, order) {
+			errors = append(errors, fmt.Errorf("unknown verification order %s, expected one of %s", order, strings.Join(VerificationOrders, ", ")))
+		}
+	}
+
+	if c.SignatureWindow < 0 {
+		errors = append(errors, fmt.Errorf("verification signature window must not be negative (%s)", c.SignatureWindow))
+	}
+
+	return errors
+}
+
+func (c *LoggingConfig) Validate() []error {
+	var errors []error
+
+	if _, err := ParseLogLevel(c.Level); err != nil {
+		errors = append(errors, err)
+	}
+
+	if c.Format != "" && c.Format != "text" && c.Format != "json" {
+		errors = append(errors, fmt.Errorf("log format must be text or json (%s)", c.Format))
+	}
+
+	for subsystem, level := range c.Subsystems {
+		if !slices.Contains(LogSubsystems, subsystem) {
+			errors = append(errors, fmt.Errorf("unknown log subsystem %s, expected one of %s", subsystem, strings.Join(LogSubsystems, ", ")))
+		} else if _, err := ParseLogLevel(level); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	return errors
+}
+
+// ParseLogLevel parses debug, info, warn or error, as well as offsets such as info+2. An empty level is info.
+func ParseLogLevel(level string) (slog.Level, error) {
+	var parsed slog.Level
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return parsed, fmt.Errorf("invalid log level %q", level)
+	}
+	return parsed, nil
+}
+
+// GetSecret returns the shared secret used with the named peer, falling back to the global secret.
+func (c *PeeringConfig) GetSecret(nodeName string) string {
+	for _, peer := range c.Peers {
+		if peer.Name == nodeName && peer.Secret != "" {
+			return peer.Secret
+		}
+	}
+
+	return c.Secret
+}
+
 func GetDefaultConfig() Config {
 
 	var c Config
@@ -209,9 +848,17 @@ func ReadConfigFile(path string) Config {
 	if err != nil {
 		log.Fatal("Error while getting parsing yaml configuration", err)
 	}
+
+	config.applyDefaults()
+	config.path = path
 	return config
 }
 
+// Path returns the file the configuration was read from, or an empty string for configurations built in memory.
+func (c *Config) Path() string {
+	return c.path
+}
+
 func WriteConfig(config Config, path string) {
 	configYaml, err := yaml.Marshal(&config)
 	if err != nil {
@@ -2,10 +2,16 @@ package config
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"log"
+	"net"
 	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
 )
 
 var (
@@ -13,14 +19,75 @@ var (
 	defaultPasswordsResource string
 )
 
+// PasswordHashPrefixes lists the prefixes of the password hashes the tracker can check: argon2id and bcrypt.
+var PasswordHashPrefixes = []string{"$argon2id$", "$2a$", "$2b$", "$2y$"}
+
 type PasswordConfig struct {
 	PasswordEntries []PasswordEntry `yaml:"PasswordEntries"`
 }
 
 type PasswordEntry struct {
-	Name        string `yaml:"Name"`        // A helpful name
-	Description string `yaml:"Description"` // A helpful description
-	Password    string `yaml:"Password"`    // The hashed/encrypted password
+	Name          string    `yaml:"Name"`                    // A helpful name, recorded on the servers registered with the entry
+	Description   string    `yaml:"Description"`             // A helpful description
+	Password      string    `yaml:"Password"`                // The argon2id or bcrypt hash of the password
+	AllowedNames  []string  `yaml:"AllowedNames,omitempty"`  // Server name patterns the entry may register, e.g. "Acme *", any name when empty
+	AllowedIPs    []string  `yaml:"AllowedIPs,omitempty"`    // Addresses or CIDRs the entry may register from, any address when empty
+	MaxServers    int       `yaml:"MaxServers,omitempty"`    // Servers registered with the entry at the same time, unlimited when 0
+	Expiry        time.Time `yaml:"Expiry,omitempty"`        // When the entry stops being accepted, never when empty
+	ReservedNames []string  `yaml:"ReservedNames,omitempty"` // Server name patterns that only this entry may register
+	SigningSecret string    `yaml:"SigningSecret,omitempty"` // Shared secret servers sign registrations with instead of sending the password, in plain text
+	Relay         bool      `yaml:"Relay,omitempty"`         // Registrations signed with the entry may name the server they relay, which is listed under its own address
+}
+
+// MinSigningSecretLength is the shortest signing secret accepted, in bytes.
+const MinSigningSecretLength = 16
+
+// AllowsName reports whether the entry may register a server under the given name.
+func (e *PasswordEntry) AllowsName(name string) bool {
+	return len(e.AllowedNames) == 0 || matchesName(e.AllowedNames, name)
+}
+
+// Reserves reports whether the name may only be registered with this entry.
+func (e *PasswordEntry) Reserves(name string) bool {
+	return matchesName(e.ReservedNames, name)
+}
+
+// AllowsIP reports whether the entry may register a server from the given address.
+func (e *PasswordEntry) AllowsIP(ip string) bool {
+	if len(e.AllowedIPs) == 0 {
+		return true
+	}
+
+	address := net.ParseIP(ip)
+
+	for _, allowed := range e.AllowedIPs {
+		if _, ipNet, err := net.ParseCIDR(allowed); err == nil {
+			if ipNet.Contains(address) {
+				return true
+			}
+		} else if net.ParseIP(allowed).Equal(address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired reports whether the entry is no longer accepted at the given time.
+func (e *PasswordEntry) Expired(now time.Time) bool {
+	return !e.Expiry.IsZero() && !e.Expiry.After(now)
+}
+
+// matchesName matches server names against shell patterns, ignoring case as Hotline clients do not tell names
+// apart by case.
+func matchesName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); matched {
+			return true
+		}
+	}
+
+	return false
 }
 
 func GetDefaultPasswordConfig() *PasswordConfig {
@@ -34,53 +101,153 @@ func GetDefaultPasswordConfig() *PasswordConfig {
 	return &cfg
 }
 
-func ReadPasswordConfigFile(path string) *PasswordConfig {
+// LoadPasswordConfigFile reads a password file without validating it.
+func LoadPasswordConfigFile(path string) (*PasswordConfig, error) {
 	var cfg PasswordConfig
 
 	configYaml, err := os.ReadFile(path)
-
 	if err != nil {
-		log.Fatal("Could not load yaml password config file: ", path, err)
+		return nil, fmt.Errorf("could not load password file: %s", err)
+	}
+
+	if err := yaml.Unmarshal(configYaml, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse password file %s: %s", path, err)
 	}
 
-	err = yaml.Unmarshal(configYaml, &cfg)
+	return &cfg, nil
+}
+
+func ReadPasswordConfigFile(path string) *PasswordConfig {
+	cfg, err := LoadPasswordConfigFile(path)
 	if err != nil {
-		log.Fatal("Error while getting parsing yaml password configuration", err)
+		log.Fatal(err)
 	}
-	return &cfg
+	return cfg
+}
+
+// FindEntry returns the index of the first password entry with the given name, or -1.
+func (c *PasswordConfig) FindEntry(name string) int {
+	for i, entry := range c.PasswordEntries {
+		if entry.Name == name {
+			return i
+		}
+	}
+	return -1
 }
 
 func (c *PasswordConfig) Validate() {
+	if errors := c.Errors(); len(errors) > 0 {
+		for _, err := range errors {
+			log.Println(err)
+		}
+
+		log.Fatal("Errors were found while validating the password configuration")
+	}
+}
+
+// Errors returns every problem found in the password configuration.
+func (c *PasswordConfig) Errors() []error {
 	var errors []error
 
 	if len(c.PasswordEntries) == 0 {
 		errors = append(errors, fmt.Errorf("password configuration is missing password entries"))
 	}
 
+	names := make(map[string]int)
+	for _, entry := range c.PasswordEntries {
+		names[entry.Name]++
+	}
+
 	for _, entry := range c.PasswordEntries {
 		if entry.Password == "" {
 			errors = append(errors, fmt.Errorf("password entry is missing password (%s)", entry.Name))
+		} else if !slices.ContainsFunc(PasswordHashPrefixes, func(prefix string) bool { return strings.HasPrefix(entry.Password, prefix) }) {
+			errors = append(errors, fmt.Errorf("password entry is not an argon2id or bcrypt hash (%s)", entry.Name))
 		}
-	}
 
-	if len(errors) > 0 {
-		for _, err := range errors {
-			log.Println(err)
+		// Servers are counted, names reserved and signing secrets looked up by entry name, which must then identify a
+		// single entry.
+		if (entry.MaxServers > 0 || len(entry.ReservedNames) > 0 || entry.SigningSecret != "") && (entry.Name == "" || names[entry.Name] > 1) {
+			errors = append(errors, fmt.Errorf("password entry with max servers, reserved names or a signing secret needs a unique name (%s)", entry.Name))
 		}
 
-		log.Fatal("Errors were found while validating the password configuration")
+		if entry.SigningSecret != "" && len(entry.SigningSecret) < MinSigningSecretLength {
+			errors = append(errors, fmt.Errorf("password entry signing secret must be at least %d characters (%s)", MinSigningSecretLength, entry.Name))
+		}
+
+		if entry.Relay && entry.SigningSecret == "" {
+			errors = append(errors, fmt.Errorf("password entry trusted to relay needs a signing secret (%s)", entry.Name))
+		}
+
+		if len(entry.Name) > 255 && entry.SigningSecret != "" {
+			errors = append(errors, fmt.Errorf("password entry with a signing secret needs a name of at most 255 bytes (%s)", entry.Name))
+		}
+
+		for _, pattern := range append(entry.AllowedNames, entry.ReservedNames...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errors = append(errors, fmt.Errorf("invalid server name pattern %s (%s)", pattern, entry.Name))
+			}
+		}
+
+		for _, ip := range entry.AllowedIPs {
+			if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+				errors = append(errors, fmt.Errorf("invalid allowed IP %s, expected an address or CIDR (%s)", ip, entry.Name))
+			}
+		}
+
+		if entry.MaxServers < 0 {
+			errors = append(errors, fmt.Errorf("password entry max servers must not be negative (%s)", entry.Name))
+		}
 	}
+
+	return errors
 }
 
 func WritePasswordConfig(config PasswordConfig, path string) {
-	configYaml, err := yaml.Marshal(&config)
-	if err != nil {
-		log.Fatal("Could not marshal password config data.", err)
+	if err := SavePasswordConfig(config, path); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	err = os.WriteFile(path, configYaml, 0644)
+// SavePasswordConfig writes the password file, replacing it in one step so that a tracker reloading the file never
+// reads it half written. Only the entries that changed are rewritten in an existing file, so its comments are kept.
+func SavePasswordConfig(config PasswordConfig, path string) error {
+	var configYaml []byte
 
-	if err != nil {
-		log.Fatal("Could not write password config yaml to file:", path, err)
+	// The file holds password hashes and signing secrets, so a new one is only readable by its owner.
+	mode := os.FileMode(0600)
+
+	if fileYaml, err := os.ReadFile(path); err == nil {
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+
+		if configYaml, err = mergeYaml(fileYaml, &config); err != nil {
+			return fmt.Errorf("could not update password file %s: %s", path, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		if configYaml, err = yaml.Marshal(&config); err != nil {
+			return fmt.Errorf("could not marshal password config data: %s", err)
+		}
+	} else {
+		return fmt.Errorf("could not read password file %s: %s", path, err)
 	}
+
+	temporary := path + ".tmp"
+
+	// WriteFile keeps the permissions of a file left over from an earlier attempt.
+	if err := os.Remove(temporary); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not write password file %s: %s", path, err)
+	}
+
+	if err := os.WriteFile(temporary, configYaml, mode); err != nil {
+		return fmt.Errorf("could not write password file %s: %s", path, err)
+	}
+
+	if err := os.Rename(temporary, path); err != nil {
+		os.Remove(temporary)
+		return fmt.Errorf("could not write password file %s: %s", path, err)
+	}
+
+	return nil
 }
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+// Save writes the configuration back to the file it was read from. Only the values that differ from the file are
+// rewritten, so comments, key order and formatting of everything else are left intact.
+func (c *Config) Save() error {
+	if c.path == "" {
+		return fmt.Errorf("configuration was not read from a file")
+	}
+
+	fileYaml, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	configYaml, err := mergeYaml(fileYaml, c)
+	if err != nil {
+		return fmt.Errorf("could not update config file %s: %s", c.path, err)
+	}
+
+	return os.WriteFile(c.path, configYaml, 0644)
+}
+
+// mergeYaml returns the file with the values of updated that differ from it rewritten, keeping the comments, key
+// order and formatting of everything else.
+func mergeYaml[T any](fileYaml []byte, updated *T) ([]byte, error) {
+	var fileDocument yaml.Node
+	if err := yaml.Unmarshal(fileYaml, &fileDocument); err != nil {
+		return nil, fmt.Errorf("could not parse file: %s", err)
+	}
+
+	// Compare against the file as this version would have written it, so that values such as durations which
+	// encode differently from how they were typed are only rewritten when they actually changed.
+	var fileValue T
+	if err := fileDocument.Decode(&fileValue); err != nil {
+		return nil, fmt.Errorf("could not decode file: %s", err)
+	}
+
+	var baseNode, newNode yaml.Node
+	if err := baseNode.Encode(&fileValue); err != nil {
+		return nil, err
+	}
+
+	if err := newNode.Encode(updated); err != nil {
+		return nil, err
+	}
+
+	if fileDocument.Kind != yaml.DocumentNode || len(fileDocument.Content) == 0 {
+		fileDocument = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&newNode}}
+	} else {
+		mergeNode(fileDocument.Content[0], &baseNode, &newNode)
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(&fileDocument); err != nil {
+		return nil, fmt.Errorf("could not marshal data: %s", err)
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// mergeNode updates file in place with the differences between base and updated.
+func mergeNode(file *yaml.Node, base *yaml.Node, updated *yaml.Node) {
+	if base != nil && nodesEqual(base, updated) {
+		return
+	}
+
+	if file.Kind != updated.Kind {
+		replaceNode(file, updated)
+		return
+	}
+
+	switch updated.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(updated.Content); i += 2 {
+			key := updated.Content[i].Value
+			updatedValue := updated.Content[i+1]
+			baseValue := mappingValue(base, key)
+
+			if fileValue := mappingValue(file, key); fileValue != nil {
+				mergeNode(fileValue, baseValue, updatedValue)
+			} else if baseValue == nil || !nodesEqual(baseValue, updatedValue) {
+				file.Content = append(file.Content, updated.Content[i], updatedValue)
+			}
+		}
+
+	case yaml.SequenceNode:
+		var baseItems []*yaml.Node
+		if base != nil && base.Kind == yaml.SequenceNode && len(base.Content) == len(file.Content) {
+			baseItems = base.Content
+		}
+
+		// Items that are unchanged keep their file node, wherever they moved to. Changed items reuse the
+		// remaining file nodes in order so their comments survive, and anything left over is appended.
+		assigned := make([]int, len(updated.Content))
+		used := make([]bool, len(file.Content))
+
+		for j, updatedItem := range updated.Content {
+			assigned[j] = -1
+			for i, baseItem := range baseItems {
+				if !used[i] && nodesEqual(baseItem, updatedItem) {
+					assigned[j] = i
+					used[i] = true
+					break
+				}
+			}
+		}
+
+		next := 0
+		content := make([]*yaml.Node, 0, len(updated.Content))
+
+		for j, updatedItem := range updated.Content {
+			if assigned[j] >= 0 {
+				content = append(content, file.Content[assigned[j]])
+				continue
+			}
+
+			for next < len(file.Content) && used[next] {
+				next++
+			}
+
+			if next < len(file.Content) {
+				var baseItem *yaml.Node
+				if baseItems != nil {
+					baseItem = baseItems[next]
+				}
+
+				mergeNode(file.Content[next], baseItem, updatedItem)
+				content = append(content, file.Content[next])
+				used[next] = true
+			} else {
+				content = append(content, updatedItem)
+			}
+		}
+
+		if len(file.Content) == 0 {
+			file.Style = updated.Style
+		}
+
+		file.Content = content
+
+	default:
+		replaceNode(file, updated)
+	}
+}
+
+// replaceNode swaps the value of file for updated while keeping the comments attached to file.
+func replaceNode(file *yaml.Node, updated *yaml.Node) {
+	headComment, lineComment, footComment := file.HeadComment, file.LineComment, file.FootComment
+	quoted := file.Kind == yaml.ScalarNode && file.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0
+
+	*file = *updated
+
+	if quoted && updated.Kind == yaml.ScalarNode && updated.Tag == "!!str" {
+		file.Style = yaml.DoubleQuotedStyle
+	}
+
+	file.HeadComment, file.LineComment, file.FootComment = headComment, lineComment, footComment
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func nodesEqual(a *yaml.Node, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+
+	if a.Kind == yaml.ScalarNode && a.Tag != b.Tag {
+		return false
+	}
+
+	for i := range a.Content {
+		if !nodesEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Ban blocks servers from registering. Exactly one of PassID, Address or IP is set on a ban.
+type Ban struct {
+	gorm.Model
+	PassID  uint32 // Pass ID of the banned server
+	Address string // Host and port of the banned server in the form "ip:port"
+	IP      string // IP address or CIDR range of the banned servers
+	Reason  string
+}
+
+type BanStore struct {
+	db *gorm.DB
+}
+
+func NewBanStore(db *gorm.DB) (*BanStore, error) {
+	if err := db.AutoMigrate(&Ban{}); err != nil {
+		return nil, err
+	}
+
+	return &BanStore{db}, nil
+}
+
+func (s *BanStore) AddBan(passID uint32, address string, ip string, reason string) (Ban, error) {
+	ban := Ban{
+		PassID:  passID,
+		Address: address,
+		IP:      ip,
+		Reason:  reason,
+	}
+
+	return ban, s.db.Create(&ban).Error
+}
+
+func (s *BanStore) RemoveBan(id uint) error {
+	return s.db.Unscoped().Where("id = ?", id).Delete(&Ban{}).Error
+}
+
+func (s *BanStore) GetBan(id uint) (Ban, error) {
+	var ban Ban
+	err := s.db.Where("id = ?", id).First(&ban).Error
+	return ban, err
+}
+
+func (s *BanStore) GetBans() ([]Ban, error) {
+	var bans []Ban
+	err := s.db.Order("id").Find(&bans).Error
+	return bans, err
+}
+
+// FindBan returns the first ban that matches a server, if any.
+func (s *BanStore) FindBan(passID uint32, host string, port uint16) (Ban, bool, error) {
+	bans, err := s.GetBans()
+	if err != nil {
+		return Ban{}, false, err
+	}
+
+	for _, ban := range bans {
+		if ban.Matches(passID, host, port) {
+			return ban, true, nil
+		}
+	}
+
+	return Ban{}, false, nil
+}
+
+func (b *Ban) Matches(passID uint32, host string, port uint16) bool {
+	if b.PassID != 0 {
+		return b.PassID == passID
+	}
+
+	if b.Address != "" {
+		return b.Address == net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	if b.IP == "" {
+		return false
+	}
+
+	if strings.Contains(b.IP, "/") {
+		_, ipNet, err := net.ParseCIDR(b.IP)
+		return err == nil && ipNet.Contains(net.ParseIP(host))
+	}
+
+	return net.ParseIP(b.IP).Equal(net.ParseIP(host))
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+type CandidateTracker struct {
+	gorm.Model
+	Host          string `gorm:"primaryKey"`
+	Port          uint16 `gorm:"primaryKey;autoincrement:false"`
+	ListedName    string // Name of the listing row the host was found under
+	ServerCount   uint16 // Number of servers in the candidate's own listing
+	Depth         uint16 // Number of listings between a configured tracker and the candidate
+	DiscoveredVia string // Host and port of the tracker whose listing contained the candidate
+	FirstSeen     time.Time
+	LastSeen      time.Time
+}
+
+type CandidateTrackerStore struct {
+	db *gorm.DB
+}
+
+func NewCandidateTrackerStore(db *gorm.DB) (*CandidateTrackerStore, error) {
+	if err := db.AutoMigrate(&CandidateTracker{}); err != nil {
+		return nil, err
+	}
+
+	return &CandidateTrackerStore{db}, nil
+}
+
+func (s *CandidateTrackerStore) SaveCandidateTracker(host string, port uint16, listedName string, serverCount uint16, depth uint16, discoveredVia string) (CandidateTracker, error) {
+	candidate, err := s.GetCandidateTracker(host, port)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		candidate = CandidateTracker{
+			Host:          host,
+			Port:          port,
+			ListedName:    listedName,
+			Depth:         depth,
+			DiscoveredVia: discoveredVia,
+			FirstSeen:     time.Now(),
+		}
+	} else if err != nil {
+		return candidate, err
+	}
+
+	// Keep the shortest known route to the candidate.
+	if depth < candidate.Depth {
+		candidate.Depth = depth
+		candidate.DiscoveredVia = discoveredVia
+	}
+
+	candidate.ServerCount = serverCount
+	candidate.LastSeen = time.Now()
+
+	return candidate, s.db.Save(&candidate).Error
+}
+
+func (s *CandidateTrackerStore) GetCandidateTracker(host string, port uint16) (CandidateTracker, error) {
+	var candidate CandidateTracker
+	err := s.db.Where("host = ? AND port = ?", host, port).First(&candidate).Error
+	return candidate, err
+}
+
+func (s *CandidateTrackerStore) GetCandidateTrackers() ([]CandidateTracker, error) {
+	var candidates []CandidateTracker
+	err := s.db.Order("depth").Order("first_seen").Find(&candidates).Error
+	return candidates, err
+}
+
+func (s *CandidateTrackerStore) CountCandidateTrackers() (int64, error) {
+	var count int64
+	err := s.db.Model(&CandidateTracker{}).Count(&count).Error
+	return count, err
+}
+
+func (s *CandidateTrackerStore) RemoveCandidateTracker(host string, port uint16) error {
+	return s.db.Unscoped().Where("host = ? AND port = ?", host, port).Delete(&CandidateTracker{}).Error
+}
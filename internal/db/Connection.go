@@ -4,11 +4,30 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"magnetron/internal/logging"
+	"time"
 )
 
+// gormLogger reports failed and slow queries through the db subsystem; missing records are expected and not logged.
+var gormLogger = logger.NewSlogLogger(logging.For(logging.DB), logger.Config{
+	LogLevel:                  logger.Warn,
+	SlowThreshold:             200 * time.Millisecond,
+	IgnoreRecordNotFoundError: true,
+	ParameterizedQueries:      true,
+})
+
 func GetDB() (*gorm.DB, error) {
 	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{
-		Logger:                 logger.Default.LogMode(logger.Silent),
+		Logger:                 gormLogger,
+		SkipDefaultTransaction: true,
+	})
+	return db, err
+}
+
+// GetFileDB opens a database stored on disk, for state that must survive restarts.
+func GetFileDB(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(path+"?_busy_timeout=5000"), &gorm.Config{
+		Logger:                 gormLogger,
 		SkipDefaultTransaction: true,
 	})
 	return db, err
@@ -73,10 +73,14 @@ func (s *FederatedServerStore) GetFederatedServer(trackerHost string, trackerPor
 
 func (s *FederatedServerStore) GetFederatedServers(trackerHost string, trackerPort uint16) ([]FederatedServer, error) {
 	var servers []FederatedServer
-	err := s.db.Where("tracker_host = ? AND tracker_port = ?", trackerHost, trackerPort).Find(&servers).Order("server_order").Error
+	err := s.db.Where("tracker_host = ? AND tracker_port = ?", trackerHost, trackerPort).Order("server_order").Find(&servers).Error
 	return servers, err
 }
 
+func (s *FederatedServerStore) RemoveFederatedServers(trackerHost string, trackerPort uint16) error {
+	return s.db.Unscoped().Where("tracker_host = ? AND tracker_port = ?", trackerHost, trackerPort).Delete(&FederatedServer{}).Error
+}
+
 func (s *FederatedServerStore) ExpireFederatedServers(expiration time.Duration) error {
 	return s.db.Where("last_seen < ?", time.Now().Add(-expiration)).Delete(&FederatedServer{}).Error
 }
@@ -13,12 +13,22 @@ type FederatedTrackerId struct {
 type FederatedTracker struct {
 	gorm.Model
 	FederatedTrackerId
-	Name         string `gorm:"not null"`
-	Description  string
-	UserCount    uint16 `gorm:"not null"`
-	FirstSeen    time.Time
-	LastSeen     time.Time
-	TrackerOrder uint16 `gorm:"not null"`
+	Name          string `gorm:"not null"`
+	Description   string
+	UserCount     uint16 `gorm:"not null"`
+	FirstSeen     time.Time
+	LastSeen      time.Time
+	TrackerOrder  uint16     `gorm:"not null"`
+	Tls           TrackerTls `gorm:"embedded;embeddedPrefix:tls_"`
+	LastPolled    time.Time  // When the tracker was last polled, successfully or not
+	LastPollError string     // Why the last poll failed, empty when it succeeded
+}
+
+// TrackerTls controls whether a federated tracker is polled over TLS and how its certificate is verified.
+type TrackerTls struct {
+	Enabled    bool
+	ServerName string // Name the certificate is verified for, the tracker's host when empty
+	CAFile     string // PEM bundle the certificate is verified with, the system roots when empty
 }
 
 type FederatedTrackerStore struct {
@@ -34,7 +44,7 @@ func NewFederatedTrackerStore(db *gorm.DB) (*FederatedTrackerStore, error) {
 	return &FederatedTrackerStore{db}, nil
 }
 
-func (s *FederatedTrackerStore) RegisterFederatedTracker(host string, port uint16, name string, description string, userCount uint16, order uint16) (FederatedTracker, error) {
+func (s *FederatedTrackerStore) RegisterFederatedTracker(host string, port uint16, name string, description string, userCount uint16, order uint16, trackerTls TrackerTls) (FederatedTracker, error) {
 
 	tracker := FederatedTracker{
 		FederatedTrackerId: FederatedTrackerId{
@@ -45,6 +55,7 @@ func (s *FederatedTrackerStore) RegisterFederatedTracker(host string, port uint1
 		Description:  description,
 		UserCount:    userCount,
 		TrackerOrder: order,
+		Tls:          trackerTls,
 	}
 
 	return tracker, s.db.Create(&tracker).Error
@@ -60,6 +71,25 @@ func (s *FederatedTrackerStore) UpdateLastSeen(host string, port uint16) error {
 	return s.db.Model(&FederatedTracker{}).Where("host = ? AND port = ?", host, port).Update("last_seen", time.Now()).Error
 }
 
+// RecordPoll stores the outcome of polling a tracker. A successful poll also marks the tracker as seen.
+func (s *FederatedTrackerStore) RecordPoll(host string, port uint16, pollError error) error {
+
+	now := time.Now()
+	updates := map[string]any{"last_polled": now, "last_poll_error": ""}
+
+	if pollError != nil {
+		updates["last_poll_error"] = pollError.Error()
+	} else {
+		updates["last_seen"] = now
+	}
+
+	if err := s.db.Model(&FederatedTracker{}).Where("host = ? AND port = ?", host, port).Updates(updates).Error; err != nil || pollError != nil {
+		return err
+	}
+
+	return s.db.Model(&FederatedTracker{}).Where("host = ? AND port = ? AND first_seen = ?", host, port, time.Time{}).Update("first_seen", now).Error
+}
+
 func (s *FederatedTrackerStore) GetFederatedTracker(host string, port uint16) (FederatedTracker, error) {
 
 	var tracker FederatedTracker
@@ -73,7 +103,48 @@ func (s *FederatedTrackerStore) GetFederatedTrackers() ([]FederatedTracker, erro
 
 	var trackers []FederatedTracker
 
-	err := s.db.Find(&trackers).Order("tracker_order").Error
+	err := s.db.Order("tracker_order").Find(&trackers).Error
 
 	return trackers, err
 }
+
+func (s *FederatedTrackerStore) GetFederatedTrackerByID(id uint) (FederatedTracker, error) {
+
+	var tracker FederatedTracker
+
+	err := s.db.Where("id = ?", id).First(&tracker).Error
+
+	return tracker, err
+}
+
+func (s *FederatedTrackerStore) UpdateFederatedTracker(id uint, host string, port uint16, name string, description string, userCount uint16, trackerTls TrackerTls) error {
+
+	return s.db.Model(&FederatedTracker{}).Where("id = ?", id).Updates(map[string]any{
+		"host":            host,
+		"port":            port,
+		"name":            name,
+		"description":     description,
+		"user_count":      userCount,
+		"tls_enabled":     trackerTls.Enabled,
+		"tls_server_name": trackerTls.ServerName,
+		"tls_ca_file":     trackerTls.CAFile,
+	}).Error
+}
+
+func (s *FederatedTrackerStore) RemoveFederatedTracker(id uint) error {
+
+	return s.db.Unscoped().Where("id = ?", id).Delete(&FederatedTracker{}).Error
+}
+
+// SetFederatedTrackerOrder renumbers the federated trackers in the order of the supplied IDs.
+func (s *FederatedTrackerStore) SetFederatedTrackerOrder(ids []uint) error {
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for order, id := range ids {
+			if err := tx.Model(&FederatedTracker{}).Where("id = ?", id).Update("tracker_order", order).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// UserCountSample aggregates the user counts a server reported during one bucket of time. Raw samples cover one
+// sampling interval each and are merged into hourly samples once they age.
+type UserCountSample struct {
+	ID         uint      `gorm:"primarykey"`
+	PassID     uint32    `gorm:"index:idx_user_count_sample,priority:1"`
+	Bucket     time.Time `gorm:"index:idx_user_count_sample,priority:2"` // Start of the period the sample covers
+	Resolution time.Duration
+	Name       string // Name of the server at the time of the sample
+	MinUsers   uint16
+	MaxUsers   uint16
+	SumUsers   uint64 // Sum of the sampled user counts, divided by Count for the average
+	Count      uint32 // Number of raw samples merged into this one
+}
+
+// TrackerSample aggregates the number of registered servers and their users across the whole tracker.
+type TrackerSample struct {
+	ID         uint      `gorm:"primarykey"`
+	Bucket     time.Time `gorm:"index"`
+	Resolution time.Duration
+	MaxServers uint32
+	SumServers uint64
+	MaxUsers   uint64
+	SumUsers   uint64
+	Count      uint32
+}
+
+// ServerTransition records a server coming online (registering) or going offline (expiring).
+type ServerTransition struct {
+	ID     uint      `gorm:"primarykey"`
+	PassID uint32    `gorm:"index:idx_server_transition,priority:1"`
+	Time   time.Time `gorm:"index:idx_server_transition,priority:2"`
+	Online bool
+}
+
+type HistoryStore struct {
+	db *gorm.DB
+}
+
+func NewHistoryStore(db *gorm.DB) (*HistoryStore, error) {
+	if err := db.AutoMigrate(&UserCountSample{}, &TrackerSample{}, &ServerTransition{}); err != nil {
+		return nil, err
+	}
+
+	return &HistoryStore{db}, nil
+}
+
+func (s *HistoryStore) AddSamples(serverSamples []UserCountSample, trackerSample TrackerSample) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if len(serverSamples) > 0 {
+			if err := tx.Create(&serverSamples).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&trackerSample).Error
+	})
+}
+
+func (s *HistoryStore) AddTransition(passID uint32, at time.Time, online bool) error {
+	return s.db.Create(&ServerTransition{PassID: passID, Time: at, Online: online}).Error
+}
+
+// GetServerSamples returns the samples of a server since the given time, oldest first.
+func (s *HistoryStore) GetServerSamples(passID uint32, since time.Time) ([]UserCountSample, error) {
+	var samples []UserCountSample
+	err := s.db.Where("pass_id = ? AND bucket >= ?", passID, since).Order("bucket").Find(&samples).Error
+	return samples, err
+}
+
+// GetAllServerSamples returns the samples of every server since the given time, oldest first.
+func (s *HistoryStore) GetAllServerSamples(since time.Time) ([]UserCountSample, error) {
+	var samples []UserCountSample
+	err := s.db.Where("bucket >= ?", since).Order("bucket").Find(&samples).Error
+	return samples, err
+}
+
+func (s *HistoryStore) GetTrackerSamples(since time.Time) ([]TrackerSample, error) {
+	var samples []TrackerSample
+	err := s.db.Where("bucket >= ?", since).Order("bucket").Find(&samples).Error
+	return samples, err
+}
+
+// GetTransitions returns the transitions of every server since the given time, oldest first, preceded for each
+// server by its last transition before that time so that its state at the start is known.
+func (s *HistoryStore) GetTransitions(since time.Time) ([]ServerTransition, error) {
+	var earlier []ServerTransition
+	err := s.db.Where("id IN (?)", s.db.Model(&ServerTransition{}).Select("MAX(id)").Where("time < ?", since).Group("pass_id")).
+		Find(&earlier).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var transitions []ServerTransition
+	if err := s.db.Where("time >= ?", since).Order("time, id").Find(&transitions).Error; err != nil {
+		return nil, err
+	}
+
+	return append(earlier, transitions...), nil
+}
+
+// GetOnlineServers returns the pass IDs of servers whose last transition brought them online, with the time of
+// their most recent sample.
+func (s *HistoryStore) GetOnlineServers() (map[uint32]time.Time, error) {
+	var lastTransitions []ServerTransition
+	err := s.db.Where("id IN (?)", s.db.Model(&ServerTransition{}).Select("MAX(id)").Group("pass_id")).Find(&lastTransitions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	online := make(map[uint32]time.Time)
+
+	for _, transition := range lastTransitions {
+		if !transition.Online {
+			continue
+		}
+
+		lastSeen := transition.Time
+
+		var sample UserCountSample
+		if err := s.db.Where("pass_id = ?", transition.PassID).Order("bucket desc").Limit(1).Find(&sample).Error; err != nil {
+			return nil, err
+		}
+		if sample.Bucket.After(lastSeen) {
+			lastSeen = sample.Bucket.Add(sample.Resolution)
+		}
+
+		online[transition.PassID] = lastSeen
+	}
+
+	return online, nil
+}
+
+// Downsample merges the samples with a finer resolution than the given one that start before the cutoff into
+// samples of that resolution.
+func (s *HistoryStore) Downsample(cutoff time.Time, resolution time.Duration) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var serverSamples []UserCountSample
+		if err := tx.Where("bucket < ? AND resolution < ?", cutoff, resolution).Order("bucket").Find(&serverSamples).Error; err != nil {
+			return err
+		}
+
+		type serverBucket struct {
+			passID uint32
+			bucket time.Time
+		}
+
+		var serverOrder []serverBucket
+		mergedServers := make(map[serverBucket]*UserCountSample)
+
+		for _, sample := range serverSamples {
+			key := serverBucket{sample.PassID, sample.Bucket.Truncate(resolution)}
+
+			merged, ok := mergedServers[key]
+			if !ok {
+				merged = &UserCountSample{PassID: key.passID, Bucket: key.bucket, Resolution: resolution, MinUsers: sample.MinUsers}
+				mergedServers[key] = merged
+				serverOrder = append(serverOrder, key)
+			}
+
+			merged.Name = sample.Name
+			merged.MinUsers = min(merged.MinUsers, sample.MinUsers)
+			merged.MaxUsers = max(merged.MaxUsers, sample.MaxUsers)
+			merged.SumUsers += sample.SumUsers
+			merged.Count += sample.Count
+		}
+
+		var trackerSamples []TrackerSample
+		if err := tx.Where("bucket < ? AND resolution < ?", cutoff, resolution).Order("bucket").Find(&trackerSamples).Error; err != nil {
+			return err
+		}
+
+		var trackerOrder []time.Time
+		mergedTracker := make(map[time.Time]*TrackerSample)
+
+		for _, sample := range trackerSamples {
+			bucket := sample.Bucket.Truncate(resolution)
+
+			merged, ok := mergedTracker[bucket]
+			if !ok {
+				merged = &TrackerSample{Bucket: bucket, Resolution: resolution}
+				mergedTracker[bucket] = merged
+				trackerOrder = append(trackerOrder, bucket)
+			}
+
+			merged.MaxServers = max(merged.MaxServers, sample.MaxServers)
+			merged.SumServers += sample.SumServers
+			merged.MaxUsers = max(merged.MaxUsers, sample.MaxUsers)
+			merged.SumUsers += sample.SumUsers
+			merged.Count += sample.Count
+		}
+
+		if err := tx.Where("bucket < ? AND resolution < ?", cutoff, resolution).Delete(&UserCountSample{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("bucket < ? AND resolution < ?", cutoff, resolution).Delete(&TrackerSample{}).Error; err != nil {
+			return err
+		}
+
+		for _, key := range serverOrder {
+			if err := tx.Create(mergedServers[key]).Error; err != nil {
+				return err
+			}
+		}
+		for _, bucket := range trackerOrder {
+			if err := tx.Create(mergedTracker[bucket]).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// RemoveHistoryBefore deletes samples and transitions older than the given time.
+func (s *HistoryStore) RemoveHistoryBefore(before time.Time) error {
+	if err := s.db.Where("bucket < ?", before).Delete(&UserCountSample{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("bucket < ?", before).Delete(&TrackerSample{}).Error; err != nil {
+		return err
+	}
+	return s.db.Where("time < ?", before).Delete(&ServerTransition{}).Error
+}
@@ -1,24 +1,27 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"gorm.io/gorm"
-	"log"
 	"time"
 )
 
 type RegisteredServer struct {
-	PassID      uint32 `gorm:"primaryKey"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	DeletedAt   gorm.DeletedAt `gorm:"index"`
-	Host        string
-	Port        uint16
-	Name        string
-	Description string
-	UserCount   uint16
-	LastSeen    time.Time
-	FirstSeen   time.Time
+	PassID        uint32 `gorm:"primaryKey"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+	Host          string
+	Port          uint16
+	Name          string
+	Description   string
+	UserCount     uint16
+	LastSeen      time.Time
+	FirstSeen     time.Time
+	Origin        string // Node name of the peer tracker the server was replicated from, empty when registered locally
+	PasswordEntry string `gorm:"index"` // Name of the password entry the server registered with, empty without passwords
+	Verified      bool   // Registered with a valid password, locally or on the peer tracker it was replicated from
 }
 
 type RegisteredServerStore struct {
@@ -33,30 +36,87 @@ func NewRegisteredServerStore(db *gorm.DB) (*RegisteredServerStore, error) {
 	return &RegisteredServerStore{db}, nil
 }
 
-func (r *RegisteredServerStore) RegisterNewServer(passID uint32, host string, port uint16, name string, description string, userCount uint16) error {
+// RegisterNewServer creates or refreshes a locally registered server. verified tells whether its password or signature
+// matched an entry, as entries may be nameless. It returns the stored server, whether the server was previously unknown
+// and whether a known server's listing changed.
+func (r *RegisteredServerStore) RegisterNewServer(passID uint32, host string, port uint16, name string, description string, userCount uint16, verified bool, passwordEntry string) (RegisteredServer, bool, bool, error) {
 
 	server := RegisteredServer{
-		PassID:      passID,
-		Host:        host,
-		Port:        port,
-		Name:        name,
-		Description: description,
-		UserCount:   userCount,
-		LastSeen:    time.Now(),
-		FirstSeen:   time.Now(),
+		PassID:        passID,
+		Host:          host,
+		Port:          port,
+		Name:          name,
+		Description:   description,
+		UserCount:     userCount,
+		LastSeen:      time.Now(),
+		FirstSeen:     time.Now(),
+		PasswordEntry: passwordEntry,
+		Verified:      verified,
 	}
 
-	var count int64
-	existingServer := RegisteredServer{}
-	r.db.First(&existingServer, passID).Count(&count)
+	existingServer, err := r.GetRegisteredServer(passID)
+	isNew := errors.Is(err, gorm.ErrRecordNotFound)
+	changed := false
+
+	if !isNew && err == nil {
+		server.FirstSeen = existingServer.FirstSeen
+		server.CreatedAt = existingServer.CreatedAt
+		changed = !server.SameListing(existingServer)
+	}
 
 	if createError := r.db.Save(&server).Error; createError != nil {
-		return fmt.Errorf("could not register server because of an internal error: %s", createError)
-	} else if count == 0 {
-		log.Printf("Registered new server: %s (%s:%d)", name, host, port)
+		return server, false, false, fmt.Errorf("could not register server because of an internal error: %s", createError)
 	}
 
-	return nil
+	return server, isNew, changed, nil
+}
+
+// SameListing reports whether both servers would be listed identically.
+func (s RegisteredServer) SameListing(other RegisteredServer) bool {
+	return s.Host == other.Host && s.Port == other.Port && s.Name == other.Name &&
+		s.Description == other.Description && s.UserCount == other.UserCount && s.Verified == other.Verified
+}
+
+// ApplyPeerServer stores a server replicated from a peer tracker. Servers that registered locally always take
+// precedence over replicated copies, in which case the server is left untouched and applied is false. changed
+// reports whether an already known server's listing changed.
+func (r *RegisteredServerStore) ApplyPeerServer(server RegisteredServer) (applied bool, isNew bool, changed bool, err error) {
+
+	existingServer, err := r.GetRegisteredServer(server.PassID)
+
+	if err == nil {
+		if existingServer.Origin == "" {
+			return false, false, false, nil
+		}
+		server.CreatedAt = existingServer.CreatedAt
+		changed = !server.SameListing(existingServer)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, false, false, err
+	} else {
+		isNew = true
+	}
+
+	if saveError := r.db.Save(&server).Error; saveError != nil {
+		return false, false, false, fmt.Errorf("could not apply peer server because of an internal error: %s", saveError)
+	}
+
+	return true, isNew, changed, nil
+}
+
+func (r *RegisteredServerStore) GetRegisteredServer(passID uint32) (RegisteredServer, error) {
+	var server RegisteredServer
+	err := r.db.First(&server, passID).Error
+	return server, err
+}
+
+// CountPasswordEntryServers returns how many locally registered servers other than passID registered with the
+// password entry.
+func (r *RegisteredServerStore) CountPasswordEntryServers(passwordEntry string, passID uint32) (int64, error) {
+	var count int64
+	err := r.db.Model(&RegisteredServer{}).
+		Where("password_entry = ? AND origin = ? AND pass_id <> ?", passwordEntry, "", passID).
+		Count(&count).Error
+	return count, err
 }
 
 func (r *RegisteredServerStore) GetAllRegisteredServers() ([]RegisteredServer, error) {
@@ -68,15 +128,31 @@ func (r *RegisteredServerStore) GetAllRegisteredServers() ([]RegisteredServer, e
 	return servers, nil
 }
 
-func (r *RegisteredServerStore) RemoveExpiredServers(expirationTime time.Duration) {
+// RemoveServer deletes a server from the registry and returns the removed record.
+func (r *RegisteredServerStore) RemoveServer(passID uint32) (RegisteredServer, error) {
+	server, err := r.GetRegisteredServer(passID)
+
+	if err != nil {
+		return server, err
+	}
+
+	return server, r.db.Delete(&server).Error
+}
+
+// RemoveExpiredServers deletes every server that has not been seen within the expiration time and returns the
+// servers that were removed.
+func (r *RegisteredServerStore) RemoveExpiredServers(expirationTime time.Duration) []RegisteredServer {
 	var registeredServers []RegisteredServer
+	var expiredServers []RegisteredServer
 
 	r.db.Find(&registeredServers)
 
 	for _, server := range registeredServers {
 		if time.Since(server.LastSeen).Minutes() > expirationTime.Minutes() {
 			r.db.Delete(&server).Commit()
-			log.Printf("Removed expired server %s", server.Name)
+			expiredServers = append(expiredServers, server)
 		}
 	}
+
+	return expiredServers
 }
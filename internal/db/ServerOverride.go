@@ -0,0 +1,100 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+// ServerOverride changes how a registered server is listed. Overrides are keyed by pass ID rather than stored on
+// the registered server, so they survive the server expiring and registering again.
+type ServerOverride struct {
+	PassID      uint32 `gorm:"primaryKey;autoincrement:false"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Name        string // Name shown instead of the registered name, empty to keep it
+	Description string // Description shown instead of the registered description, empty to keep it
+	Pinned      bool   // Pinned servers are listed before the other registered servers
+}
+
+type ServerOverrideStore struct {
+	db *gorm.DB
+}
+
+func NewServerOverrideStore(db *gorm.DB) (*ServerOverrideStore, error) {
+	if err := db.AutoMigrate(&ServerOverride{}); err != nil {
+		return nil, err
+	}
+
+	return &ServerOverrideStore{db}, nil
+}
+
+func (s *ServerOverrideStore) SetOverride(passID uint32, name string, description string, pinned bool) (ServerOverride, error) {
+	override := ServerOverride{
+		PassID:      passID,
+		Name:        name,
+		Description: description,
+		Pinned:      pinned,
+	}
+
+	return override, s.db.Save(&override).Error
+}
+
+func (s *ServerOverrideStore) RemoveOverride(passID uint32) error {
+	return s.db.Where("pass_id = ?", passID).Delete(&ServerOverride{}).Error
+}
+
+func (s *ServerOverrideStore) GetOverride(passID uint32) (ServerOverride, error) {
+	var override ServerOverride
+	err := s.db.Where("pass_id = ?", passID).First(&override).Error
+	return override, err
+}
+
+func (s *ServerOverrideStore) GetOverrides() ([]ServerOverride, error) {
+	var overrides []ServerOverride
+	err := s.db.Order("pass_id").Find(&overrides).Error
+	return overrides, err
+}
+
+// ApplyOverrides returns the servers as they should be listed: with overridden names and descriptions, and with
+// pinned servers moved to the front. The relative order of the servers is otherwise kept.
+func (s *ServerOverrideStore) ApplyOverrides(servers []RegisteredServer) ([]RegisteredServer, map[uint32]ServerOverride, error) {
+	overrides, err := s.GetOverrides()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	overridesByPassID := make(map[uint32]ServerOverride, len(overrides))
+	for _, override := range overrides {
+		overridesByPassID[override.PassID] = override
+	}
+
+	listed := slices.Clone(servers)
+
+	for i, server := range listed {
+		if override, ok := overridesByPassID[server.PassID]; ok {
+			if override.Name != "" {
+				listed[i].Name = override.Name
+			}
+
+			if override.Description != "" {
+				listed[i].Description = override.Description
+			}
+		}
+	}
+
+	slices.SortStableFunc(listed, func(a RegisteredServer, b RegisteredServer) int {
+		aPinned := overridesByPassID[a.PassID].Pinned
+		bPinned := overridesByPassID[b.PassID].Pinned
+
+		if aPinned == bPinned {
+			return 0
+		} else if aPinned {
+			return -1
+		}
+
+		return 1
+	})
+
+	return listed, overridesByPassID, nil
+}
@@ -39,9 +39,41 @@ func (s *StaticServerStore) RegisterStaticServer(host string, port uint16, name
 	return server, s.db.Create(&server).Error
 }
 
+func (s *StaticServerStore) UpdateStaticServer(id uint, host string, port uint16, name string, description string, userCount uint16) error {
+	return s.db.Model(&StaticServer{}).Where("id = ?", id).Updates(map[string]any{
+		"host":        host,
+		"port":        port,
+		"name":        name,
+		"description": description,
+		"user_count":  userCount,
+	}).Error
+}
+
+func (s *StaticServerStore) RemoveStaticServer(id uint) error {
+	return s.db.Unscoped().Where("id = ?", id).Delete(&StaticServer{}).Error
+}
+
+// SetStaticServerOrder renumbers the static servers in the order of the supplied IDs.
+func (s *StaticServerStore) SetStaticServerOrder(ids []uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for order, id := range ids {
+			if err := tx.Model(&StaticServer{}).Where("id = ?", id).Update("server_order", order).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *StaticServerStore) GetStaticServer(id uint) (StaticServer, error) {
+	var server StaticServer
+	err := s.db.Where("id = ?", id).First(&server).Error
+	return server, err
+}
+
 func (s *StaticServerStore) GetStaticServers() ([]StaticServer, error) {
 	var servers []StaticServer
-	if err := s.db.Find(&servers).Order("server_order").Error; err != nil {
+	if err := s.db.Order("server_order").Find(&servers).Error; err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"   // Waiting for its first or next attempt
+	DeliveryDelivered DeliveryStatus = "delivered" // Accepted by the endpoint with a 2xx status
+	DeliveryFailed    DeliveryStatus = "failed"    // Given up after the maximum number of attempts
+)
+
+// WebhookDelivery is a rendered webhook request and the state of its delivery.
+type WebhookDelivery struct {
+	gorm.Model
+	Hook           string `gorm:"index"` // Name of the webhook the delivery is for
+	EventID        uint64
+	EventType      string
+	Payload        string
+	Status         DeliveryStatus `gorm:"index"`
+	Attempts       int
+	NextAttempt    time.Time `gorm:"index"`
+	LastAttempt    time.Time
+	LastStatusCode int    // HTTP status of the last attempt, zero when no response was received
+	LastError      string // Why the last attempt failed
+	DeliveredAt    time.Time
+}
+
+type WebhookDeliveryStore struct {
+	db *gorm.DB
+}
+
+func NewWebhookDeliveryStore(db *gorm.DB) (*WebhookDeliveryStore, error) {
+	if err := db.AutoMigrate(&WebhookDelivery{}); err != nil {
+		return nil, err
+	}
+
+	return &WebhookDeliveryStore{db}, nil
+}
+
+func (s *WebhookDeliveryStore) Enqueue(hook string, eventID uint64, eventType string, payload string) (WebhookDelivery, error) {
+	delivery := WebhookDelivery{
+		Hook:        hook,
+		EventID:     eventID,
+		EventType:   eventType,
+		Payload:     payload,
+		Status:      DeliveryPending,
+		NextAttempt: time.Now(),
+	}
+
+	return delivery, s.db.Create(&delivery).Error
+}
+
+// GetDueDeliveries returns pending deliveries of a webhook whose next attempt is due, oldest first.
+func (s *WebhookDeliveryStore) GetDueDeliveries(hook string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := s.db.Where("hook = ? AND status = ? AND next_attempt <= ?", hook, DeliveryPending, time.Now()).Order("next_attempt, id").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// FailOrphanedDeliveries gives up on the pending deliveries of webhooks other than the given ones.
+func (s *WebhookDeliveryStore) FailOrphanedDeliveries(hooks []string, reason string) (int64, error) {
+	query := s.db.Model(&WebhookDelivery{}).Where("status = ?", DeliveryPending)
+	if len(hooks) > 0 {
+		query = query.Where("hook NOT IN ?", hooks)
+	}
+
+	result := query.Updates(map[string]any{"status": DeliveryFailed, "last_error": reason, "last_attempt": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+func (s *WebhookDeliveryStore) GetDelivery(id uint) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := s.db.Where("id = ?", id).First(&delivery).Error
+	return delivery, err
+}
+
+// DeliveryQuery selects a page of deliveries.
+type DeliveryQuery struct {
+	Hook   string         // Only deliveries of this webhook, all when empty
+	Status DeliveryStatus // Only deliveries in this status, all when empty
+	Search string         // Lower case text the webhook, event type or last error contains
+	Sort   string         // Column to sort by, newest first when empty
+	Desc   bool           // Sort descending
+	Limit  int            // Maximum number of deliveries, zero for no limit
+	Offset int            // Number of matching deliveries to skip
+}
+
+// GetDeliveries returns a page of the deliveries matching the query, along with how many match in total. Deliveries
+// sorting equally stay newest first.
+func (s *WebhookDeliveryStore) GetDeliveries(deliveryQuery DeliveryQuery) ([]WebhookDelivery, int64, error) {
+	query := s.db.Model(&WebhookDelivery{})
+
+	if deliveryQuery.Hook != "" {
+		query = query.Where("hook = ?", deliveryQuery.Hook)
+	}
+	if deliveryQuery.Status != "" {
+		query = query.Where("status = ?", deliveryQuery.Status)
+	}
+	if deliveryQuery.Search != "" {
+		pattern := "%" + likeEscaper.Replace(deliveryQuery.Search) + "%"
+		query = query.Where(`(lower(hook) LIKE ? ESCAPE '\' OR lower(event_type) LIKE ? ESCAPE '\' OR lower(last_error) LIKE ? ESCAPE '\')`,
+			pattern, pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if deliveryQuery.Sort != "" {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: deliveryQuery.Sort}, Desc: deliveryQuery.Desc})
+	}
+	query = query.Order("id desc").Offset(deliveryQuery.Offset)
+
+	if deliveryQuery.Limit > 0 {
+		query = query.Limit(deliveryQuery.Limit)
+	}
+
+	var deliveries []WebhookDelivery
+	err := query.Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, for use with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *WebhookDeliveryStore) SaveDelivery(delivery *WebhookDelivery) error {
+	return s.db.Save(delivery).Error
+}
+
+// CountByStatus returns the number of deliveries of a webhook in each status.
+func (s *WebhookDeliveryStore) CountByStatus(hook string) (map[DeliveryStatus]int64, error) {
+	var rows []struct {
+		Status DeliveryStatus
+		Count  int64
+	}
+
+	err := s.db.Model(&WebhookDelivery{}).Select("status, count(*) as count").Where("hook = ?", hook).Group("status").Scan(&rows).Error
+
+	counts := make(map[DeliveryStatus]int64)
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	return counts, err
+}
+
+// RemoveFinishedDeliveries deletes delivered and failed deliveries last attempted before the given time.
+func (s *WebhookDeliveryStore) RemoveFinishedDeliveries(before time.Time) error {
+	return s.db.Unscoped().Where("status <> ? AND last_attempt < ?", DeliveryPending, before).Delete(&WebhookDelivery{}).Error
+}
@@ -0,0 +1,399 @@
+package directory
+
+import (
+	"embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html/template"
+	"magnetron/internal/config"
+	"magnetron/internal/db"
+	"magnetron/internal/listing"
+	"magnetron/internal/logging"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	pageTemplate           = "directory.html"
+	templateReloadInterval = 5 * time.Second
+)
+
+var (
+	//go:embed templates
+	templateResources embed.FS
+
+	logger = logging.For(logging.API)
+)
+
+// Page is the data the directory template is executed with.
+type Page struct {
+	Title       string
+	Description string
+	Updated     time.Time
+	Sections    []Section
+	Servers     int // Number of servers in every section
+	Users       int // Users on every server
+	Feeds       Feeds
+}
+
+// Feeds holds the URLs of the directory's feeds, relative to the page.
+type Feeds struct {
+	RSS  string
+	Atom string
+	JSON string
+}
+
+// Section is a run of servers of the listing: the servers at its top, those below a section header, or those of a
+// federated tracker.
+type Section struct {
+	Name        string // Header or federated tracker name, empty for the servers at the top of the listing
+	Description string // Description of the federated tracker
+	Tracker     string // Address of the federated tracker, empty for other sections
+	Servers     []Server
+	Users       int
+}
+
+type Server struct {
+	Name        string
+	Description string
+	Host        string
+	Port        uint16
+	Address     string // host:port
+	Users       int
+	Static      bool      // A static entry from the configuration
+	Since       time.Time // When the server was first seen since it was last delisted, zero for static entries
+}
+
+// Directory renders the public directory page and the feeds of newly registered servers.
+type Directory struct {
+	cfg                   config.DirectoryConfig
+	listingBuilder        *listing.Builder
+	registeredServerStore *db.RegisteredServerStore
+	serverOverrideStore   *db.ServerOverrideStore
+	mu                    sync.RWMutex
+	templates             *template.Template
+	templateModTimes      map[string]time.Time // Modification times of the files in TemplateDir when they were parsed
+}
+
+func NewDirectory(database *gorm.DB, cfg *config.Config, listingBuilder *listing.Builder) (*Directory, error) {
+
+	if directoryErrors := cfg.Directory.Validate(); len(directoryErrors) > 0 {
+		return nil, errors.Join(directoryErrors...)
+	}
+
+	registeredServerStore, err := db.NewRegisteredServerStore(database)
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing registered server store: %s", err)
+	}
+
+	serverOverrideStore, err := db.NewServerOverrideStore(database)
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing server override store: %s", err)
+	}
+
+	directory := &Directory{
+		cfg:                   cfg.Directory,
+		listingBuilder:        listingBuilder,
+		registeredServerStore: registeredServerStore,
+		serverOverrideStore:   serverOverrideStore,
+	}
+
+	if directory.cfg.Path == "" {
+		directory.cfg.Path = config.DefaultDirectoryPath
+	}
+	if directory.cfg.Title == "" {
+		directory.cfg.Title = config.DefaultDirectoryTitle
+	}
+	if directory.cfg.FeedItems == 0 {
+		directory.cfg.FeedItems = config.DefaultDirectoryFeedItems
+	}
+
+	modTimes, err := directory.templateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if directory.templates, err = directory.parseTemplates(modTimes); err != nil {
+		return nil, err
+	}
+	directory.templateModTimes = modTimes
+
+	return directory, nil
+}
+
+// Path returns the URL path the directory is served at.
+func (d *Directory) Path() string {
+	return d.cfg.Path
+}
+
+// templateFiles returns the modification time of every template in TemplateDir, none without one.
+func (d *Directory) templateFiles() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+
+	if d.cfg.TemplateDir == "" {
+		return modTimes, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(d.cfg.TemplateDir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.html templates found in %s", d.cfg.TemplateDir)
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+// parseTemplates parses the built-in templates, then the files of TemplateDir, so that a file replaces the built-in
+// template of the same name and may redefine the blocks the built-in templates declare.
+func (d *Directory) parseTemplates(modTimes map[string]time.Time) (*template.Template, error) {
+	templates, err := template.New(pageTemplate).Funcs(templateFuncs).ParseFS(templateResources, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(modTimes) == 0 {
+		return templates, nil
+	}
+
+	paths := make([]string, 0, len(modTimes))
+	for path := range modTimes {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	if templates, err = templates.ParseFiles(paths...); err != nil {
+		return nil, fmt.Errorf("could not parse directory templates: %w", err)
+	}
+
+	if templates.Lookup(pageTemplate) == nil {
+		return nil, fmt.Errorf("directory templates do not define %s", pageTemplate)
+	}
+
+	return templates, nil
+}
+
+// Watch reparses the templates of TemplateDir whenever a file is added, removed or changed. Templates that fail to
+// parse leave the previous ones in use.
+func (d *Directory) Watch() {
+	if d.cfg.TemplateDir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(templateReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		modTimes, err := d.templateFiles()
+		if err != nil {
+			logger.Warn("Could not check directory templates for changes", "template_dir", d.cfg.TemplateDir, logging.Err(err))
+			continue
+		}
+
+		if sameModTimes(modTimes, d.templateModTimes) {
+			continue
+		}
+		d.templateModTimes = modTimes
+
+		templates, err := d.parseTemplates(modTimes)
+		if err != nil {
+			logger.Error("Could not reload directory templates, keeping the previous ones", "template_dir", d.cfg.TemplateDir, logging.Err(err))
+			continue
+		}
+
+		d.mu.Lock()
+		d.templates = templates
+		d.mu.Unlock()
+
+		logger.Info("Reloaded directory templates", "template_dir", d.cfg.TemplateDir)
+	}
+}
+
+func sameModTimes(a map[string]time.Time, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for path, modTime := range a {
+		if !modTime.Equal(b[path]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// page groups the listing into sections. Each header and federated tracker starts a new section, and sections
+// without servers are left out.
+func (d *Directory) page() (Page, error) {
+	rows, err := d.listingBuilder.Build()
+
+	page := Page{
+		Title:       d.cfg.Title,
+		Description: d.cfg.Description,
+		Updated:     time.Now(),
+		Sections:    []Section{},
+		Feeds:       Feeds{RSS: "feed.rss", Atom: "feed.atom", JSON: "feed.json"},
+	}
+
+	section := Section{}
+
+	for _, row := range rows {
+		msg := row.Message
+		name, description := string(msg.Name), string(msg.Description)
+		host := net.IPv4(msg.IPAddr[0], msg.IPAddr[1], msg.IPAddr[2], msg.IPAddr[3]).String()
+		port := binary.BigEndian.Uint16(msg.Port[:])
+
+		switch row.Kind {
+		case listing.HeaderRow, listing.FederatedTrackerRow:
+			if len(section.Servers) > 0 {
+				page.Sections = append(page.Sections, section)
+			}
+
+			section = Section{Name: name}
+			if row.Kind == listing.FederatedTrackerRow {
+				section.Description = description
+				section.Tracker = net.JoinHostPort(host, strconv.Itoa(int(port)))
+			}
+
+		default:
+			users := int(binary.BigEndian.Uint16(msg.NumUsers[:]))
+
+			section.Servers = append(section.Servers, Server{
+				Name:        name,
+				Description: description,
+				Host:        host,
+				Port:        port,
+				Address:     net.JoinHostPort(host, strconv.Itoa(int(port))),
+				Users:       users,
+				Static:      row.Kind == listing.StaticRow,
+				Since:       row.Since,
+			})
+			section.Users += users
+			page.Servers++
+			page.Users += users
+		}
+	}
+
+	if len(section.Servers) > 0 {
+		page.Sections = append(page.Sections, section)
+	}
+
+	return page, err
+}
+
+// ServePage renders the directory page.
+func (d *Directory) ServePage(w http.ResponseWriter, request *http.Request) {
+
+	page, err := d.page()
+	if err != nil {
+		// Sections that could be built are still shown.
+		logger.Error("Could not build listing for the directory", logging.Err(err))
+	}
+
+	d.mu.RLock()
+	templates := d.templates
+	d.mu.RUnlock()
+
+	var body strings.Builder
+	if err := templates.ExecuteTemplate(&body, pageTemplate, page); err != nil {
+		logger.Error("Could not render directory", logging.Err(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(body.String()))
+}
+
+// baseURL returns the absolute URL of the directory page, from BaseURL or else from the request.
+func (d *Directory) baseURL(request *http.Request) string {
+	if d.cfg.BaseURL != "" {
+		return strings.TrimSuffix(d.cfg.BaseURL, "/") + d.cfg.Path
+	}
+
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + request.Host + d.cfg.Path
+}
+
+// newestServers returns the registered servers that were first seen most recently, newest first, as listed.
+func (d *Directory) newestServers() ([]db.RegisteredServer, error) {
+	servers, err := d.registeredServerStore.GetAllRegisteredServers()
+	if err != nil {
+		return nil, err
+	}
+
+	if servers, _, err = d.serverOverrideStore.ApplyOverrides(servers); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(servers, func(a db.RegisteredServer, b db.RegisteredServer) int {
+		return b.FirstSeen.Compare(a.FirstSeen)
+	})
+
+	if len(servers) > d.cfg.FeedItems {
+		servers = servers[:d.cfg.FeedItems]
+	}
+
+	return servers, nil
+}
+
+var templateFuncs = template.FuncMap{
+	// uptime describes how long ago a time was in its largest whole unit, e.g. "3 days".
+	"uptime": func(since time.Time) string {
+		if since.IsZero() {
+			return ""
+		}
+		return humanDuration(time.Since(since))
+	},
+	// hotlineURL links to a server for Hotline clients that handle the hotline scheme.
+	"hotlineURL": func(server Server) template.URL {
+		return template.URL("hotline://" + server.Address)
+	},
+}
+
+func humanDuration(duration time.Duration) string {
+	for _, unit := range []struct {
+		length time.Duration
+		name   string
+	}{
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+	} {
+		if count := int(duration / unit.length); count >= 1 {
+			if count == 1 {
+				return "1 " + unit.name
+			}
+			return strconv.Itoa(count) + " " + unit.name + "s"
+		}
+	}
+
+	return "less than a minute"
+}
@@ -0,0 +1,308 @@
+package directory
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"magnetron/internal/db"
+	"magnetron/internal/logging"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// feedItem is a newly registered server, as every feed format describes it.
+type feedItem struct {
+	ID        string // Stable for as long as the server stays registered, new when it registers again after expiring
+	Title     string
+	Content   string
+	Published time.Time
+}
+
+type feed struct {
+	Title       string
+	Description string
+	PageURL     string
+	Updated     time.Time
+	Items       []feedItem
+}
+
+// newFeed describes the most recently registered servers. Items are identified by tag URIs of the page's host,
+// the server's pass ID and when it was first seen.
+func (d *Directory) newFeed(request *http.Request) (feed, error) {
+	pageURL := d.baseURL(request)
+
+	servers, err := d.newestServers()
+	if err != nil {
+		return feed{}, err
+	}
+
+	authority := request.Host
+	if parsedURL, err := url.Parse(pageURL); err == nil {
+		authority = parsedURL.Hostname()
+	}
+
+	result := feed{
+		Title:       d.cfg.Title,
+		Description: d.cfg.Description,
+		PageURL:     pageURL,
+		Updated:     time.Now().UTC(),
+		Items:       make([]feedItem, 0, len(servers)),
+	}
+
+	if result.Description == "" {
+		result.Description = "Servers newly registered with " + d.cfg.Title
+	}
+
+	if len(servers) > 0 {
+		result.Updated = servers[0].FirstSeen.UTC()
+	}
+
+	for _, server := range servers {
+		result.Items = append(result.Items, newFeedItem(server, authority))
+	}
+
+	return result, nil
+}
+
+func newFeedItem(server db.RegisteredServer, authority string) feedItem {
+	address := net.JoinHostPort(server.Host, strconv.Itoa(int(server.Port)))
+
+	content := fmt.Sprintf("%s\n\nAddress: %s\nUsers: %d", server.Description, address, server.UserCount)
+	if server.Description == "" {
+		content = fmt.Sprintf("Address: %s\nUsers: %d", address, server.UserCount)
+	}
+
+	firstSeen := server.FirstSeen.UTC()
+
+	return feedItem{
+		ID:        fmt.Sprintf("tag:%s,%s:server/%d/%d", authority, firstSeen.Format(time.DateOnly), server.PassID, firstSeen.Unix()),
+		Title:     server.Name,
+		Content:   content,
+		Published: firstSeen,
+	}
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomDocument struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Link      atomLink    `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// jsonFeedDocument follows JSON Feed 1.1, https://www.jsonfeed.org/version/1.1/.
+type jsonFeedDocument struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	ContentText   string `json:"content_text"`
+	DatePublished string `json:"date_published"`
+}
+
+// ServeRSS serves the newly registered servers as an RSS 2.0 feed.
+func (d *Directory) ServeRSS(w http.ResponseWriter, request *http.Request) {
+
+	newest, ok := d.feedOrError(w, request)
+	if !ok {
+		return
+	}
+
+	document := rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         newest.Title,
+			Link:          newest.PageURL,
+			Description:   newest.Description,
+			LastBuildDate: newest.Updated.Format(time.RFC1123Z),
+			SelfLink:      atomLink{Href: newest.PageURL + "feed.rss", Rel: "self", Type: "application/rss+xml"},
+			Items:         make([]rssItem, 0, len(newest.Items)),
+		},
+	}
+
+	for _, item := range newest.Items {
+		document.Channel.Items = append(document.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        newest.PageURL,
+			Description: item.Content,
+			GUID:        rssGUID{IsPermaLink: "false", Value: item.ID},
+			PubDate:     item.Published.Format(time.RFC1123Z),
+		})
+	}
+
+	writeXml(w, "application/rss+xml; charset=utf-8", d.feedCacheControl(), document)
+}
+
+// ServeAtom serves the newly registered servers as an Atom feed.
+func (d *Directory) ServeAtom(w http.ResponseWriter, request *http.Request) {
+
+	newest, ok := d.feedOrError(w, request)
+	if !ok {
+		return
+	}
+
+	document := atomDocument{
+		ID:      newest.PageURL,
+		Title:   newest.Title,
+		Updated: newest.Updated.Format(time.RFC3339),
+		Author:  atomAuthor{Name: newest.Title},
+		Links: []atomLink{
+			{Href: newest.PageURL + "feed.atom", Rel: "self", Type: "application/atom+xml"},
+			{Href: newest.PageURL, Rel: "alternate", Type: "text/html"},
+		},
+		Entries: make([]atomEntry, 0, len(newest.Items)),
+	}
+
+	for _, item := range newest.Items {
+		document.Entries = append(document.Entries, atomEntry{
+			ID:        item.ID,
+			Title:     item.Title,
+			Updated:   item.Published.Format(time.RFC3339),
+			Published: item.Published.Format(time.RFC3339),
+			Link:      atomLink{Href: newest.PageURL, Rel: "alternate", Type: "text/html"},
+			Content:   atomContent{Type: "text", Value: item.Content},
+		})
+	}
+
+	writeXml(w, "application/atom+xml; charset=utf-8", d.feedCacheControl(), document)
+}
+
+// ServeJSONFeed serves the newly registered servers as a JSON Feed.
+func (d *Directory) ServeJSONFeed(w http.ResponseWriter, request *http.Request) {
+
+	newest, ok := d.feedOrError(w, request)
+	if !ok {
+		return
+	}
+
+	document := jsonFeedDocument{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       newest.Title,
+		HomePageURL: newest.PageURL,
+		FeedURL:     newest.PageURL + "feed.json",
+		Description: newest.Description,
+		Items:       make([]jsonFeedItem, 0, len(newest.Items)),
+	}
+
+	for _, item := range newest.Items {
+		document.Items = append(document.Items, jsonFeedItem{
+			ID:            item.ID,
+			URL:           newest.PageURL,
+			Title:         item.Title,
+			ContentText:   item.Content,
+			DatePublished: item.Published.Format(time.RFC3339),
+		})
+	}
+
+	jsonResponse, err := json.Marshal(document)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	w.Header().Set("Cache-Control", d.feedCacheControl())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(jsonResponse)
+}
+
+// feedCacheControl lets shared caches keep feeds only when their links come from BaseURL. Links taken from the
+// request's Host header would otherwise be served from a cache to everyone, whatever host the first request named.
+func (d *Directory) feedCacheControl() string {
+	if d.cfg.BaseURL == "" {
+		return "private, max-age=60"
+	}
+	return "public, max-age=60"
+}
+
+func (d *Directory) feedOrError(w http.ResponseWriter, request *http.Request) (feed, bool) {
+	newest, err := d.newFeed(request)
+	if err != nil {
+		logger.Error("Could not get newly registered servers for the directory feed", logging.Err(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return feed{}, false
+	}
+
+	return newest, true
+}
+
+func writeXml(w http.ResponseWriter, contentType string, cacheControl string, document any) {
+	xmlResponse, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(xmlResponse)
+}
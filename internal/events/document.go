@@ -0,0 +1,64 @@
+package events
+
+import "time"
+
+// Document is the JSON representation of an event shared by the event stream and webhooks.
+type Document struct {
+	ID      uint64           `json:"id"`
+	Type    EventType        `json:"type"`
+	Time    time.Time        `json:"time"`
+	Origin  string           `json:"origin,omitempty"` // Peer tracker the change originated on, omitted for local changes
+	Via     []string         `json:"via,omitempty"`
+	Server  *ServerDocument  `json:"server,omitempty"`
+	Tracker *TrackerDocument `json:"tracker,omitempty"`
+	Reason  string           `json:"reason,omitempty"` // Why a registration was rejected or a tracker is down
+}
+
+type ServerDocument struct {
+	PassID      uint32 `json:"passId"`
+	Name        string `json:"name"`
+	Host        string `json:"host"`
+	Port        uint16 `json:"port"`
+	Description string `json:"description"`
+	UserCount   uint16 `json:"userCount"`
+}
+
+type TrackerDocument struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+}
+
+func (e Event) IsTrackerEvent() bool {
+	return e.Type == TrackerUp || e.Type == TrackerDown
+}
+
+func (e Event) Document() Document {
+	document := Document{
+		ID:     e.ID,
+		Type:   e.Type,
+		Time:   e.Time,
+		Origin: e.Origin,
+		Via:    e.Via,
+		Reason: e.Reason,
+	}
+
+	if e.IsTrackerEvent() {
+		document.Tracker = &TrackerDocument{
+			Name: e.Tracker.Name,
+			Host: e.Tracker.Host,
+			Port: e.Tracker.Port,
+		}
+	} else {
+		document.Server = &ServerDocument{
+			PassID:      e.Server.PassID,
+			Name:        e.Server.Name,
+			Host:        e.Server.Host,
+			Port:        e.Server.Port,
+			Description: e.Server.Description,
+			UserCount:   e.Server.UserCount,
+		}
+	}
+
+	return document
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"log/slog"
+	"magnetron/internal/db"
+	"magnetron/internal/metrics"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	ServerRegistered EventType = "server.registered" // A server registered with the tracker for the first time
+	ServerUpdated    EventType = "server.updated"    // A known server registered again with a different listing
+	ServerRefreshed  EventType = "server.refreshed"  // A known server registered again without changes
+	ServerExpired    EventType = "server.expired"    // A server was removed from the registry
+	ServerRejected   EventType = "server.rejected"   // A registration was refused, see Reason
+	TrackerUp        EventType = "tracker.up"        // A federated tracker answered after failing or for the first time
+	TrackerDown      EventType = "tracker.down"      // A federated tracker could not be polled, see Reason
+)
+
+var AllTypes = []EventType{ServerRegistered, ServerUpdated, ServerRefreshed, ServerExpired, ServerRejected, TrackerUp, TrackerDown}
+
+// historySize is the number of past events kept so that subscribers can resume after reconnecting. Refreshes are
+// not kept: every registered server publishes one each time it re-registers, and they would push out the changes.
+const historySize = 1024
+
+type Event struct {
+	ID      uint64              // Sequence number assigned on publication, unique for the lifetime of the process
+	Type    EventType           // Kind of change
+	Time    time.Time           // When the change happened
+	Origin  string              // Node name of the tracker the change originated on, empty for local changes
+	Via     []string            // Node names of the trackers the change has been relayed through
+	Server  db.RegisteredServer // Snapshot of the affected server, for server events
+	Tracker db.FederatedTracker // Affected federated tracker, for tracker events
+	Reason  string              // Why a registration was rejected or a tracker is down
+}
+
+type Subscription struct {
+	C     <-chan Event
+	Start uint64 // ID of the last event published before the subscription, so that a gap in the first IDs shows too
+	id    uint64
+	bus   *Bus
+}
+
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	subscribers map[uint64]chan Event
+	lastEventID uint64
+	history     [historySize]Event // Ring of the most recent events, except refreshes
+	oldest      int                // Index of the oldest event in history
+	retained    int                // Number of events in history
+	forgotten   uint64             // ID of the newest event dropped from history, 0 when none was
+}
+
+var (
+	defaultBus = NewBus()
+
+	// MissedEvents counts the events subscribers noticed were dropped for them, by subscriber.
+	MissedEvents = metrics.NewCounter("magnetron_events_missed_total", "Events dropped for a subscriber that fell behind, by subscriber.", "subscriber")
+)
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[uint64]chan Event),
+	}
+}
+
+// Subscribe returns a subscription that receives every event published after the call. Events are dropped
+// for subscribers whose buffer is full, so slow consumers never block the publisher; they see a gap in the IDs,
+// which otherwise follow each other from Start.
+func (b *Bus) Subscribe(bufferSize int) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	ch := make(chan Event, bufferSize)
+	b.subscribers[b.nextID] = ch
+
+	return &Subscription{C: ch, Start: b.lastEventID, id: b.nextID, bus: b}
+}
+
+// SubscribeSince works like Subscribe but also returns the retained events published after the event with the
+// given ID. Refreshes are not retained, and are left out without making the events incomplete. complete is false
+// when some of the other events are no longer retained, or the ID is unknown.
+func (b *Bus) SubscribeSince(lastEventID uint64, bufferSize int) (subscription *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	ch := make(chan Event, bufferSize)
+	b.subscribers[b.nextID] = ch
+
+	complete = lastEventID <= b.lastEventID && lastEventID >= b.forgotten
+
+	for i := 0; i < b.retained; i++ {
+		if event := b.history[(b.oldest+i)%historySize]; event.ID > lastEventID {
+			missed = append(missed, event)
+		}
+	}
+
+	return &Subscription{C: ch, Start: b.lastEventID, id: b.nextID, bus: b}, missed, complete
+}
+
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastEventID++
+	event.ID = b.lastEventID
+
+	if event.Type != ServerRefreshed {
+		if b.retained == historySize {
+			b.forgotten = b.history[b.oldest].ID
+			b.oldest = (b.oldest + 1) % historySize
+			b.retained--
+		}
+		b.history[(b.oldest+b.retained)%historySize] = event
+		b.retained++
+	}
+
+	for id, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("Dropped event for slow subscriber", "event_type", event.Type, "subscriber", id)
+		}
+	}
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if ch, ok := s.bus.subscribers[s.id]; ok {
+		delete(s.bus.subscribers, s.id)
+		close(ch)
+	}
+}
+
+func Subscribe(bufferSize int) *Subscription {
+	return defaultBus.Subscribe(bufferSize)
+}
+
+func SubscribeSince(lastEventID uint64, bufferSize int) (*Subscription, []Event, bool) {
+	return defaultBus.SubscribeSince(lastEventID, bufferSize)
+}
+
+func Publish(event Event) {
+	defaultBus.Publish(event)
+}
@@ -0,0 +1,267 @@
+package history
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"magnetron/internal/config"
+	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"magnetron/internal/logging"
+	"time"
+)
+
+const (
+	defaultSampleInterval = 5 * time.Minute
+	defaultRawRetention   = 48 * time.Hour
+	defaultRetention      = 90 * 24 * time.Hour
+	downsampledResolution = 1 * time.Hour
+	maintenanceInterval   = 1 * time.Hour
+)
+
+type HistoryService struct {
+	db                    *gorm.DB
+	cfg                   *config.Config
+	historyStore          *db.HistoryStore
+	registeredServerStore *db.RegisteredServerStore
+	subscription          *events.Subscription
+}
+
+var (
+	HistoryServiceInstance *HistoryService
+
+	logger = logging.For(logging.DB)
+)
+
+func NewHistoryService(cfg *config.Config) error {
+	var registryDatabase, historyDatabase *gorm.DB
+	var err error
+
+	if registryDatabase, err = db.GetDB(); err != nil {
+		return fmt.Errorf("error while getting internal DB connection: %s", err)
+	}
+
+	// Without a file, or while history is disabled, history only lives in memory.
+	if cfg.History.Enabled && cfg.History.File != "" {
+		historyDatabase, err = db.GetFileDB(cfg.History.File)
+	} else {
+		historyDatabase = registryDatabase
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while opening history file: %s", err)
+	}
+
+	historyStore, err := db.NewHistoryStore(historyDatabase)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing history store: %s", err)
+	}
+
+	registeredServerStore, err := db.NewRegisteredServerStore(registryDatabase)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing registered server store: %s", err)
+	}
+
+	service := &HistoryService{
+		db:                    historyDatabase,
+		cfg:                   cfg,
+		historyStore:          historyStore,
+		registeredServerStore: registeredServerStore,
+	}
+
+	// Subscribe right away so that servers registering while the other services start are recorded.
+	if cfg.History.Enabled {
+		service.subscription = events.Subscribe(1024)
+	}
+
+	HistoryServiceInstance = service
+	return nil
+}
+
+func durationOrDefault(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func (h *HistoryService) Enabled() bool {
+	return h.cfg.History.Enabled
+}
+
+func (h *HistoryService) SampleInterval() time.Duration {
+	return durationOrDefault(h.cfg.History.SampleInterval, defaultSampleInterval)
+}
+
+func (h *HistoryService) Retention() time.Duration {
+	return durationOrDefault(h.cfg.History.Retention, defaultRetention)
+}
+
+func (h *HistoryService) Serve() {
+
+	if !h.cfg.History.Enabled {
+		return
+	}
+
+	h.closeOpenPeriods()
+
+	go h.recordSamples()
+
+	// Servers recorded as online. Open periods were just closed, so none are yet.
+	online := make(map[uint32]bool)
+
+	lastEventID := h.subscription.Start
+
+	for event := range h.subscription.C {
+		switch event.Type {
+		case events.ServerRegistered:
+			h.recordTransition(online, event.Server.PassID, event.Time, true)
+		case events.ServerExpired:
+			h.recordTransition(online, event.Server.PassID, event.Time, false)
+		}
+
+		// Every subscriber sees every event, so a gap in the IDs means the bus dropped some while history fell behind.
+		// The registered servers tell which of the missed transitions happened.
+		if event.ID > lastEventID+1 {
+			missed := event.ID - lastEventID - 1
+			logger.Warn("History missed events, reconciling with the registered servers", "missed", missed)
+			events.MissedEvents.Add(float64(missed), "history")
+			h.reconcile(online, event.Time)
+		}
+
+		lastEventID = event.ID
+	}
+}
+
+// recordTransition records a server going online or offline, unless it already is.
+func (h *HistoryService) recordTransition(online map[uint32]bool, passID uint32, at time.Time, isOnline bool) {
+	if online[passID] == isOnline {
+		return
+	}
+
+	if err := h.historyStore.AddTransition(passID, at, isOnline); err != nil {
+		logger.Error("Could not record transition", logging.PassID(passID), logging.Err(err))
+		return
+	}
+
+	if isOnline {
+		online[passID] = true
+	} else {
+		delete(online, passID)
+	}
+}
+
+// reconcile records the transitions that bring the servers recorded as online in line with the registered servers.
+func (h *HistoryService) reconcile(online map[uint32]bool, at time.Time) {
+	servers, err := h.registeredServerStore.GetAllRegisteredServers()
+	if err != nil {
+		logger.Error("Could not get registered servers", logging.Err(err))
+		return
+	}
+
+	registered := make(map[uint32]bool, len(servers))
+
+	for _, server := range servers {
+		registered[server.PassID] = true
+		h.recordTransition(online, server.PassID, at, true)
+	}
+
+	for passID := range online {
+		if !registered[passID] {
+			h.recordTransition(online, passID, at, false)
+		}
+	}
+}
+
+// closeOpenPeriods marks servers that were online when the tracker last stopped as having gone offline when they
+// were last sampled, since nothing is known about them after that.
+func (h *HistoryService) closeOpenPeriods() {
+	online, err := h.historyStore.GetOnlineServers()
+	if err != nil {
+		logger.Error("Could not get servers that were online", logging.Err(err))
+		return
+	}
+
+	for passID, lastSeen := range online {
+		if err := h.historyStore.AddTransition(passID, lastSeen, false); err != nil {
+			logger.Error("Could not record transition", logging.PassID(passID), logging.Err(err))
+		}
+	}
+}
+
+func (h *HistoryService) recordSamples() {
+	ticker := time.NewTicker(h.SampleInterval())
+	defer ticker.Stop()
+
+	lastMaintenance := time.Time{}
+
+	for {
+		if time.Since(lastMaintenance) > maintenanceInterval {
+			h.maintain()
+			lastMaintenance = time.Now()
+		}
+
+		h.sample()
+
+		<-ticker.C
+	}
+}
+
+func (h *HistoryService) sample() {
+	servers, err := h.registeredServerStore.GetAllRegisteredServers()
+	if err != nil {
+		logger.Error("Could not get registered servers", logging.Err(err))
+		return
+	}
+
+	interval := h.SampleInterval()
+	bucket := time.Now().Truncate(interval)
+
+	trackerSample := db.TrackerSample{
+		Bucket:     bucket,
+		Resolution: interval,
+		MaxServers: uint32(len(servers)),
+		SumServers: uint64(len(servers)),
+		Count:      1,
+	}
+
+	serverSamples := make([]db.UserCountSample, 0, len(servers))
+
+	for _, server := range servers {
+		serverSamples = append(serverSamples, db.UserCountSample{
+			PassID:     server.PassID,
+			Bucket:     bucket,
+			Resolution: interval,
+			Name:       server.Name,
+			MinUsers:   server.UserCount,
+			MaxUsers:   server.UserCount,
+			SumUsers:   uint64(server.UserCount),
+			Count:      1,
+		})
+
+		trackerSample.SumUsers += uint64(server.UserCount)
+	}
+
+	trackerSample.MaxUsers = trackerSample.SumUsers
+
+	if err := h.historyStore.AddSamples(serverSamples, trackerSample); err != nil {
+		logger.Error("Could not record samples", logging.Err(err))
+	}
+}
+
+// maintain merges aged samples into hourly ones and removes history past its retention.
+func (h *HistoryService) maintain() {
+	rawRetention := durationOrDefault(h.cfg.History.RawRetention, defaultRawRetention)
+
+	// Only whole hours are merged, so that an hourly sample never has to be merged again.
+	cutoff := time.Now().Add(-rawRetention).Truncate(downsampledResolution)
+
+	if err := h.historyStore.Downsample(cutoff, downsampledResolution); err != nil {
+		logger.Error("Could not downsample history", logging.Err(err))
+	}
+
+	if err := h.historyStore.RemoveHistoryBefore(time.Now().Add(-h.Retention())); err != nil {
+		logger.Error("Could not remove expired history", logging.Err(err))
+	}
+}
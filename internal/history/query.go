@@ -0,0 +1,313 @@
+package history
+
+import (
+	"cmp"
+	"magnetron/internal/db"
+	"slices"
+	"time"
+)
+
+// Point is the user count of a server, or of the whole tracker, over one period of time.
+type Point struct {
+	Time       time.Time
+	MinUsers   uint16
+	MaxUsers   uint16
+	AvgUsers   float64
+	Resolution time.Duration
+}
+
+type Transition struct {
+	Time   time.Time
+	Online bool
+}
+
+type ServerHistory struct {
+	PassID      uint32
+	Name        string
+	Points      []Point
+	Transitions []Transition
+	Uptime      Uptime
+}
+
+type Uptime struct {
+	Online   time.Duration // Time spent online within the observed period
+	Observed time.Duration // Part of the requested period the server was known for
+}
+
+func (u Uptime) Percent() float64 {
+	if u.Observed <= 0 {
+		return 0
+	}
+	return float64(u.Online) / float64(u.Observed) * 100
+}
+
+type ServerUptime struct {
+	PassID   uint32
+	Name     string
+	Uptime   Uptime
+	AvgUsers float64
+	MaxUsers uint16
+}
+
+type TrackerPeriod struct {
+	Start       time.Time
+	MaxUsers    uint64
+	AvgUsers    float64
+	MaxServers  uint32
+	AvgServers  float64
+	PeakUsersAt time.Time
+}
+
+type TrackerSummary struct {
+	Peak   TrackerPeriod // The whole requested period
+	Daily  []TrackerPeriod
+	Weekly []TrackerPeriod // Weeks start on Monday, UTC
+}
+
+// ServerHistory returns a server's user counts and transitions since the given time. A non-zero resolution merges
+// the stored samples into points of that length.
+func (h *HistoryService) ServerHistory(passID uint32, since time.Time, resolution time.Duration) (ServerHistory, error) {
+	now := time.Now()
+	result := ServerHistory{PassID: passID}
+
+	samples, err := h.historyStore.GetServerSamples(passID, since)
+	if err != nil {
+		return result, err
+	}
+
+	result.Points = mergeServerSamples(samples, resolution)
+	if len(samples) > 0 {
+		result.Name = samples[len(samples)-1].Name
+	}
+
+	transitions, err := h.historyStore.GetTransitions(since)
+	if err != nil {
+		return result, err
+	}
+
+	serverTransitions := transitionsByServer(transitions)[passID]
+
+	for _, transition := range serverTransitions {
+		if !transition.Time.Before(since) {
+			result.Transitions = append(result.Transitions, Transition{Time: transition.Time, Online: transition.Online})
+		}
+	}
+
+	result.Uptime = computeUptime(serverTransitions, since, now)
+
+	return result, nil
+}
+
+func mergeServerSamples(samples []db.UserCountSample, resolution time.Duration) []Point {
+	points := make([]Point, 0, len(samples))
+
+	var sum uint64
+	var count uint32
+
+	for _, sample := range samples {
+		bucket, sampleResolution := sample.Bucket, sample.Resolution
+		if resolution > 0 {
+			bucket, sampleResolution = sample.Bucket.Truncate(resolution), max(resolution, sample.Resolution)
+		}
+
+		if len(points) == 0 || !points[len(points)-1].Time.Equal(bucket) {
+			points = append(points, Point{Time: bucket, MinUsers: sample.MinUsers, Resolution: sampleResolution})
+			sum, count = 0, 0
+		}
+
+		point := &points[len(points)-1]
+		point.MinUsers = min(point.MinUsers, sample.MinUsers)
+		point.MaxUsers = max(point.MaxUsers, sample.MaxUsers)
+		sum += sample.SumUsers
+		count += sample.Count
+		point.AvgUsers = average(sum, count)
+	}
+
+	return points
+}
+
+func average(sum uint64, count uint32) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(sum) / float64(count)
+}
+
+func transitionsByServer(transitions []db.ServerTransition) map[uint32][]db.ServerTransition {
+	byServer := make(map[uint32][]db.ServerTransition)
+	for _, transition := range transitions {
+		byServer[transition.PassID] = append(byServer[transition.PassID], transition)
+	}
+
+	for _, serverTransitions := range byServer {
+		slices.SortStableFunc(serverTransitions, func(a, b db.ServerTransition) int { return a.Time.Compare(b.Time) })
+	}
+
+	return byServer
+}
+
+// computeUptime adds up the time a server spent online between from and to. Transitions must be sorted and may
+// start with the last transition before from. A server first seen after from is only observed from then on.
+func computeUptime(transitions []db.ServerTransition, from time.Time, to time.Time) Uptime {
+	if len(transitions) == 0 {
+		return Uptime{}
+	}
+
+	observedFrom := from
+	online := false
+
+	if transitions[0].Time.Before(from) {
+		online = transitions[0].Online
+		transitions = transitions[1:]
+	} else {
+		observedFrom = transitions[0].Time
+	}
+
+	var uptime Uptime
+	cursor := observedFrom
+
+	for _, transition := range transitions {
+		if online {
+			uptime.Online += transition.Time.Sub(cursor)
+		}
+		cursor = transition.Time
+		online = transition.Online
+	}
+
+	if online {
+		uptime.Online += to.Sub(cursor)
+	}
+
+	uptime.Observed = to.Sub(observedFrom)
+	return uptime
+}
+
+// UptimeRanking returns the uptime and user counts of every server seen since the given time, most reliable first.
+func (h *HistoryService) UptimeRanking(since time.Time) ([]ServerUptime, error) {
+	now := time.Now()
+
+	transitions, err := h.historyStore.GetTransitions(since)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := h.historyStore.GetAllServerSamples(since)
+	if err != nil {
+		return nil, err
+	}
+
+	type userStats struct {
+		name  string
+		sum   uint64
+		count uint32
+		max   uint16
+	}
+
+	statsByServer := make(map[uint32]*userStats)
+	for _, sample := range samples {
+		stats, ok := statsByServer[sample.PassID]
+		if !ok {
+			stats = &userStats{}
+			statsByServer[sample.PassID] = stats
+		}
+
+		stats.name = sample.Name
+		stats.sum += sample.SumUsers
+		stats.count += sample.Count
+		stats.max = max(stats.max, sample.MaxUsers)
+	}
+
+	var ranking []ServerUptime
+
+	for passID, serverTransitions := range transitionsByServer(transitions) {
+		uptime := computeUptime(serverTransitions, since, now)
+		if uptime.Observed <= 0 {
+			continue
+		}
+
+		serverUptime := ServerUptime{PassID: passID, Uptime: uptime}
+
+		if stats, ok := statsByServer[passID]; ok {
+			serverUptime.Name = stats.name
+			serverUptime.AvgUsers = average(stats.sum, stats.count)
+			serverUptime.MaxUsers = stats.max
+		}
+
+		ranking = append(ranking, serverUptime)
+	}
+
+	slices.SortFunc(ranking, func(a, b ServerUptime) int {
+		if c := cmp.Compare(b.Uptime.Percent(), a.Uptime.Percent()); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.AvgUsers, a.AvgUsers); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.PassID, b.PassID)
+	})
+
+	return ranking, nil
+}
+
+// TrackerSummary returns the peak, daily and weekly number of servers and users registered since the given time.
+func (h *HistoryService) TrackerSummary(since time.Time) (TrackerSummary, error) {
+	samples, err := h.historyStore.GetTrackerSamples(since)
+	if err != nil {
+		return TrackerSummary{}, err
+	}
+
+	summary := TrackerSummary{
+		Peak:   TrackerPeriod{Start: since},
+		Daily:  aggregateTrackerSamples(samples, startOfDay),
+		Weekly: aggregateTrackerSamples(samples, startOfWeek),
+	}
+
+	if whole := aggregateTrackerSamples(samples, func(time.Time) time.Time { return since }); len(whole) > 0 {
+		summary.Peak = whole[0]
+	}
+
+	return summary, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func startOfWeek(t time.Time) time.Time {
+	day := startOfDay(t)
+	daysSinceMonday := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -daysSinceMonday)
+}
+
+// aggregateTrackerSamples groups samples into periods by their start, as given by period.
+func aggregateTrackerSamples(samples []db.TrackerSample, period func(time.Time) time.Time) []TrackerPeriod {
+	var periods []TrackerPeriod
+	var sumUsers, sumServers uint64
+	var count uint32
+
+	for _, sample := range samples {
+		start := period(sample.Bucket)
+
+		if len(periods) == 0 || !periods[len(periods)-1].Start.Equal(start) {
+			periods = append(periods, TrackerPeriod{Start: start})
+			sumUsers, sumServers, count = 0, 0, 0
+		}
+
+		current := &periods[len(periods)-1]
+
+		if sample.MaxUsers > current.MaxUsers || current.PeakUsersAt.IsZero() {
+			current.MaxUsers = sample.MaxUsers
+			current.PeakUsersAt = sample.Bucket
+		}
+		current.MaxServers = max(current.MaxServers, sample.MaxServers)
+
+		sumUsers += sample.SumUsers
+		sumServers += sample.SumServers
+		count += sample.Count
+		current.AvgUsers = average(sumUsers, count)
+		current.AvgServers = average(sumServers, count)
+	}
+
+	return periods
+}
@@ -0,0 +1,322 @@
+package listing
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"magnetron/internal/config"
+	"magnetron/internal/db"
+	"magnetron/internal/proto/client"
+	"slices"
+	"strings"
+	"time"
+)
+
+type RowKind string
+
+const (
+	StaticRow           RowKind = "static"            // A static entry from the configuration
+	RegisteredRow       RowKind = "registered"        // A server that registered with this tracker
+	HeaderRow           RowKind = "header"            // A section header generated by the tracker
+	FederatedTrackerRow RowKind = "federated-tracker" // A federated tracker, listed above its servers
+	FederatedServerRow  RowKind = "federated-server"  // A server listed by a federated tracker
+)
+
+type Row struct {
+	Kind    RowKind
+	Message client.ServerMessage
+	Since   time.Time // When a registered or federated server was first seen since it was last delisted, zero otherwise
+}
+
+// Builder assembles the listing sent to Hotline clients, in the order it goes out on the wire.
+type Builder struct {
+	cfg                   *config.Config
+	federatedTrackerStore *db.FederatedTrackerStore
+	federatedServerStore  *db.FederatedServerStore
+	staticServerStore     *db.StaticServerStore
+	registeredServerStore *db.RegisteredServerStore
+	serverOverrideStore   *db.ServerOverrideStore
+}
+
+func NewBuilder(database *gorm.DB, cfg *config.Config) (*Builder, error) {
+	federatedTrackerStore, err := db.NewFederatedTrackerStore(database)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing federated tracker store: %s", err)
+	}
+
+	federatedServerStore, err := db.NewFederatedServerStore(database)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing federated server store: %s", err)
+	}
+
+	staticServerStore, err := db.NewStaticServerStore(database)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing static server store: %s", err)
+	}
+
+	registeredServerStore, err := db.NewRegisteredServerStore(database)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing registered server store: %s", err)
+	}
+
+	serverOverrideStore, err := db.NewServerOverrideStore(database)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing server override store: %s", err)
+	}
+
+	return &Builder{
+		cfg:                   cfg,
+		federatedTrackerStore: federatedTrackerStore,
+		federatedServerStore:  federatedServerStore,
+		staticServerStore:     staticServerStore,
+		registeredServerStore: registeredServerStore,
+		serverOverrideStore:   serverOverrideStore,
+	}, nil
+}
+
+// Build returns the listing rows in wire order. A section that fails to build is left out and its error is
+// returned alongside the rows of the sections that succeeded.
+func (b *Builder) Build() ([]Row, error) {
+	var rows []Row
+	var errs []error
+
+	if staticRows, err := b.staticRows(); err != nil {
+		errs = append(errs, err)
+	} else {
+		rows = append(rows, staticRows...)
+	}
+
+	if registeredRows, err := b.registeredRows(); err != nil {
+		errs = append(errs, err)
+	} else {
+		rows = append(rows, registeredRows...)
+	}
+
+	rows = append(rows, b.federatedHeaderRow())
+
+	if federatedRows, err := b.federatedRows(); err != nil {
+		errs = append(errs, err)
+	} else {
+		rows = append(rows, federatedRows...)
+	}
+
+	return rows, errors.Join(errs...)
+}
+
+func (b *Builder) staticRows() ([]Row, error) {
+	staticServers, err := b.staticServerStore.GetStaticServers()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row, 0, len(staticServers))
+
+	for _, server := range staticServers {
+		if serverMessage, err := client.BuildStaticServerMessage(server); err != nil {
+			return nil, err
+		} else {
+			rows = append(rows, Row{Kind: StaticRow, Message: *serverMessage})
+		}
+	}
+
+	return rows, nil
+}
+
+func (b *Builder) registeredRows() ([]Row, error) {
+	registeredServers, err := b.registeredServerStore.GetAllRegisteredServers()
+
+	if err != nil {
+		return nil, err
+	}
+
+	registeredServers, overrides, err := b.serverOverrideStore.ApplyOverrides(registeredServers)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.cfg.PasswordMode() != config.PasswordsMixed {
+		return registeredServerRows(registeredServers)
+	}
+
+	// In mixed mode verified and unverified servers are listed in sections of their own.
+	var verified, unverified []db.RegisteredServer
+	for _, server := range registeredServers {
+		if server.Verified {
+			verified = append(verified, server)
+		} else {
+			unverified = append(unverified, server)
+		}
+	}
+
+	var rows []Row
+	verification := b.cfg.Verification
+
+	for _, section := range []struct {
+		header  string
+		order   string
+		servers []db.RegisteredServer
+	}{
+		{verification.VerifiedHeader, verification.VerifiedOrder, verified},
+		{verification.UnverifiedHeader, verification.UnverifiedOrder, unverified},
+	} {
+		if len(section.servers) == 0 {
+			continue
+		}
+
+		if section.header != "" {
+			rows = append(rows, headerRow(section.header))
+		}
+
+		sortSection(section.servers, section.order, overrides)
+
+		sectionRows, err := registeredServerRows(section.servers)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, sectionRows...)
+	}
+
+	return rows, nil
+}
+
+func registeredServerRows(registeredServers []db.RegisteredServer) ([]Row, error) {
+	rows := make([]Row, 0, len(registeredServers))
+
+	for _, server := range registeredServers {
+		if serverMessage, err := client.BuildRegisteredServerMessage(server); err != nil {
+			return nil, err
+		} else {
+			rows = append(rows, Row{Kind: RegisteredRow, Message: *serverMessage, Since: server.FirstSeen})
+		}
+	}
+
+	return rows, nil
+}
+
+// sortSection orders the servers of a verified or unverified section. Pinned servers stay in front, and the
+// listing order keeps the servers as they are.
+func sortSection(servers []db.RegisteredServer, order string, overrides map[uint32]db.ServerOverride) {
+	var compare func(a db.RegisteredServer, b db.RegisteredServer) int
+
+	switch order {
+	case "name":
+		compare = func(a, b db.RegisteredServer) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+	case "users":
+		compare = func(a, b db.RegisteredServer) int { return cmp.Compare(b.UserCount, a.UserCount) }
+	case "firstSeen":
+		compare = func(a, b db.RegisteredServer) int { return a.FirstSeen.Compare(b.FirstSeen) }
+	default:
+		return
+	}
+
+	slices.SortStableFunc(servers, func(a db.RegisteredServer, b db.RegisteredServer) int {
+		if aPinned, bPinned := overrides[a.PassID].Pinned, overrides[b.PassID].Pinned; aPinned != bPinned {
+			if aPinned {
+				return -1
+			}
+			return 1
+		}
+
+		return compare(a, b)
+	})
+}
+
+func (b *Builder) federatedHeaderRow() Row {
+	return headerRow(b.cfg.TrackerFederation.Header)
+}
+
+func headerRow(header string) Row {
+	headerName := []byte(header)
+
+	return Row{
+		Kind: HeaderRow,
+		Message: client.ServerMessage{
+			IPAddr:          [4]byte{0, 0, 0, 0},
+			Port:            [2]byte{0, 0},
+			NumUsers:        [2]byte{0, 0},
+			Unused:          [2]byte{0, 0},
+			NameSize:        byte(len(headerName)),
+			Name:            headerName,
+			DescriptionSize: 0,
+			Description:     nil,
+		},
+	}
+}
+
+func (b *Builder) federatedRows() ([]Row, error) {
+	federatedTrackers, err := b.federatedTrackerStore.GetFederatedTrackers()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row, 0)
+
+	for _, tracker := range federatedTrackers {
+
+		if serverMessage, err := client.BuildFederatedTrackerMessage(tracker); err != nil {
+			return nil, err
+		} else {
+			rows = append(rows, Row{Kind: FederatedTrackerRow, Message: *serverMessage})
+		}
+
+		federatedServers, err := b.federatedServerStore.GetFederatedServers(tracker.Host, tracker.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, server := range federatedServers {
+			if serverMessage, err := client.BuildFederatedServerMessage(server); err != nil {
+				return nil, err
+			} else {
+				rows = append(rows, Row{Kind: FederatedServerRow, Message: *serverMessage, Since: server.FirstSeen})
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+func Messages(rows []Row) []client.ServerMessage {
+	serverMessages := make([]client.ServerMessage, 0, len(rows))
+
+	for _, row := range rows {
+		serverMessages = append(serverMessages, row.Message)
+	}
+
+	return serverMessages
+}
+
+// Encode returns the bytes a tracker sends to a client after receiving its header: the tracker header, the
+// update message and every server entry.
+func Encode(rows []Row) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	serverMessages := Messages(rows)
+
+	headerMsg := client.BuildHeaderMessage()
+	if err := binary.Write(&buffer, binary.BigEndian, &headerMsg); err != nil {
+		return nil, err
+	}
+
+	updateMsg := client.BuildUpdateMessage(serverMessages)
+	if err := binary.Write(&buffer, binary.BigEndian, &updateMsg); err != nil {
+		return nil, err
+	}
+
+	for _, serverMessage := range serverMessages {
+		buffer.Write(serverMessage.GetMessageInBytes())
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"magnetron/internal/config"
+	"os"
+	"sync/atomic"
+)
+
+// Subsystems whose level is configured with Logging.Subsystems.
+const (
+	Registry   = "registry"   // UDP registrations and Hotline client listings
+	Federation = "federation" // Federated tracker polling, discovery, relaying and peering
+	API        = "api"        // REST API, webhooks and metrics
+	DB         = "db"         // Stores and history
+)
+
+// Keys of the attributes shared by the subsystems, so that records can be correlated across them.
+const (
+	KeySubsystem  = "subsystem"
+	KeyRemoteAddr = "remote_addr"
+	KeyPassID     = "pass_id"
+	KeyServerName = "server_name"
+	KeyTracker    = "tracker"
+	KeyError      = "error"
+)
+
+var (
+	output atomic.Pointer[slog.Handler]
+	levels = make(map[string]*slog.LevelVar)
+	file   *os.File
+)
+
+func init() {
+	for _, subsystem := range config.LogSubsystems {
+		levels[subsystem] = new(slog.LevelVar)
+	}
+
+	setOutput(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// For returns the logger of a subsystem. Loggers may be created before Setup; they follow the configuration it
+// applies.
+func For(subsystem string) *slog.Logger {
+	level, ok := levels[subsystem]
+	if !ok {
+		panic(fmt.Sprintf("unknown log subsystem %s", subsystem))
+	}
+
+	return slog.New(&handler{level: level}).With(KeySubsystem, subsystem)
+}
+
+// Setup applies the logging configuration to every subsystem logger and to the default logger, which the
+// standard log package writes through.
+func Setup(cfg config.LoggingConfig) error {
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return fmt.Errorf("invalid logging configuration: %w", errors.Join(errs...))
+	}
+
+	defaultLevel, err := config.ParseLogLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = os.Stderr
+	var logFile *os.File
+
+	if cfg.File != "" {
+		if logFile, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return fmt.Errorf("could not open log file: %s", err)
+		}
+		writer = logFile
+	}
+
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var outputHandler slog.Handler
+	if cfg.Format == "json" {
+		outputHandler = slog.NewJSONHandler(writer, options)
+	} else {
+		outputHandler = slog.NewTextHandler(writer, options)
+	}
+
+	for subsystem, level := range levels {
+		subsystemLevel := defaultLevel
+		if configured, ok := cfg.Subsystems[subsystem]; ok {
+			if subsystemLevel, err = config.ParseLogLevel(configured); err != nil {
+				return err
+			}
+		}
+		level.Set(subsystemLevel)
+	}
+
+	setOutput(outputHandler)
+
+	defaultLevelVar := new(slog.LevelVar)
+	defaultLevelVar.Set(defaultLevel)
+	slog.SetDefault(slog.New(&handler{level: defaultLevelVar}))
+
+	if file != nil {
+		file.Close()
+	}
+	file = logFile
+
+	return nil
+}
+
+func setOutput(outputHandler slog.Handler) {
+	output.Store(&outputHandler)
+}
+
+// handler filters records by the level of its subsystem and hands them to the current output, which Setup may
+// replace after loggers have been created.
+type handler struct {
+	level *slog.LevelVar
+	with  []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	outputHandler := *output.Load()
+	for _, with := range h.with {
+		outputHandler = with(outputHandler)
+	}
+
+	return outputHandler.Handle(ctx, record)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(outputHandler slog.Handler) slog.Handler { return outputHandler.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.extend(func(outputHandler slog.Handler) slog.Handler { return outputHandler.WithGroup(name) })
+}
+
+func (h *handler) extend(with func(slog.Handler) slog.Handler) slog.Handler {
+	extended := &handler{level: h.level, with: make([]func(slog.Handler) slog.Handler, 0, len(h.with)+1)}
+	extended.with = append(extended.with, h.with...)
+	extended.with = append(extended.with, with)
+	return extended
+}
+
+func RemoteAddr(addr string) slog.Attr {
+	return slog.String(KeyRemoteAddr, addr)
+}
+
+func PassID(passID uint32) slog.Attr {
+	return slog.Uint64(KeyPassID, uint64(passID))
+}
+
+func ServerName(name string) slog.Attr {
+	return slog.String(KeyServerName, name)
+}
+
+// Tracker identifies a federated tracker, upstream or peer by its host:port or node name.
+func Tracker(tracker string) slog.Attr {
+	return slog.String(KeyTracker, tracker)
+}
+
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
@@ -0,0 +1,289 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"magnetron/internal/logging"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit durations in seconds of network operations, from a millisecond to half a minute.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// Sample is one value of a gauge computed at scrape time.
+type Sample struct {
+	Labels []string // Label values, in the order of the metric's label names
+	Value  float64
+}
+
+type metric struct {
+	name       string
+	help       string
+	kind       metricType
+	labelNames []string
+	buckets    []float64
+
+	mu      sync.Mutex
+	series  map[string]*series
+	collect func() []Sample
+}
+
+type series struct {
+	labels  []string
+	value   float64  // Counter or gauge value, or the sum of observations of a histogram
+	count   uint64   // Number of observations of a histogram
+	buckets []uint64 // Cumulative observation counts of a histogram, by upper bound
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+var (
+	DefaultRegistry = &Registry{}
+
+	logger = logging.For(logging.API)
+)
+
+func (r *Registry) register(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.metrics {
+		if existing.name == m.name {
+			panic("metric registered twice: " + m.name)
+		}
+	}
+
+	m.series = make(map[string]*series)
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+type Counter struct{ m *metric }
+
+type Gauge struct{ m *metric }
+
+type Histogram struct{ m *metric }
+
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{DefaultRegistry.register(&metric{name: name, help: help, kind: counterType, labelNames: labelNames})}
+}
+
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{DefaultRegistry.register(&metric{name: name, help: help, kind: gaugeType, labelNames: labelNames})}
+}
+
+// NewGaugeFunc registers a gauge whose samples are computed by collect each time the metrics are written.
+func NewGaugeFunc(name string, help string, labelNames []string, collect func() []Sample) {
+	DefaultRegistry.register(&metric{name: name, help: help, kind: gaugeType, labelNames: labelNames, collect: collect})
+}
+
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{DefaultRegistry.register(&metric{name: name, help: help, kind: histogramType, labelNames: labelNames, buckets: buckets})}
+}
+
+// with returns the series for the given label values, creating it on first use. Callers hold m.mu.
+func (m *metric) with(labels []string) *series {
+	if len(labels) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", m.name, len(m.labelNames), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: slices.Clone(labels)}
+		if m.kind == histogramType {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (c *Counter) Add(delta float64, labels ...string) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.with(labels).value += delta
+}
+
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.with(labels).value = value
+}
+
+func (g *Gauge) Add(delta float64, labels ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.with(labels).value += delta
+}
+
+func (g *Gauge) Inc(labels ...string) {
+	g.Add(1, labels...)
+}
+
+func (g *Gauge) Dec(labels ...string) {
+	g.Add(-1, labels...)
+}
+
+func (h *Histogram) Observe(value float64, labels ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+
+	s := h.m.with(labels)
+	s.value += value
+	s.count++
+
+	for i, upperBound := range h.m.buckets {
+		if value <= upperBound {
+			s.buckets[i]++
+		}
+	}
+}
+
+// WriteText writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		if err := m.writeText(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *metric) writeText(w io.Writer) error {
+	var lines []string
+
+	if m.collect != nil {
+		for _, sample := range m.collect() {
+			lines = append(lines, m.name+formatLabels(m.labelNames, sample.Labels)+" "+formatValue(sample.Value))
+		}
+	} else {
+		m.mu.Lock()
+
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := m.series[key]
+
+			if m.kind != histogramType {
+				lines = append(lines, m.name+formatLabels(m.labelNames, s.labels)+" "+formatValue(s.value))
+				continue
+			}
+
+			bucketLabelNames := append(slices.Clone(m.labelNames), "le")
+			for i, upperBound := range m.buckets {
+				bucketLabels := append(slices.Clone(s.labels), formatValue(upperBound))
+				lines = append(lines, m.name+"_bucket"+formatLabels(bucketLabelNames, bucketLabels)+" "+strconv.FormatUint(s.buckets[i], 10))
+			}
+			lines = append(lines,
+				m.name+"_bucket"+formatLabels(bucketLabelNames, append(slices.Clone(s.labels), "+Inf"))+" "+strconv.FormatUint(s.count, 10),
+				m.name+"_sum"+formatLabels(m.labelNames, s.labels)+" "+formatValue(s.value),
+				m.name+"_count"+formatLabels(m.labelNames, s.labels)+" "+strconv.FormatUint(s.count, 10))
+		}
+
+		m.mu.Unlock()
+	}
+
+	// Metrics without labels are always reported so that they exist from the first scrape.
+	if len(lines) == 0 && len(m.labelNames) == 0 && m.kind != histogramType {
+		lines = append(lines, m.name+" 0")
+	}
+
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// Handler serves the metrics of the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := DefaultRegistry.WriteText(w); err != nil {
+			logger.Warn("Could not write metrics", logging.RemoteAddr(request.RemoteAddr), logging.Err(err))
+		}
+	})
+}
+
+// Serve exposes /metrics on a listener of its own, for scrapers that should not reach the REST API.
+func Serve(host string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+
+	logger.Info("Serving metrics", "host", host)
+
+	if err := http.ListenAndServe(host, mux); err != nil {
+		logger.Error("Could not start metrics listener", logging.Err(err))
+	}
+}
@@ -0,0 +1,408 @@
+package peer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"magnetron/internal/config"
+	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"magnetron/internal/logging"
+	"net"
+	"slices"
+	"time"
+)
+
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 1 * time.Minute
+	handshakeTimeout  = 10 * time.Second
+)
+
+type PeerService struct {
+	db                    *gorm.DB
+	cfg                   *config.Config
+	registeredServerStore *db.RegisteredServerStore
+	banStore              *db.BanStore
+	nonces                *nonceCache
+}
+
+var (
+	PeerServiceInstance *PeerService
+
+	logger = logging.For(logging.Federation)
+)
+
+func NewPeerService(cfg *config.Config) error {
+	var database *gorm.DB
+	var err error
+
+	if database, err = db.GetDB(); err != nil {
+		return fmt.Errorf("error while getting internal DB connection: %s", err)
+	}
+
+	registeredServerStore, err := db.NewRegisteredServerStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing registered server store: %s", err)
+	}
+
+	banStore, err := db.NewBanStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing ban store: %s", err)
+	}
+
+	PeerServiceInstance = &PeerService{
+		db:                    database,
+		cfg:                   cfg,
+		registeredServerStore: registeredServerStore,
+		banStore:              banStore,
+		nonces:                newNonceCache(),
+	}
+
+	return nil
+}
+
+func (p *PeerService) Serve() {
+
+	if !p.cfg.Peering.Enabled {
+		return
+	}
+
+	for _, peer := range p.cfg.Peering.Peers {
+		go p.streamToPeer(peer)
+	}
+
+	p.acceptPeers()
+}
+
+func (p *PeerService) acceptPeers() {
+	listener, err := net.Listen("tcp", p.cfg.Peering.Host)
+	if err != nil {
+		logger.Error("Could not start peering listener", logging.Err(err))
+		return
+	}
+	defer listener.Close()
+
+	logger.Info("Tracker is accepting peer connections", "host", p.cfg.Peering.Host)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Error("Failed to accept peer connection", logging.Err(err))
+			continue
+		}
+
+		go p.receiveFromPeer(conn)
+	}
+}
+
+func (p *PeerService) receiveFromPeer(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+
+	var hello HelloMessage
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if err := decoder.Decode(&hello); err != nil {
+		logger.Warn("Invalid hello from peer", logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	secret := p.cfg.Peering.GetSecret(hello.Node)
+
+	if err := hello.Verify(secret, p.nonces); err != nil {
+		logger.Warn("Rejected peer connection", logging.Tracker(hello.Node), logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(err))
+		encoder.Encode(WelcomeMessage{Node: p.cfg.Peering.NodeName, Error: "authentication failed"})
+		return
+	}
+
+	welcome, err := NewWelcomeMessage(p.cfg.Peering.NodeName, secret, hello)
+	if err != nil {
+		logger.Error("Could not welcome peer", logging.Tracker(hello.Node), logging.Err(err))
+		return
+	}
+
+	if err := encoder.Encode(welcome); err != nil {
+		logger.Warn("Could not welcome peer", logging.Tracker(hello.Node), logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(err))
+		return
+	}
+
+	logger.Info("Receiving changes from peer", logging.Tracker(hello.Node), logging.RemoteAddr(conn.RemoteAddr().String()))
+
+	opener := changeOpener{key: sessionKey(secret, hello, welcome)}
+
+	// Servers of the peer's own origin are collected until its snapshot is complete.
+	snapshot := make(map[uint32]bool)
+
+	for {
+		var envelope ChangeEnvelope
+		if err := decoder.Decode(&envelope); err != nil {
+			logger.Info("Peer disconnected", logging.Tracker(hello.Node), logging.Err(err))
+			return
+		}
+
+		change, err := opener.open(envelope)
+		if err != nil {
+			logger.Warn("Dropping peer connection", logging.Tracker(hello.Node), logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(err))
+			return
+		}
+
+		if snapshot != nil {
+			if change.Type == snapshotComplete {
+				p.expireMissing(hello.Node, snapshot)
+				snapshot = nil
+				continue
+			}
+
+			if change.Origin == hello.Node {
+				snapshot[change.Server.PassID] = true
+			}
+		}
+
+		p.applyChange(hello.Node, change)
+	}
+}
+
+// expireMissing removes the servers replicated from a peer that its snapshot no longer lists.
+func (p *PeerService) expireMissing(peerName string, snapshot map[uint32]bool) {
+	servers, err := p.registeredServerStore.GetAllRegisteredServers()
+	if err != nil {
+		logger.Error("Could not compare servers with peer snapshot", logging.Tracker(peerName), logging.Err(err))
+		return
+	}
+
+	for _, server := range servers {
+		if server.Origin != peerName || snapshot[server.PassID] {
+			continue
+		}
+
+		if removed, err := p.registeredServerStore.RemoveServer(server.PassID); err != nil {
+			logger.Error("Could not remove server missing from peer snapshot", logging.Tracker(peerName), logging.PassID(server.PassID), logging.Err(err))
+		} else {
+			logger.Info("Expired server missing from peer snapshot", logging.Tracker(peerName), logging.ServerName(removed.Name), logging.PassID(removed.PassID))
+			events.Publish(events.Event{Type: events.ServerExpired, Origin: peerName, Via: []string{peerName}, Server: removed})
+		}
+	}
+}
+
+func (p *PeerService) applyChange(peerName string, change ChangeMessage) {
+	self := p.cfg.Peering.NodeName
+
+	// Changes that started here or already passed through here are echoes and must not be applied again.
+	if change.Origin == "" || change.Origin == self || slices.Contains(change.Via, self) {
+		return
+	}
+
+	via := append(slices.Clone(change.Via), peerName)
+
+	switch change.Type {
+	case events.ServerRegistered, events.ServerUpdated:
+		server := change.Server.ToRegisteredServer(change.Origin)
+
+		// Bans apply to replicated servers as they do to registrations, whether or not the origin bans them too.
+		if ban, banned, err := p.banStore.FindBan(server.PassID, server.Host, server.Port); err != nil {
+			logger.Error("Could not look up bans", logging.PassID(server.PassID), logging.Err(err))
+		} else if banned {
+			logger.Debug("Ignoring banned server from peer", logging.Tracker(peerName), logging.ServerName(server.Name), logging.PassID(server.PassID), "reason", ban.Reason)
+			return
+		}
+
+		applied, isNew, changed, err := p.registeredServerStore.ApplyPeerServer(server)
+		if err != nil {
+			logger.Error("Could not apply server from peer", logging.Tracker(peerName), logging.PassID(server.PassID), logging.Err(err))
+			return
+		}
+
+		if !applied {
+			return
+		}
+
+		// The kind of change is decided against the local copy, since it may differ from the origin's.
+		eventType := events.ServerRefreshed
+		if isNew {
+			eventType = events.ServerRegistered
+			logger.Info("Replicated new server", logging.ServerName(server.Name), logging.PassID(server.PassID), "origin", change.Origin, "host", server.Host, "port", server.Port)
+		} else if changed {
+			eventType = events.ServerUpdated
+		}
+
+		events.Publish(events.Event{Type: eventType, Origin: change.Origin, Via: via, Server: server})
+
+	case events.ServerExpired:
+		existing, err := p.registeredServerStore.GetRegisteredServer(change.Server.PassID)
+		if err != nil || existing.Origin != change.Origin {
+			return
+		}
+
+		if removed, err := p.registeredServerStore.RemoveServer(existing.PassID); err != nil {
+			logger.Error("Could not remove server expired by peer", logging.Tracker(peerName), logging.PassID(existing.PassID), logging.Err(err))
+		} else {
+			events.Publish(events.Event{Type: events.ServerExpired, Origin: change.Origin, Via: via, Server: removed})
+		}
+
+	default:
+		logger.Warn("Ignoring unknown change type from peer", logging.Tracker(peerName), "change_type", change.Type)
+	}
+}
+
+func (p *PeerService) streamToPeer(peer config.PeerEntry) {
+	delay := minReconnectDelay
+
+	for {
+		startedAt := time.Now()
+
+		if err := p.connectAndStream(peer); err != nil {
+			logger.Warn("Peer connection lost", logging.Tracker(peer.Name), "address", peer.Address, logging.Err(err))
+		}
+
+		if time.Since(startedAt) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (p *PeerService) connectAndStream(peer config.PeerEntry) error {
+	// Subscribe before taking the snapshot so no change falls between the two.
+	subscription := events.Subscribe(1024)
+	defer subscription.Unsubscribe()
+
+	conn, err := net.DialTimeout("tcp", peer.Address, handshakeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+
+	secret := p.cfg.Peering.GetSecret(peer.Name)
+
+	hello, err := NewHelloMessage(p.cfg.Peering.NodeName, secret)
+	if err != nil {
+		return err
+	}
+
+	if err := encoder.Encode(hello); err != nil {
+		return err
+	}
+
+	var welcome WelcomeMessage
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if err := decoder.Decode(&welcome); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if err := welcome.Verify(secret, hello); err != nil {
+		return err
+	}
+
+	logger.Info("Streaming changes to peer", logging.Tracker(peer.Name), "address", peer.Address)
+
+	sealer := &changeSealer{key: sessionKey(secret, hello, welcome)}
+
+	if err := p.sendSnapshot(peer, encoder, sealer); err != nil {
+		return err
+	}
+
+	lastEventID := subscription.Start
+
+	for event := range subscription.C {
+		// Every subscriber sees every event, so a gap in the IDs means the bus dropped some for this peer. Starting
+		// over sends a fresh snapshot, which catches the peer up.
+		if event.ID > lastEventID+1 {
+			return fmt.Errorf("missed %d changes, reconnecting to send a snapshot", event.ID-lastEventID-1)
+		}
+		lastEventID = event.ID
+
+		if !p.shouldForward(peer, event) {
+			continue
+		}
+
+		if err := p.sendChange(encoder, sealer, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendSnapshot brings a peer up to date after (re)connecting, since changes made while disconnected are not queued.
+// It ends with snapshotComplete, upon which the peer drops the servers of this tracker the snapshot did not list.
+func (p *PeerService) sendSnapshot(peer config.PeerEntry, encoder *json.Encoder, sealer *changeSealer) error {
+	servers, err := p.registeredServerStore.GetAllRegisteredServers()
+	if err != nil {
+		return err
+	}
+
+	for _, server := range servers {
+		event := events.Event{Type: events.ServerUpdated, Origin: server.Origin, Server: server}
+
+		if !p.shouldForward(peer, event) {
+			continue
+		}
+
+		if err := p.sendChange(encoder, sealer, event); err != nil {
+			return err
+		}
+	}
+
+	return p.sendMessage(encoder, sealer, ChangeMessage{Type: snapshotComplete, Origin: p.cfg.Peering.NodeName})
+}
+
+func (p *PeerService) sendChange(encoder *json.Encoder, sealer *changeSealer, event events.Event) error {
+	return p.sendMessage(encoder, sealer, p.newChangeMessage(event))
+}
+
+func (p *PeerService) sendMessage(encoder *json.Encoder, sealer *changeSealer, change ChangeMessage) error {
+	envelope, err := sealer.seal(change)
+	if err != nil {
+		return err
+	}
+
+	return encoder.Encode(envelope)
+}
+
+func (p *PeerService) shouldForward(peer config.PeerEntry, event events.Event) bool {
+	switch event.Type {
+	case events.ServerRegistered, events.ServerUpdated, events.ServerRefreshed, events.ServerExpired:
+	default:
+		return false
+	}
+
+	return event.Origin != peer.Name && !slices.Contains(event.Via, peer.Name)
+}
+
+func (p *PeerService) newChangeMessage(event events.Event) ChangeMessage {
+	origin := event.Origin
+	if origin == "" {
+		origin = p.cfg.Peering.NodeName
+	}
+
+	// Refreshes travel as updates so that peers running older versions keep the server's last seen time current.
+	changeType := event.Type
+	if changeType == events.ServerRefreshed {
+		changeType = events.ServerUpdated
+	}
+
+	return ChangeMessage{
+		Type:   changeType,
+		Origin: origin,
+		Via:    event.Via,
+		Server: NewServerDocument(event.Server),
+	}
+}
@@ -0,0 +1,272 @@
+package peer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Peers speak newline-delimited JSON over TCP. The dialing tracker opens with a HelloMessage signed with the
+// shared secret, and the listening tracker answers with a WelcomeMessage that signs the dialer's nonce and a nonce
+// of its own, so that each side proves it knows the secret. Both nonces derive a session key, and from then on the
+// dialer streams ChangeEnvelopes, each authenticated with the session key and numbered so that none can be replayed,
+// dropped or reordered unnoticed. Replication is one-way per connection, so two trackers that wish to exchange
+// changes list each other as peers.
+
+const (
+	maxClockSkew = 2 * time.Minute
+)
+
+// snapshotComplete ends the snapshot the dialer sends after connecting. The snapshot lists every server of the
+// dialer's own origin, so the ones it left out expired while the trackers were disconnected.
+const snapshotComplete events.EventType = "snapshot.complete"
+
+type HelloMessage struct {
+	Node      string `json:"node"`
+	Time      int64  `json:"time"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+type WelcomeMessage struct {
+	Node      string `json:"node"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"` // Signs the hello's nonce and this nonce
+	Error     string `json:"error,omitempty"`
+}
+
+// ChangeEnvelope carries a ChangeMessage with the MAC of its sequence number and encoded change.
+type ChangeEnvelope struct {
+	Seq    uint64          `json:"seq"`
+	Change json.RawMessage `json:"change"`
+	MAC    string          `json:"mac"`
+}
+
+type ChangeMessage struct {
+	Type   events.EventType `json:"type"`
+	Origin string           `json:"origin"`
+	Via    []string         `json:"via"`
+	Server ServerDocument   `json:"server"`
+}
+
+type ServerDocument struct {
+	PassID      uint32    `json:"passId"`
+	Host        string    `json:"host"`
+	Port        uint16    `json:"port"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	UserCount   uint16    `json:"userCount"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Verified    bool      `json:"verified,omitempty"`
+}
+
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
+
+// mac signs the parts of a message, each message kind with a label of its own so that one can not stand in for
+// another.
+func mac(key string, label string, parts ...string) string {
+	digest := hmac.New(sha256.New, []byte(key))
+	digest.Write([]byte(label))
+	for _, part := range parts {
+		digest.Write([]byte("\n" + part))
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+func NewHelloMessage(node string, secret string) (HelloMessage, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return HelloMessage{}, err
+	}
+
+	msg := HelloMessage{
+		Node:  node,
+		Time:  time.Now().Unix(),
+		Nonce: nonce,
+	}
+	msg.Signature = msg.sign(secret)
+
+	return msg, nil
+}
+
+func (m *HelloMessage) sign(secret string) string {
+	return mac(secret, "hello", m.Node, strconv.FormatInt(m.Time, 10), m.Nonce)
+}
+
+// Verify checks the hello's signature and freshness. Nonces are recorded in seen, so that a hello is accepted once.
+func (m *HelloMessage) Verify(secret string, seen *nonceCache) error {
+	if secret == "" {
+		return fmt.Errorf("no shared secret configured for peer %s", m.Node)
+	}
+
+	skew := time.Since(time.Unix(m.Time, 0))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("hello from peer %s is outside the allowed clock skew", m.Node)
+	}
+
+	if m.Nonce == "" || !hmac.Equal([]byte(m.sign(secret)), []byte(m.Signature)) {
+		return fmt.Errorf("invalid signature from peer %s", m.Node)
+	}
+
+	if !seen.add(m.Nonce) {
+		return fmt.Errorf("replayed hello from peer %s", m.Node)
+	}
+
+	return nil
+}
+
+// NewWelcomeMessage answers a verified hello.
+func NewWelcomeMessage(node string, secret string, hello HelloMessage) (WelcomeMessage, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return WelcomeMessage{}, err
+	}
+
+	msg := WelcomeMessage{Node: node, Nonce: nonce}
+	msg.Signature = msg.sign(secret, hello)
+
+	return msg, nil
+}
+
+func (m *WelcomeMessage) sign(secret string, hello HelloMessage) string {
+	return mac(secret, "welcome", m.Node, hello.Node, hello.Nonce, m.Nonce)
+}
+
+// Verify checks that the welcome answers the hello it was sent in reply to.
+func (m *WelcomeMessage) Verify(secret string, hello HelloMessage) error {
+	if m.Error != "" {
+		return fmt.Errorf("peer refused connection: %s", m.Error)
+	}
+
+	if m.Nonce == "" || m.Nonce == hello.Nonce || !hmac.Equal([]byte(m.sign(secret, hello)), []byte(m.Signature)) {
+		return fmt.Errorf("invalid welcome signature from peer %s", m.Node)
+	}
+
+	return nil
+}
+
+// sessionKey derives the key the changes of a connection are authenticated with.
+func sessionKey(secret string, hello HelloMessage, welcome WelcomeMessage) string {
+	return mac(secret, "session", hello.Node, welcome.Node, hello.Nonce, welcome.Nonce)
+}
+
+// changeSealer numbers and authenticates the changes sent over a connection.
+type changeSealer struct {
+	key string
+	seq uint64
+}
+
+func (s *changeSealer) seal(change ChangeMessage) (ChangeEnvelope, error) {
+	encoded, err := json.Marshal(change)
+	if err != nil {
+		return ChangeEnvelope{}, err
+	}
+
+	s.seq++
+
+	return ChangeEnvelope{
+		Seq:    s.seq,
+		Change: encoded,
+		MAC:    mac(s.key, "change", strconv.FormatUint(s.seq, 10), string(encoded)),
+	}, nil
+}
+
+// changeOpener checks the changes received over a connection.
+type changeOpener struct {
+	key string
+	seq uint64
+}
+
+func (o *changeOpener) open(envelope ChangeEnvelope) (ChangeMessage, error) {
+	if !hmac.Equal([]byte(mac(o.key, "change", strconv.FormatUint(envelope.Seq, 10), string(envelope.Change))), []byte(envelope.MAC)) {
+		return ChangeMessage{}, fmt.Errorf("invalid change signature")
+	}
+
+	if envelope.Seq != o.seq+1 {
+		return ChangeMessage{}, fmt.Errorf("change %d received out of sequence, expected %d", envelope.Seq, o.seq+1)
+	}
+	o.seq = envelope.Seq
+
+	var change ChangeMessage
+	if err := json.Unmarshal(envelope.Change, &change); err != nil {
+		return ChangeMessage{}, err
+	}
+
+	return change, nil
+}
+
+// nonceCache remembers the nonces of accepted hellos for as long as their hellos could pass the clock skew check.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// add records a nonce, and reports false when it was already recorded.
+func (c *nonceCache) add(nonce string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for seenNonce, seenAt := range c.seen {
+		if now.Sub(seenAt) > 2*maxClockSkew {
+			delete(c.seen, seenNonce)
+		}
+	}
+
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+
+	c.seen[nonce] = now
+	return true
+}
+
+func NewServerDocument(server db.RegisteredServer) ServerDocument {
+	return ServerDocument{
+		PassID:      server.PassID,
+		Host:        server.Host,
+		Port:        server.Port,
+		Name:        server.Name,
+		Description: server.Description,
+		UserCount:   server.UserCount,
+		FirstSeen:   server.FirstSeen,
+		LastSeen:    server.LastSeen,
+		Verified:    server.Verified,
+	}
+}
+
+func (d ServerDocument) ToRegisteredServer(origin string) db.RegisteredServer {
+	return db.RegisteredServer{
+		PassID:      d.PassID,
+		Host:        d.Host,
+		Port:        d.Port,
+		Name:        d.Name,
+		Description: d.Description,
+		UserCount:   d.UserCount,
+		FirstSeen:   d.FirstSeen,
+		LastSeen:    d.LastSeen,
+		Origin:      origin,
+		Verified:    d.Verified,
+	}
+}
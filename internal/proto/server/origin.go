@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// Relaying trackers may name the server a registration was received from in a block between the password and the
+// signature, so that it is covered by the signature:
+//
+//	"MGA1"      4 bytes, marks the extension
+//	Address     4 bytes, IPv4 address of the server
+const (
+	OriginMagic = "MGA1"
+	originSize  = net.IPv4len
+)
+
+// readOrigin parses the origin extension starting at offset. It returns nil and the offset unchanged when the
+// registration does not carry one, and otherwise the offset following it.
+func readOrigin(input []byte, offset int) ([]byte, int, error) {
+	if len(input) < offset+len(OriginMagic) || string(input[offset:offset+len(OriginMagic)]) != OriginMagic {
+		return nil, offset, nil
+	}
+
+	position := offset + len(OriginMagic)
+	if len(input) < position+originSize {
+		return nil, offset, errors.New("origin is truncated")
+	}
+
+	return input[position : position+originSize], position + originSize, nil
+}
+
+// SetOrigin names the server a relayed registration was received from.
+func (msg *ServerRegistration) SetOrigin(host string) error {
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return errors.New("origin must be an IPv4 address")
+	}
+
+	msg.Origin = ip
+	return nil
+}
+
+// OriginHost returns the address of the server a relayed registration was received from, or an empty string.
+func (msg *ServerRegistration) OriginHost() string {
+	if len(msg.Origin) != originSize {
+		return ""
+	}
+
+	return net.IP(msg.Origin).String()
+}
@@ -6,17 +6,19 @@ import (
 )
 
 type ServerRegistration struct {
-	magic           [2]byte // Magic number
-	Port            [2]byte // Port number
-	NumberOfUsers   [2]byte // Number of users connected to this particular server
-	magic2          [2]byte // Magic number part deux
-	PassId          [4]byte // Pass ID
-	NameSize        byte    // Length of name string
-	Name            []byte  // Server name
-	DescriptionSize byte    // Length of description string
-	Description     []byte  // Server description
-	PasswordSize    byte    // Length of password string
-	Password        []byte  // Server password
+	magic           [2]byte    // Magic number
+	Port            [2]byte    // Port number
+	NumberOfUsers   [2]byte    // Number of users connected to this particular server
+	magic2          [2]byte    // Magic number part deux
+	PassId          [4]byte    // Pass ID
+	NameSize        byte       // Length of name string
+	Name            []byte     // Server name
+	DescriptionSize byte       // Length of description string
+	Description     []byte     // Server description
+	PasswordSize    byte       // Length of password string
+	Password        []byte     // Server password
+	Origin          []byte     // IPv4 address of the server a relayed registration was received from, nil when not relayed
+	Signature       *Signature // Signature following the password, nil when the registration is not signed
 }
 
 func ReadServerRegistration(input []byte) (*ServerRegistration, *proto.ProtoError) {
@@ -61,6 +63,71 @@ func ReadServerRegistration(input []byte) (*ServerRegistration, *proto.ProtoErro
 
 	msg.Password = input[15+msg.NameSize+msg.DescriptionSize : 15+msg.NameSize+msg.DescriptionSize+msg.PasswordSize]
 
+	origin, offset, err := readOrigin(input, 15+int(msg.NameSize)+int(msg.DescriptionSize)+int(msg.PasswordSize))
+	if err != nil {
+		result := proto.ProtoError{
+			Error:        err,
+			ErrorMessage: "Invalid server registration origin",
+			Expected:     msg,
+		}
+		return nil, &result
+	}
+	msg.Origin = origin
+
+	signature, err := readSignature(input, offset)
+	if err != nil {
+		result := proto.ProtoError{
+			Error:        err,
+			ErrorMessage: "Invalid server registration signature",
+			Expected:     msg,
+		}
+		return nil, &result
+	}
+	msg.Signature = signature
+
 	return &msg, nil
 
 }
+
+func BuildServerRegistration(port [2]byte, numberOfUsers [2]byte, passId [4]byte, name []byte, description []byte, password []byte) ServerRegistration {
+	return ServerRegistration{
+		magic:           [2]byte{0x00, 0x01},
+		Port:            port,
+		NumberOfUsers:   numberOfUsers,
+		magic2:          [2]byte{0x00, 0x00},
+		PassId:          passId,
+		NameSize:        byte(len(name)),
+		Name:            name,
+		DescriptionSize: byte(len(description)),
+		Description:     description,
+		PasswordSize:    byte(len(password)),
+		Password:        password,
+	}
+}
+
+func (msg *ServerRegistration) GetMessageInBytes() []byte {
+
+	msgBytes := make([]byte, 0, 15+len(msg.Name)+len(msg.Description)+len(msg.Password)+len(OriginMagic)+len(msg.Origin))
+
+	msgBytes = append(msgBytes, msg.magic[:]...)
+	msgBytes = append(msgBytes, msg.Port[:]...)
+	msgBytes = append(msgBytes, msg.NumberOfUsers[:]...)
+	msgBytes = append(msgBytes, msg.magic2[:]...)
+	msgBytes = append(msgBytes, msg.PassId[:]...)
+
+	msgBytes = append(msgBytes, msg.NameSize)
+	msgBytes = append(msgBytes, msg.Name...)
+
+	msgBytes = append(msgBytes, msg.DescriptionSize)
+	msgBytes = append(msgBytes, msg.Description...)
+
+	msgBytes = append(msgBytes, msg.PasswordSize)
+	msgBytes = append(msgBytes, msg.Password...)
+
+	if msg.Origin != nil {
+		msgBytes = append(msgBytes, OriginMagic...)
+		msgBytes = append(msgBytes, msg.Origin...)
+	}
+
+	return msgBytes
+}
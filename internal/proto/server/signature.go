@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Registrations may carry a signature after the password block and the origin, if any, which trackers that do not
+// know the extension never read:
+//
+//	"MGS1"      4 bytes, marks the extension
+//	KeyIDSize   1 byte
+//	KeyID       KeyIDSize bytes, names the secret the registration is signed with
+//	Timestamp   8 bytes, Unix seconds, big endian
+//	Nonce       8 bytes, random
+//	MAC         32 bytes, HMAC-SHA256 under the secret of every byte of the registration before it
+const (
+	SignatureMagic = "MGS1"
+	nonceSize      = 8
+	macSize        = sha256.Size
+)
+
+type Signature struct {
+	KeyID     []byte          // Names the secret the registration is signed with
+	Timestamp uint64          // When the registration was signed, in Unix seconds
+	Nonce     [nonceSize]byte // Random, makes every signed registration unique
+	MAC       [macSize]byte   // HMAC-SHA256 of signed
+	signed    []byte          // Bytes of the registration covered by the MAC
+}
+
+// readSignature parses the signature extension starting at offset, returning nil when the registration is not
+// signed.
+func readSignature(input []byte, offset int) (*Signature, error) {
+	if len(input) < offset+len(SignatureMagic) || string(input[offset:offset+len(SignatureMagic)]) != SignatureMagic {
+		return nil, nil
+	}
+
+	position := offset + len(SignatureMagic)
+	if len(input) < position+1 {
+		return nil, errors.New("signature is truncated")
+	}
+
+	keyIDSize := int(input[position])
+	position++
+
+	if len(input) < position+keyIDSize+8+nonceSize+macSize {
+		return nil, errors.New("signature is truncated")
+	}
+
+	var signature Signature
+
+	signature.KeyID = input[position : position+keyIDSize]
+	position += keyIDSize
+
+	signature.Timestamp = binary.BigEndian.Uint64(input[position : position+8])
+	position += 8
+
+	copy(signature.Nonce[:], input[position:position+nonceSize])
+	position += nonceSize
+
+	signature.signed = input[:position]
+	copy(signature.MAC[:], input[position:position+macSize])
+
+	return &signature, nil
+}
+
+// GetSignedMessageInBytes encodes the registration followed by a signature under the secret named keyID.
+func (msg *ServerRegistration) GetSignedMessageInBytes(keyID string, secret []byte, now time.Time) ([]byte, error) {
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("signing key ID is longer than 255 bytes")
+	}
+
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+
+	msgBytes := msg.GetMessageInBytes()
+	msgBytes = append(msgBytes, SignatureMagic...)
+	msgBytes = append(msgBytes, byte(len(keyID)))
+	msgBytes = append(msgBytes, keyID...)
+	msgBytes = binary.BigEndian.AppendUint64(msgBytes, uint64(now.Unix()))
+	msgBytes = append(msgBytes, nonce[:]...)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(msgBytes)
+
+	return mac.Sum(msgBytes), nil
+}
+
+// ReplayGuard verifies signed registrations, refusing ones signed too long ago and ones it has already seen.
+type ReplayGuard struct {
+	window time.Duration
+	mu     sync.Mutex
+	seen   map[string]time.Time // Key ID and nonce of the registrations verified within the window
+}
+
+// NewReplayGuard returns a guard accepting registrations signed up to window before or after the current time.
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{window: window, seen: make(map[string]time.Time)}
+}
+
+// Verify checks the signature of a registration under secret. A registration passes once; the same registration
+// received again is refused as a replay.
+func (g *ReplayGuard) Verify(msg *ServerRegistration, secret []byte, now time.Time) error {
+	signature := msg.Signature
+	if signature == nil {
+		return errors.New("registration is not signed")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(signature.signed)
+	if !hmac.Equal(mac.Sum(nil), signature.MAC[:]) {
+		return errors.New("signature does not match")
+	}
+
+	signedAt := time.Unix(int64(signature.Timestamp), 0)
+	if skew := now.Sub(signedAt); skew > g.window || skew < -g.window {
+		return fmt.Errorf("signature is %s away from the tracker's clock", skew.Round(time.Second))
+	}
+
+	key := string(signature.KeyID) + "\x00" + string(signature.Nonce[:])
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, replayed := g.seen[key]; replayed {
+		return errors.New("registration was already received")
+	}
+
+	// Nonces are only remembered while their timestamp is within the window, after which the clock check refuses
+	// them anyway.
+	for seenKey, seenAt := range g.seen {
+		if now.Sub(seenAt) > 2*g.window {
+			delete(g.seen, seenKey)
+		}
+	}
+
+	g.seen[key] = signedAt
+
+	return nil
+}
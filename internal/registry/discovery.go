@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"magnetron/internal/logging"
+	"magnetron/internal/proto/client"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTrackerPort  = 5498
+	maxConcurrentProbes = 8
+
+	defaultMaxCandidates = 50
+	defaultProbeTimeout  = 5 * time.Second
+)
+
+type discoveryState struct {
+	mu      sync.Mutex
+	probed  map[string]time.Time
+	probing chan struct{}
+}
+
+func newDiscoveryState() *discoveryState {
+	return &discoveryState{
+		probed:  make(map[string]time.Time),
+		probing: make(chan struct{}, maxConcurrentProbes),
+	}
+}
+
+// claim reports whether the host is due for a probe and, if so, marks it as probed.
+func (d *discoveryState) claim(address string, interval time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if probedAt, ok := d.probed[address]; ok && time.Since(probedAt) < interval {
+		return false
+	}
+
+	d.probed[address] = time.Now()
+	return true
+}
+
+// discoverTrackers probes every host in a tracker listing for a tracker listening on the default tracker port.
+// Hosts that answer the HTRK handshake are recorded as candidate trackers for an operator to review.
+func (r *Registry) discoverTrackers(trackerHost string, trackerPort uint16, listing []client.ServerMessage, depth uint16) {
+	discoveryCfg := r.cfg.TrackerFederation.Discovery
+
+	if !discoveryCfg.Enabled || depth > discoveryCfg.MaxDepth {
+		return
+	}
+
+	discoveredVia := net.JoinHostPort(trackerHost, strconv.Itoa(int(trackerPort)))
+
+	for _, serverMsg := range listing {
+		if serverMsg.IPAddr == [4]byte{0, 0, 0, 0} {
+			continue
+		}
+
+		host := net.IPv4(serverMsg.IPAddr[0], serverMsg.IPAddr[1], serverMsg.IPAddr[2], serverMsg.IPAddr[3]).String()
+
+		if r.isKnownTracker(host, defaultTrackerPort) {
+			continue
+		}
+
+		if !r.discovery.claim(net.JoinHostPort(host, strconv.Itoa(defaultTrackerPort)), discoveryCfg.ProbeInterval) {
+			continue
+		}
+
+		listedName := string(serverMsg.Name)
+		go r.probeCandidateTracker(host, defaultTrackerPort, listedName, depth, discoveredVia)
+	}
+}
+
+func (r *Registry) probeCandidateTracker(host string, port uint16, listedName string, depth uint16, discoveredVia string) {
+	discoveryCfg := r.cfg.TrackerFederation.Discovery
+
+	probeTimeout := discoveryCfg.ProbeTimeout
+	if probeTimeout <= 0 {
+		probeTimeout = defaultProbeTimeout
+	}
+
+	maxCandidates := int64(discoveryCfg.MaxCandidates)
+	if maxCandidates == 0 {
+		maxCandidates = defaultMaxCandidates
+	}
+
+	r.discovery.probing <- struct{}{}
+	listing, err := fetchTrackerListing(host, port, probeTimeout, nil)
+	<-r.discovery.probing
+
+	if err != nil && len(listing) == 0 {
+		return
+	}
+
+	if _, err := r.candidateTrackerStore.GetCandidateTracker(host, port); err != nil {
+		if count, err := r.candidateTrackerStore.CountCandidateTrackers(); err != nil {
+			federationLogger.Error("Could not count candidate trackers", logging.Err(err))
+			return
+		} else if count >= maxCandidates {
+			return
+		}
+
+		federationLogger.Info("Discovered candidate tracker", logging.Tracker(trackerLabel(host, port)), "via", discoveredVia)
+	}
+
+	if _, err := r.candidateTrackerStore.SaveCandidateTracker(host, port, listedName, uint16(len(listing)), depth, discoveredVia); err != nil {
+		federationLogger.Error("Could not save candidate tracker", logging.Tracker(trackerLabel(host, port)), logging.Err(err))
+		return
+	}
+
+	r.discoverTrackers(host, port, listing, depth+1)
+}
+
+func (r *Registry) isKnownTracker(host string, port uint16) bool {
+	if _, err := r.federatedTrackerStore.GetFederatedTracker(host, port); err == nil {
+		return true
+	}
+
+	if clientHost, err := r.cfg.GetHost(); err == nil && clientHost == host {
+		if clientPort, err := r.cfg.GetPort(); err == nil && clientPort == port {
+			return true
+		}
+	}
+
+	return false
+}
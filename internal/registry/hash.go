@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Password hash algorithms. New hashes use argon2id, bcrypt hashes are still accepted and upgraded when a server
+// registers with one.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Argon2id parameters of new hashes, the OWASP recommendation for servers checking many passwords. Hashes record
+// their parameters, so changing these does not invalidate existing hashes.
+const (
+	argon2Memory  = 19 * 1024 // KiB
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// HashPassword hashes a password with the given algorithm, argon2id when empty.
+func HashPassword(password string, algorithm string) (string, error) {
+	switch algorithm {
+	case "", Argon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	default:
+		return "", fmt.Errorf("unknown password hash algorithm %s, expected %s or %s", algorithm, Argon2id, Bcrypt)
+	}
+}
+
+// HashAlgorithm returns the algorithm of a password hash.
+func HashAlgorithm(hash string) string {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return Argon2id
+	}
+	return Bcrypt
+}
+
+// checkPasswordHash reports whether the password matches an argon2id or bcrypt hash.
+func checkPasswordHash(hash string, password string) bool {
+	if HashAlgorithm(hash) == Bcrypt {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+
+	// $argon2id$v=19$m=19456,t=2,p=1$salt$key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+
+	supplied := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(supplied, key) == 1
+}
@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"magnetron/internal/logging"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// trackerHealth remembers whether each federated tracker answered its last poll, so that only changes are reported.
+type trackerHealth struct {
+	mu sync.Mutex
+	up map[string]bool
+}
+
+func newTrackerHealth() *trackerHealth {
+	return &trackerHealth{
+		up: make(map[string]bool),
+	}
+}
+
+// report records the outcome of a poll and publishes an event when the tracker's state changed.
+func (h *trackerHealth) report(tracker db.FederatedTracker, pollError error) {
+	address := net.JoinHostPort(tracker.Host, strconv.Itoa(int(tracker.Port)))
+	isUp := pollError == nil
+
+	h.mu.Lock()
+	wasUp, known := h.up[address]
+	h.up[address] = isUp
+	h.mu.Unlock()
+
+	if known && wasUp == isUp {
+		return
+	}
+
+	if isUp {
+		federationLogger.Info("Federated tracker is up", logging.Tracker(address))
+		events.Publish(events.Event{Type: events.TrackerUp, Tracker: tracker})
+	} else {
+		federationLogger.Warn("Federated tracker is down", logging.Tracker(address), logging.Err(pollError))
+		events.Publish(events.Event{Type: events.TrackerDown, Tracker: tracker, Reason: pollError.Error()})
+	}
+}
@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"magnetron/internal/logging"
+	"magnetron/internal/metrics"
+	"net"
+	"strconv"
+)
+
+// Reasons a server registration is rejected, used as the reason label of rejectedPackets.
+const (
+	rejectMalformed  = "malformed"
+	rejectPassword   = "password"
+	rejectRestricted = "restricted" // The password entry does not allow the name, address or another server
+	rejectSignature  = "signature"  // The registration is signed, but not validly, recently or for the first time
+	rejectBanned     = "banned"
+	rejectInternal   = "internal"
+)
+
+var (
+	receivedPackets      = metrics.NewCounter("magnetron_udp_packets_received_total", "UDP registration packets received from servers.")
+	acceptedPackets      = metrics.NewCounter("magnetron_udp_packets_accepted_total", "UDP registration packets that registered or refreshed a server.")
+	rejectedPackets      = metrics.NewCounter("magnetron_udp_packets_rejected_total", "UDP registration packets that were refused, by reason.", "reason")
+	passwordRejections   = metrics.NewCounter("magnetron_password_rejections_total", "Registrations refused because of an invalid password.")
+	droppedPackets       = metrics.NewCounter("magnetron_udp_packets_dropped_total", "UDP registration packets dropped because the registration queue was full.")
+	passwordCacheLookups = metrics.NewCounter("magnetron_password_cache_lookups_total", "Password checks answered from the password cache or not, by result.", "result")
+	dryRunRejections     = metrics.NewCounter("magnetron_password_dry_run_rejections_total", "Registrations accepted in dry-run mode that would be refused if passwords were required, by reason.", "reason")
+	listingsServed       = metrics.NewCounter("magnetron_client_listings_total", "Server listings sent to Hotline clients.")
+	listingDuration      = metrics.NewHistogram("magnetron_client_listing_duration_seconds", "Time taken to build and send a listing to a Hotline client.", metrics.DefaultBuckets)
+	clientConnections    = metrics.NewGauge("magnetron_client_connections", "Hotline client connections currently open.")
+	federationPolls      = metrics.NewCounter("magnetron_federation_polls_total", "Polls of federated trackers, by tracker and result.", "tracker", "result")
+	federationPollTimes  = metrics.NewHistogram("magnetron_federation_poll_duration_seconds", "Time taken to poll a federated tracker.", metrics.DefaultBuckets, "tracker")
+)
+
+func (r *Registry) registerQueueGauges() {
+	metrics.NewGaugeFunc("magnetron_registration_queue_depth", "Registrations waiting for a worker.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(len(r.queue))}}
+	})
+
+	metrics.NewGaugeFunc("magnetron_registration_queue_capacity", "Registrations that can wait for a worker before new ones are dropped.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(cap(r.queue))}}
+	})
+}
+
+func rejectPacket(reason string) {
+	rejectedPackets.Inc(reason)
+	if reason == rejectPassword {
+		passwordRejections.Inc()
+	}
+}
+
+// registerGauges reports the contents of the registry, computed whenever the metrics are scraped.
+func (r *Registry) registerGauges() {
+	metrics.NewGaugeFunc("magnetron_servers", "Servers known to the tracker, by source.", []string{"source"}, func() []metrics.Sample {
+		counts := r.serverStats()
+		return []metrics.Sample{
+			{Labels: []string{"registered"}, Value: float64(counts.registered)},
+			{Labels: []string{"static"}, Value: float64(counts.static)},
+			{Labels: []string{"federated"}, Value: float64(counts.federated)},
+		}
+	})
+
+	metrics.NewGaugeFunc("magnetron_advertised_users", "Users advertised by the listed servers, by source.", []string{"source"}, func() []metrics.Sample {
+		counts := r.serverStats()
+		return []metrics.Sample{
+			{Labels: []string{"registered"}, Value: float64(counts.registeredUsers)},
+			{Labels: []string{"static"}, Value: float64(counts.staticUsers)},
+			{Labels: []string{"federated"}, Value: float64(counts.federatedUsers)},
+		}
+	})
+
+	metrics.NewGaugeFunc("magnetron_federated_trackers", "Federated trackers that are polled.", nil, func() []metrics.Sample {
+		trackers, err := r.federatedTrackerStore.GetFederatedTrackers()
+		if err != nil {
+			logger.Error("Could not get federated trackers", logging.Err(err))
+		}
+		return []metrics.Sample{{Value: float64(len(trackers))}}
+	})
+}
+
+type serverStats struct {
+	registered, static, federated                int
+	registeredUsers, staticUsers, federatedUsers uint64
+}
+
+func (r *Registry) serverStats() serverStats {
+	var stats serverStats
+
+	if servers, err := r.registeredServerStore.GetAllRegisteredServers(); err != nil {
+		logger.Error("Could not get registered servers", logging.Err(err))
+	} else {
+		stats.registered = len(servers)
+		for _, server := range servers {
+			stats.registeredUsers += uint64(server.UserCount)
+		}
+	}
+
+	if servers, err := r.staticServerStore.GetStaticServers(); err != nil {
+		logger.Error("Could not get static servers", logging.Err(err))
+	} else {
+		stats.static = len(servers)
+		for _, server := range servers {
+			stats.staticUsers += uint64(server.UserCount)
+		}
+	}
+
+	if trackers, err := r.federatedTrackerStore.GetFederatedTrackers(); err != nil {
+		logger.Error("Could not get federated trackers", logging.Err(err))
+	} else {
+		for _, tracker := range trackers {
+			servers, err := r.federatedServerStore.GetFederatedServers(tracker.Host, tracker.Port)
+			if err != nil {
+				logger.Error("Could not get federated servers", logging.Tracker(trackerLabel(tracker.Host, tracker.Port)), logging.Err(err))
+				continue
+			}
+
+			stats.federated += len(servers)
+			for _, server := range servers {
+				stats.federatedUsers += uint64(server.UserCount)
+			}
+		}
+	}
+
+	return stats
+}
+
+func trackerLabel(host string, port uint16) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
@@ -1,22 +1,25 @@
 package registry
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
-	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/term"
 	"magnetron/internal/config"
+	"magnetron/internal/proto/server"
+	"sync"
 	"syscall"
+	"time"
 )
 
-func EncryptPassword(password string) (string, error) {
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-
-	return string(hash), err
-}
+const (
+	defaultPasswordCacheTTL = 10 * time.Minute
+	passwordCacheSize       = 4096 // Results kept before expired ones are pruned
+)
 
-func PromptUserForPassword() (string, error) {
-	fmt.Print("Enter password: ")
+func PromptUserForPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
 
 	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
 
@@ -29,11 +32,198 @@ func PromptUserForPassword() (string, error) {
 
 func CheckPassword(password string, passwordConfig config.PasswordConfig) bool {
 
+	_, ok := MatchPassword(password, passwordConfig)
+
+	return ok
+}
+
+// MatchPassword returns the first password entry whose hash matches the supplied password.
+func MatchPassword(password string, passwordConfig config.PasswordConfig) (config.PasswordEntry, bool) {
+
 	for _, entry := range passwordConfig.PasswordEntries {
-		if err := bcrypt.CompareHashAndPassword([]byte(entry.Password), []byte(password)); err == nil {
-			return true
+		if checkPasswordHash(entry.Password, password) {
+			return entry, true
+		}
+	}
+
+	return config.PasswordEntry{}, false
+}
+
+// passwordCache remembers which entry a password matched, as checking a password against every bcrypt hash is by far
+// the slowest part of a registration and servers send the same password with every heartbeat. Results are keyed by
+// an HMAC of the password under a key generated at startup, so neither passwords nor hashes that could be attacked
+// offline are kept in memory.
+type passwordCache struct {
+	ttl     time.Duration
+	key     []byte
+	mu      sync.Mutex
+	results map[[sha256.Size]byte]passwordResult
+}
+
+type passwordResult struct {
+	entry     config.PasswordEntry
+	matched   bool
+	checkedAt time.Time
+}
+
+func newPasswordCache(ttl time.Duration) (*passwordCache, error) {
+	if ttl == 0 {
+		ttl = defaultPasswordCacheTTL
+	}
+
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return &passwordCache{
+		ttl:     ttl,
+		key:     key,
+		results: make(map[[sha256.Size]byte]passwordResult),
+	}, nil
+}
+
+// match returns the entry the password matches like MatchPassword, reusing the result of an earlier check of the
+// same password while it is fresh.
+func (c *passwordCache) match(password string, passwordConfig config.PasswordConfig) (config.PasswordEntry, bool) {
+	if c.ttl < 0 {
+		return MatchPassword(password, passwordConfig)
+	}
+
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(password))
+	var key [sha256.Size]byte
+	copy(key[:], mac.Sum(nil))
+
+	c.mu.Lock()
+	result, found := c.results[key]
+	c.mu.Unlock()
+
+	if found && time.Since(result.checkedAt) < c.ttl {
+		passwordCacheLookups.Inc("hit")
+		return result.entry, result.matched
+	}
+
+	passwordCacheLookups.Inc("miss")
+	entry, matched := MatchPassword(password, passwordConfig)
+
+	c.mu.Lock()
+	if len(c.results) >= passwordCacheSize {
+		c.prune()
+	}
+	c.results[key] = passwordResult{entry: entry, matched: matched, checkedAt: time.Now()}
+	c.mu.Unlock()
+
+	return entry, matched
+}
+
+func (c *passwordCache) clear() {
+	c.mu.Lock()
+	clear(c.results)
+	c.mu.Unlock()
+}
+
+// prune removes expired results, or every result when none has expired. It is called with mu held.
+func (c *passwordCache) prune() {
+	for key, result := range c.results {
+		if time.Since(result.checkedAt) >= c.ttl {
+			delete(c.results, key)
+		}
+	}
+
+	if len(c.results) >= passwordCacheSize {
+		clear(c.results)
+	}
+}
+
+// verification is the outcome of checking a registration against the password configuration.
+type verification struct {
+	verified      bool   // Whether a password or signature matched an entry, which may be nameless
+	passwordEntry string // Name of the entry that verified the server
+	rejection     string // Reason label of rejectedPackets when the registration is refused
+	reason        string // Why the registration is refused
+}
+
+// verifyRegistration checks a registration under the given mode, given the entry its password or signature matched. In
+// mixed mode a server without a valid password is accepted unverified, unless its name is reserved by a password entry.
+// A registration with an invalid signature is always refused, so that a captured one cannot be replayed unsigned.
+func (r *Registry) verifyRegistration(mode string, passwordCfg config.PasswordConfig, entry config.PasswordEntry, matched bool, signatureErr error, passID uint32, name string, host string) (verification, error) {
+	if signatureErr != nil {
+		return verification{rejection: rejectSignature, reason: "invalid signature: " + signatureErr.Error()}, nil
+	}
+
+	if !matched {
+		if mode != config.PasswordsMixed {
+			return verification{rejection: rejectPassword, reason: "invalid password"}, nil
+		}
+
+		for _, other := range passwordCfg.PasswordEntries {
+			if other.Reserves(name) {
+				return verification{rejection: rejectRestricted, reason: "name reserved for a password entry"}, nil
+			}
+		}
+
+		return verification{}, nil
+	}
+
+	if reason, err := r.checkPasswordEntry(passwordCfg, entry, passID, name, host); err != nil {
+		return verification{}, err
+	} else if reason != "" {
+		return verification{rejection: rejectRestricted, reason: reason}, nil
+	}
+
+	return verification{verified: true, passwordEntry: entry.Name}, nil
+}
+
+// verifySignature returns the entry a signed registration names, once its signature is checked with the entry's
+// signing secret.
+func (r *Registry) verifySignature(serverReg *server.ServerRegistration, passwordCfg config.PasswordConfig) (config.PasswordEntry, error) {
+	keyID := string(serverReg.Signature.KeyID)
+
+	index := passwordCfg.FindEntry(keyID)
+	if index < 0 || passwordCfg.PasswordEntries[index].SigningSecret == "" {
+		return config.PasswordEntry{}, fmt.Errorf("no signing secret for %s", keyID)
+	}
+
+	entry := passwordCfg.PasswordEntries[index]
+	if err := r.replayGuard.Verify(serverReg, []byte(entry.SigningSecret), time.Now()); err != nil {
+		return config.PasswordEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// checkPasswordEntry enforces the constraints of the password entry a server registered with. It returns why the
+// registration is refused, or an empty string when the entry allows it.
+func (r *Registry) checkPasswordEntry(passwordCfg config.PasswordConfig, entry config.PasswordEntry, passID uint32, name string, host string) (string, error) {
+	if entry.Expired(time.Now()) {
+		return "password entry expired", nil
+	}
+
+	if !entry.AllowsIP(host) {
+		return "address not allowed for password entry", nil
+	}
+
+	if !entry.AllowsName(name) {
+		return "name not allowed for password entry", nil
+	}
+
+	for _, other := range passwordCfg.PasswordEntries {
+		if other.Name != entry.Name && other.Reserves(name) {
+			return "name reserved for another password entry", nil
+		}
+	}
+
+	if entry.MaxServers > 0 {
+		count, err := r.registeredServerStore.CountPasswordEntryServers(entry.Name, passID)
+		if err != nil {
+			return "", err
+		}
+
+		if count >= int64(entry.MaxServers) {
+			return "password entry server limit reached", nil
 		}
 	}
 
-	return false
+	return "", nil
 }
@@ -0,0 +1,147 @@
+package registry
+
+import (
+	"errors"
+	"magnetron/internal/config"
+	"magnetron/internal/logging"
+	"os"
+	"sync"
+	"time"
+)
+
+const passwordReloadInterval = 5 * time.Second
+
+// passwordSet keeps the password file in memory and reloads it when it changes, so that entries added, removed or
+// rotated with the password command apply without a restart.
+type passwordSet struct {
+	path    string
+	cache   *passwordCache
+	mu      sync.RWMutex
+	config  config.PasswordConfig
+	modTime time.Time
+	size    int64
+}
+
+func newPasswordSet(path string, passwordCfg config.PasswordConfig, cache *passwordCache) *passwordSet {
+	set := &passwordSet{path: path, cache: cache, config: passwordCfg}
+
+	if info, err := os.Stat(path); err == nil {
+		set.modTime, set.size = info.ModTime(), info.Size()
+	}
+
+	return set
+}
+
+// current returns the password configuration in use. Entries are never changed in place, so the returned
+// configuration can be read while the file is reloaded.
+func (s *passwordSet) current() config.PasswordConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// signs reports whether the password entry with the given name has a signing secret.
+func (s *passwordSet) signs(name string) bool {
+	passwordCfg := s.current()
+	index := passwordCfg.FindEntry(name)
+	return index >= 0 && passwordCfg.PasswordEntries[index].SigningSecret != ""
+}
+
+// watch reloads the password file whenever its modification time or size changes. A file that fails to load leaves
+// the previous entries in place.
+func (s *passwordSet) watch() {
+	ticker := time.NewTicker(passwordReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.reload(); err != nil {
+			logger.Error("Could not reload passwords, keeping the previous ones", logging.Err(err))
+		}
+	}
+}
+
+func (s *passwordSet) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	unchanged := info.ModTime().Equal(s.modTime) && info.Size() == s.size
+	// A file that failed to load is only retried once it changes again.
+	s.modTime, s.size = info.ModTime(), info.Size()
+	s.mu.Unlock()
+
+	if unchanged {
+		return nil
+	}
+
+	passwordCfg, err := config.LoadPasswordConfigFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	if errs := passwordCfg.Errors(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	s.mu.Lock()
+	s.config = *passwordCfg
+	s.mu.Unlock()
+
+	s.cache.clear()
+
+	logger.Info("Loaded passwords", "entries", len(passwordCfg.PasswordEntries), "file", s.path)
+
+	return nil
+}
+
+// upgrade replaces a bcrypt hash a server just registered with by an argon2id hash of its password, in memory and in
+// the password file. A file that cannot be written only keeps the upgrade in memory.
+func (s *passwordSet) upgrade(entry config.PasswordEntry, password string) {
+	upgraded, err := HashPassword(password, Argon2id)
+	if err != nil {
+		logger.Warn("Could not upgrade password hash", "password_entry", entry.Name, logging.Err(err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, ok := replaceHash(s.config.PasswordEntries, entry.Password, upgraded)
+	if !ok {
+		// Another registration upgraded the entry first, or the file changed since.
+		return
+	}
+	s.config = config.PasswordConfig{PasswordEntries: entries}
+
+	// The file is read again, so entries changed since the last reload are not overwritten. The watcher then
+	// reloads the upgraded file.
+	if passwordCfg, err := config.LoadPasswordConfigFile(s.path); err != nil {
+		logger.Warn("Could not upgrade password hash in the password file", "password_entry", entry.Name, logging.Err(err))
+	} else if entries, ok := replaceHash(passwordCfg.PasswordEntries, entry.Password, upgraded); ok {
+		if err := config.SavePasswordConfig(config.PasswordConfig{PasswordEntries: entries}, s.path); err != nil {
+			logger.Warn("Could not upgrade password hash in the password file", "password_entry", entry.Name, logging.Err(err))
+		} else {
+			logger.Info("Upgraded password hash to argon2id", "password_entry", entry.Name)
+		}
+	}
+
+	s.cache.clear()
+}
+
+// replaceHash returns a copy of the entries with every password hash equal to hash replaced, and whether there was one.
+func replaceHash(entries []config.PasswordEntry, hash string, replacement string) ([]config.PasswordEntry, bool) {
+	replaced := make([]config.PasswordEntry, len(entries))
+	found := false
+
+	for i, entry := range entries {
+		if entry.Password == hash {
+			entry.Password = replacement
+			found = true
+		}
+		replaced[i] = entry
+	}
+
+	return replaced, found
+}
@@ -1,31 +1,66 @@
 package registry
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"fmt"
 	"gorm.io/gorm"
-	"log"
+	"magnetron/internal/certs"
 	"magnetron/internal/config"
 	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"magnetron/internal/listing"
+	"magnetron/internal/logging"
 	"magnetron/internal/proto/client"
 	"magnetron/internal/proto/server"
 	"net"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type Registry struct {
 	db                    *gorm.DB
 	cfg                   *config.Config
-	passwordConfig        *config.PasswordConfig
+	passwords             *passwordSet // Entries of the password file, nil when passwords are disabled and there is none
 	federatedTrackerStore *db.FederatedTrackerStore
 	federatedServerStore  *db.FederatedServerStore
 	staticServerStore     *db.StaticServerStore
 	registeredServerStore *db.RegisteredServerStore
+	candidateTrackerStore *db.CandidateTrackerStore
+	banStore              *db.BanStore
+	serverOverrideStore   *db.ServerOverrideStore
+	discovery             *discoveryState
+	health                *trackerHealth
+	relay                 *relay
+	listingBuilder        *listing.Builder
+	queue                 chan registrationPacket // Registrations read from the socket, waiting for a worker
+	passwordCache         *passwordCache
+	replayGuard           *server.ReplayGuard // Signed registrations already received
+	registerMu            sync.Mutex
 }
 
+// registrationPacket is a datagram read from the server socket, zero padded to the size the parser expects.
+type registrationPacket struct {
+	block []byte
+	addr  *net.UDPAddr
+}
+
+const (
+	defaultRegistrationWorkers   = 4
+	defaultRegistrationQueueSize = 1024
+	droppedLogInterval           = 10 * time.Second
+	defaultSignatureWindow       = 5 * time.Minute
+	tlsHandshakeTimeout          = 10 * time.Second
+)
+
 var (
 	RegistryInstance *Registry
+
+	logger           = logging.For(logging.Registry)
+	federationLogger = logging.For(logging.Federation)
 )
 
 func NewRegistry(cfg *config.Config, passwordConfig *config.PasswordConfig) error {
@@ -60,14 +95,73 @@ func NewRegistry(cfg *config.Config, passwordConfig *config.PasswordConfig) erro
 		return fmt.Errorf("error while initializing registered server store: %s", err)
 	}
 
+	candidateTrackerStore, err := db.NewCandidateTrackerStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing candidate tracker store: %s", err)
+	}
+
+	banStore, err := db.NewBanStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing ban store: %s", err)
+	}
+
+	serverOverrideStore, err := db.NewServerOverrideStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing server override store: %s", err)
+	}
+
+	serverRelay, err := newRelay(&cfg.Relay)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing registration relay: %s", err)
+	}
+
+	listingBuilder, err := listing.NewBuilder(database, cfg)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing listing builder: %s", err)
+	}
+
+	passwordCache, err := newPasswordCache(cfg.Registration.PasswordCacheTTL)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing password cache: %s", err)
+	}
+
+	signatureWindow := cfg.Verification.SignatureWindow
+	if signatureWindow == 0 {
+		signatureWindow = defaultSignatureWindow
+	}
+
+	queueSize := cfg.Registration.QueueSize
+	if queueSize == 0 {
+		queueSize = defaultRegistrationQueueSize
+	}
+
 	newReg := &Registry{
 		db:                    database,
 		cfg:                   cfg,
-		passwordConfig:        passwordConfig,
 		federatedTrackerStore: federatedTrackerStore,
 		federatedServerStore:  federatedServerStore,
 		staticServerStore:     staticServerStore,
 		registeredServerStore: registeredServerStore,
+		candidateTrackerStore: candidateTrackerStore,
+		banStore:              banStore,
+		serverOverrideStore:   serverOverrideStore,
+		discovery:             newDiscoveryState(),
+		health:                newTrackerHealth(),
+		relay:                 serverRelay,
+		listingBuilder:        listingBuilder,
+		queue:                 make(chan registrationPacket, queueSize),
+		passwordCache:         passwordCache,
+		replayGuard:           server.NewReplayGuard(signatureWindow),
+	}
+
+	if passwordConfig != nil {
+		newReg.passwords = newPasswordSet(cfg.PasswordFile, *passwordConfig, passwordCache)
 	}
 
 	for idx, entry := range cfg.TrackerFederation.TrackerEntries {
@@ -81,7 +175,11 @@ func NewRegistry(cfg *config.Config, passwordConfig *config.PasswordConfig) erro
 			return err
 		}
 
-		if _, err := newReg.federatedTrackerStore.RegisterFederatedTracker(trackerHost, trackerPort, entry.Name, entry.Description, entry.UserCount, uint16(idx)); err != nil {
+		if _, err := newReg.federatedTrackerStore.RegisterFederatedTracker(trackerHost, trackerPort, entry.Name, entry.Description, entry.UserCount, uint16(idx), db.TrackerTls{
+			Enabled:    entry.Tls,
+			ServerName: entry.TlsServerName,
+			CAFile:     entry.TlsCAFile,
+		}); err != nil {
 			return err
 		}
 	}
@@ -105,252 +203,380 @@ func NewRegistry(cfg *config.Config, passwordConfig *config.PasswordConfig) erro
 
 	}
 
+	for _, ban := range cfg.Moderation.Bans {
+		if _, err := newReg.banStore.AddBan(ban.PassID, ban.Address, ban.IP, ban.Reason); err != nil {
+			return err
+		}
+	}
+
+	for _, override := range cfg.Moderation.Overrides {
+		if _, err := newReg.serverOverrideStore.SetOverride(override.PassID, override.Name, override.Description, override.Pinned); err != nil {
+			return err
+		}
+	}
+
 	ticker := time.NewTicker(1 * time.Minute)
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				newReg.registeredServerStore.RemoveExpiredServers(cfg.ServerExpiration)
+				for _, server := range newReg.registeredServerStore.RemoveExpiredServers(cfg.ServerExpiration) {
+					logger.Info("Removed expired server", logging.ServerName(server.Name), logging.PassID(server.PassID))
+					events.Publish(events.Event{Type: events.ServerExpired, Origin: server.Origin, Server: server})
+				}
 			}
 		}
 	}()
 
+	newReg.registerGauges()
+	newReg.registerQueueGauges()
+
 	RegistryInstance = newReg
 	return nil
 
 }
 
-func (r *Registry) getAllStaticServers() ([]client.ServerMessage, error) {
-	var staticServers []db.StaticServer
-	staticServers, err := r.staticServerStore.GetStaticServers()
-
+func (r *Registry) serveClients() {
+	server, err := net.Listen("tcp", r.cfg.ClientHost)
 	if err != nil {
-		return nil, err
+		logger.Error("Could not start client listener", logging.Err(err))
+		os.Exit(1)
 	}
+	defer server.Close()
 
-	serverMessages := make([]client.ServerMessage, 0)
-
-	for _, server := range staticServers {
-		if serverMessage, err := client.BuildStaticServerMessage(server); err != nil {
-			return nil, err
-		} else {
-			serverMessages = append(serverMessages, *serverMessage)
-		}
-	}
+	logger.Info("Tracker is accepting client connections", "host", r.cfg.ClientHost)
 
-	return serverMessages, nil
+	r.acceptClients(server)
 }
 
-func (r *Registry) getAllRegisteredServers() ([]client.ServerMessage, error) {
-	var registeredServers []db.RegisteredServer
-	registeredServers, err := r.registeredServerStore.GetAllRegisteredServers()
+// serveClientsTls serves the same listing as serveClients to clients connecting over TLS.
+func (r *Registry) serveClientsTls() {
+	tlsCfg := r.cfg.ClientTls
 
-	if err != nil {
-		return nil, err
+	if err := certs.EnsureCertificate(tlsCfg.CertFile, tlsCfg.KeyFile, tlsCfg.Host, logger); err != nil {
+		logger.Error("Could not generate client TLS certificate", logging.Err(err))
+		os.Exit(1)
 	}
 
-	serverMessages := make([]client.ServerMessage, 0)
-
-	for _, server := range registeredServers {
-		if serverMessage, err := client.BuildRegisteredServerMessage(server); err != nil {
-			return nil, err
-		} else {
-			serverMessages = append(serverMessages, *serverMessage)
-		}
+	reloader, err := certs.NewReloader(tlsCfg.CertFile, tlsCfg.KeyFile, logger)
+	if err != nil {
+		logger.Error("Could not load client TLS certificate", logging.Err(err))
+		os.Exit(1)
 	}
+	go reloader.Watch()
 
-	return serverMessages, nil
-}
-
-func (r *Registry) getAllFederatedServers() ([]client.ServerMessage, error) {
-
-	serverMessages := make([]client.ServerMessage, 0)
-
-	var federatedTrackers []db.FederatedTracker
-	federatedTrackers, err := r.federatedTrackerStore.GetFederatedTrackers()
+	server, err := tls.Listen("tcp", tlsCfg.Host, certs.ServerConfig(reloader))
 	if err != nil {
-		return nil, err
+		logger.Error("Could not start client TLS listener", logging.Err(err))
+		os.Exit(1)
 	}
+	defer server.Close()
 
-	for _, tracker := range federatedTrackers {
+	logger.Info("Tracker is accepting client connections over TLS", "host", tlsCfg.Host)
 
-		if serverMessage, err := client.BuildFederatedTrackerMessage(tracker); err != nil {
-			return nil, err
-		} else {
-			serverMessages = append(serverMessages, *serverMessage)
-		}
+	r.acceptClients(server)
+}
 
-		federatedServers, err := r.federatedServerStore.GetFederatedServers(tracker.Host, tracker.Port)
+func (r *Registry) acceptClients(server net.Listener) {
+	for {
+		conn, err := server.Accept()
 		if err != nil {
-			return nil, err
+			logger.Error("Failed to accept client connection", logging.Err(err))
+			continue
 		}
 
-		for _, server := range federatedServers {
-			if serverMessage, err := client.BuildFederatedServerMessage(server); err != nil {
-				return nil, err
-			} else {
-				serverMessages = append(serverMessages, *serverMessage)
-			}
-		}
+		go r.serveClient(conn)
 	}
-
-	return serverMessages, nil
 }
 
-func (r *Registry) serveClients() {
-	server, err := net.Listen("tcp", r.cfg.ClientHost)
-	if err != nil {
-		log.Fatalln(err)
+// serveClient performs the tracker side of the HTRK exchange, sending the listing to a Hotline client.
+func (r *Registry) serveClient(conn net.Conn) {
+	clientConnections.Inc()
+	defer func() {
+		conn.Close()
+		clientConnections.Dec()
+	}()
+
+	// A client that never completes the handshake would otherwise hold the connection open.
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			logger.Debug("TLS handshake with client failed", logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(err))
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
 	}
-	defer server.Close()
 
-	log.Println("Tracker is accepting client connections on:", r.cfg.ClientHost)
+	_, errorMsg := client.ReceiveTrackerHeaderMsg(conn)
 
-	for {
-		conn, err := server.Accept()
+	if errorMsg != nil {
+		logger.Warn(errorMsg.ErrorMessage, logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(errorMsg.Error))
+	} else {
+		logger.Info("Serving Hotline client connection", logging.RemoteAddr(conn.RemoteAddr().String()))
+
+		startedAt := time.Now()
+
+		responseHeaderMsg := client.BuildHeaderMessage()
+		if msgError := client.SendTrackerHeaderMsg(responseHeaderMsg, conn); msgError != nil {
+			logger.Warn(msgError.ErrorMessage, logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(msgError.Error))
+		}
+
+		rows, err := r.listingBuilder.Build()
 		if err != nil {
-			log.Println("Failed to accept conn.", err)
-			continue
+			logger.Error("Could not build listing", logging.Err(err))
 		}
 
-		go func(conn net.Conn) {
-			defer func() {
-				conn.Close()
-			}()
-			_, errorMsg := client.ReceiveTrackerHeaderMsg(conn)
+		serverMessages := listing.Messages(rows)
 
-			if errorMsg != nil {
-				log.Println(errorMsg.ErrorMessage)
-			} else {
-				log.Printf("Serving hotline client connection from %s", conn.RemoteAddr().String())
+		update := client.BuildUpdateMessage(serverMessages)
 
-				responseHeaderMsg := client.BuildHeaderMessage()
-				if msgError := client.SendTrackerHeaderMsg(responseHeaderMsg, conn); msgError != nil {
-					log.Println(msgError)
-				}
+		if msgError := client.SendUpdateMessage(update, conn); msgError != nil {
+			logger.Warn(msgError.ErrorMessage, logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(msgError.Error))
+		}
 
-				var staticServerMessages []client.ServerMessage
+		for _, staticServerMsg := range serverMessages {
+			if msgError := client.SendServerRegistry(staticServerMsg, conn); msgError != nil {
+				logger.Warn(msgError.ErrorMessage, logging.RemoteAddr(conn.RemoteAddr().String()), logging.Err(msgError.Error))
+			}
+		}
 
-				if staticServerMessages, err = r.getAllStaticServers(); err != nil {
-					log.Println(err)
-				}
+		listingsServed.Inc()
+		listingDuration.Observe(time.Since(startedAt).Seconds())
 
-				var registeredServerMessages []client.ServerMessage
+		client.ReceiveTrackerHeaderMsg(conn)
+	}
+}
 
-				if registeredServerMessages, err = r.getAllRegisteredServers(); err != nil {
-					log.Println(err)
-				}
+// serveServers reads registrations from the UDP socket and queues them for the registration workers. Reading never
+// waits for a worker: when the queue is full the registration is dropped, so the socket keeps being drained during
+// bursts and its receive buffer does not overflow.
+func (r *Registry) serveServers() {
 
-				var federatedServerMessages []client.ServerMessage
+	logger.Info("Tracker is accepting server connections", "host", r.cfg.ServerHost)
+	hostAddr, err := net.ResolveUDPAddr("udp4", r.cfg.ServerHost)
+	if err != nil {
+		logger.Error("Could not resolve server listener address", logging.Err(err))
+		os.Exit(1)
+	}
 
-				if federatedServerMessages, err = r.getAllFederatedServers(); err != nil {
-					log.Println(err)
-				}
+	conn, err := net.ListenUDP("udp4", hostAddr)
+	if err != nil {
+		logger.Error("Could not start server listener", logging.Err(err))
+		os.Exit(1)
+	}
 
-				var serverMessages []client.ServerMessage
+	if readBuffer := r.cfg.Registration.ReadBuffer; readBuffer > 0 {
+		if err := conn.SetReadBuffer(readBuffer); err != nil {
+			logger.Warn("Could not set the UDP read buffer", "bytes", readBuffer, logging.Err(err))
+		}
+	}
 
-				fedServerHeaderName := []byte(r.cfg.TrackerFederation.Header)
+	workers := r.cfg.Registration.Workers
+	if workers == 0 {
+		workers = defaultRegistrationWorkers
+	}
 
-				federatedServerHeader := client.ServerMessage{
-					IPAddr:          [4]byte{0, 0, 0, 0},
-					Port:            [2]byte{0, 0},
-					NumUsers:        [2]byte{0, 0},
-					Unused:          [2]byte{0, 0},
-					NameSize:        byte(len(fedServerHeaderName)),
-					Name:            fedServerHeaderName,
-					DescriptionSize: 0,
-					Description:     nil,
-				}
+	for i := 0; i < workers; i++ {
+		go r.processRegistrations()
+	}
 
-				serverMessages = append(serverMessages, staticServerMessages...)
-				serverMessages = append(serverMessages, registeredServerMessages...)
-				serverMessages = append(serverMessages, federatedServerHeader)
-				serverMessages = append(serverMessages, federatedServerMessages...)
+	// Drops are logged at most once per interval, as a full queue drops many registrations in a row.
+	var dropped int
+	var droppedLoggedAt time.Time
 
-				update := client.BuildUpdateMessage(serverMessages)
+	for {
+		block := make([]byte, 2048)
+		_, addr, err := conn.ReadFromUDP(block)
+		if err != nil {
+			logger.Error("Could not read registration", logging.Err(err))
+			continue
+		}
 
-				if msgError := client.SendUpdateMessage(update, conn); msgError != nil {
-					log.Println(msgError)
-				}
+		receivedPackets.Inc()
 
-				for _, staticServerMsg := range serverMessages {
-					if msgError := client.SendServerRegistry(staticServerMsg, conn); msgError != nil {
-						log.Println(msgError)
-					}
-				}
+		select {
+		case r.queue <- registrationPacket{block: block, addr: addr}:
+		default:
+			dropped++
+			droppedPackets.Inc()
 
-				client.ReceiveTrackerHeaderMsg(conn)
+			if time.Since(droppedLoggedAt) >= droppedLogInterval {
+				logger.Warn("Registration queue is full, dropping registrations", "dropped", dropped, "queue_size", cap(r.queue))
+				droppedLoggedAt = time.Now()
+				dropped = 0
 			}
+		}
+	}
+}
 
-		}(conn)
+func (r *Registry) processRegistrations() {
+	for packet := range r.queue {
+		r.handleRegistration(packet.block, packet.addr)
 	}
 }
 
-func (r *Registry) serveServers() {
+func (r *Registry) handleRegistration(block []byte, addr *net.UDPAddr) {
+	serverReg, pError := server.ReadServerRegistration(block)
+	if pError != nil {
+		logger.Info("Rejected malformed registration", logging.RemoteAddr(addr.String()), "reason", pError.ErrorMessage, logging.Err(pError.Error))
+		rejectPacket(rejectMalformed)
+		return
+	}
 
-	log.Println("Tracker is accepting server connections on:", r.cfg.ServerHost)
-	hostAddr, err := net.ResolveUDPAddr("udp4", r.cfg.ServerHost)
+	passIdArray := make([]byte, 4)
+	passIdArray[0] = serverReg.PassId[0]
+	passIdArray[1] = serverReg.PassId[1]
+	passIdArray[2] = serverReg.PassId[2]
+	passIdArray[3] = serverReg.PassId[3]
+	passID := binary.BigEndian.Uint32(passIdArray)
 
-	conn, err := net.ListenUDP("udp4", hostAddr)
-	if err != nil {
-		log.Println(err)
-	}
+	host := addr.IP.String()
 
-	for true {
+	portArray := make([]byte, 2)
+	portArray[0] = serverReg.Port[0]
+	portArray[1] = serverReg.Port[1]
+	port := binary.BigEndian.Uint16(portArray)
 
-		block := make([]byte, 2048)
-		_, addr, _ := conn.ReadFromUDP(block)
+	serverName := string(serverReg.Name)
 
-		if serverReg, pError := server.ReadServerRegistration(block); pError != nil {
-			log.Println(pError)
-		} else {
+	description := string(serverReg.Description)
 
-			passIdArray := make([]byte, 4)
-			passIdArray[0] = serverReg.PassId[0]
-			passIdArray[1] = serverReg.PassId[1]
-			passIdArray[2] = serverReg.PassId[2]
-			passIdArray[3] = serverReg.PassId[3]
-			passID := binary.BigEndian.Uint32(passIdArray)
+	userCountArray := make([]byte, 2)
+	userCountArray[0] = serverReg.NumberOfUsers[0]
+	userCountArray[1] = serverReg.NumberOfUsers[1]
+	userCount := binary.BigEndian.Uint16(userCountArray)
 
-			host := addr.IP.String()
+	// Passwords and signatures are checked outside the lock below, as this is where workers spend most of their time.
+	// A signed registration is verified by its signature alone, its password is not checked.
+	mode := r.cfg.PasswordMode()
 
-			portArray := make([]byte, 2)
-			portArray[0] = serverReg.Port[0]
-			portArray[1] = serverReg.Port[1]
-			port := binary.BigEndian.Uint16(portArray)
+	// Servers only sign to be verified, so signatures are checked even while passwords are off, and the registration
+	// is then checked as in mixed mode. Signatures naming no entry with a signing secret cannot be checked, and are
+	// ignored while passwords are off.
+	if mode == config.PasswordsOff && serverReg.Signature != nil && r.passwords != nil && r.passwords.signs(string(serverReg.Signature.KeyID)) {
+		mode = config.PasswordsMixed
+	}
 
-			serverName := string(serverReg.Name)
+	var passwordCfg config.PasswordConfig
+	var entry config.PasswordEntry
+	var matched bool
+	var signatureErr error
+	if mode != config.PasswordsOff {
+		passwordCfg = r.passwords.current()
 
-			description := string(serverReg.Description)
+		if serverReg.Signature != nil {
+			entry, signatureErr = r.verifySignature(serverReg, passwordCfg)
+			matched = signatureErr == nil
+		} else {
+			entry, matched = r.passwordCache.match(string(serverReg.Password), passwordCfg)
 
-			userCountArray := make([]byte, 2)
-			userCountArray[0] = serverReg.NumberOfUsers[0]
-			userCountArray[1] = serverReg.NumberOfUsers[1]
-			userCount := binary.BigEndian.Uint16(userCountArray)
+			// Dry-run only observes, so the password file is left as it is.
+			if matched && HashAlgorithm(entry.Password) == Bcrypt && mode != config.PasswordsDryRun {
+				r.passwords.upgrade(entry, string(serverReg.Password))
+			}
+		}
+	}
 
-			var validServer = true
-			if r.cfg.EnablePasswords == true {
+	// Quotas, bans and the registration are checked and stored by one worker at a time, so concurrent registrations
+	// cannot exceed a quota together or apply out of order.
+	r.registerMu.Lock()
 
-				passwdString := string(serverReg.Password)
+	var validServer = true
+	var verified bool
+	var passwordEntry string
+	if mode != config.PasswordsOff {
 
-				if !CheckPassword(passwdString, *r.passwordConfig) {
-					log.Printf("Rejected server %s / %s because of invalid password", serverName, addr.IP.String())
-					validServer = false
-				}
+		// Dry-run checks registrations as if passwords were required, but only logs the outcome.
+		enforced := mode
+		if mode == config.PasswordsDryRun {
+			enforced = config.PasswordsRequired
+		}
 
-			}
+		if result, err := r.verifyRegistration(enforced, passwordCfg, entry, matched, signatureErr, passID, serverName, host); err != nil {
+			logger.Error("Could not verify server", logging.PassID(passID), logging.Err(err))
+			validServer = false
+			rejectPacket(rejectInternal)
+		} else if result.rejection != "" && mode == config.PasswordsDryRun {
+			logger.Info("Server would be rejected if passwords were required", logging.ServerName(serverName), logging.PassID(passID), logging.RemoteAddr(addr.String()), "reason", result.reason)
+			dryRunRejections.Inc(result.rejection)
+		} else if result.rejection != "" {
+			logger.Info("Rejected server because of its password", logging.ServerName(serverName), logging.PassID(passID), logging.RemoteAddr(addr.String()), "reason", result.reason)
+			validServer = false
+			rejectPacket(result.rejection)
+			r.publishRejection(passID, host, port, serverName, description, userCount, result.reason)
+		} else {
+			verified = result.verified
+			passwordEntry = result.passwordEntry
+		}
 
-			if validServer {
-				err := r.registeredServerStore.RegisterNewServer(passID, host, port, serverName, description, userCount)
-				if err != nil {
-					log.Println(err)
-				}
-			}
+	}
 
-			//log.Printf("Registered server %s / %s", serverName, addr.IP.String())
+	// A relaying tracker may name the server it relays, which is only believed when the relay signed the
+	// registration with an entry trusted to relay. The relay's own address is what the entry's AllowedIPs checked.
+	relayed := false
+	if validServer && serverReg.Origin != nil {
+		if serverReg.Signature != nil && matched && entry.Relay {
+			host = serverReg.OriginHost()
+			relayed = true
+		} else {
+			logger.Debug("Ignoring origin of registration not signed by a relay", logging.ServerName(serverName), logging.PassID(passID), logging.RemoteAddr(addr.String()))
+		}
+	}
 
+	if validServer {
+		if ban, banned, err := r.banStore.FindBan(passID, host, port); err != nil {
+			logger.Error("Could not look up bans", logging.PassID(passID), logging.Err(err))
+		} else if banned {
+			logger.Info("Rejected server because it is banned", logging.ServerName(serverName), logging.PassID(passID), logging.RemoteAddr(addr.String()), "reason", ban.Reason)
+			validServer = false
+			rejectPacket(rejectBanned)
+			r.publishRejection(passID, host, port, serverName, description, userCount, "banned: "+ban.Reason)
+		}
+	}
+
+	if validServer {
+		registeredServer, isNew, changed, err := r.registeredServerStore.RegisterNewServer(passID, host, port, serverName, description, userCount, verified, passwordEntry)
+		if err != nil {
+			logger.Error("Could not register server", logging.ServerName(serverName), logging.PassID(passID), logging.Err(err))
+			rejectPacket(rejectInternal)
+		} else {
+			acceptedPackets.Inc()
+
+			if isNew {
+				logger.Info("Registered new server", logging.ServerName(serverName), logging.PassID(passID), logging.RemoteAddr(addr.String()), "port", port)
+				events.Publish(events.Event{Type: events.ServerRegistered, Server: registeredServer})
+			} else if changed {
+				logger.Debug("Updated server", logging.ServerName(serverName), logging.PassID(passID), logging.RemoteAddr(addr.String()), "users", userCount)
+				events.Publish(events.Event{Type: events.ServerUpdated, Server: registeredServer})
+			} else {
+				logger.Debug("Refreshed server", logging.ServerName(serverName), logging.PassID(passID), logging.RemoteAddr(addr.String()))
+				events.Publish(events.Event{Type: events.ServerRefreshed, Server: registeredServer})
+			}
 		}
 	}
+
+	r.registerMu.Unlock()
+
+	// Registrations relayed in are not relayed again, so that trackers relaying to each other do not bounce a server
+	// between them, nor keep it listed after it stops registering.
+	if validServer && !relayed {
+		r.relay.forward(serverReg, passwordEntry, host, port)
+	}
+}
+
+func (r *Registry) publishRejection(passID uint32, host string, port uint16, name string, description string, userCount uint16, reason string) {
+	events.Publish(events.Event{
+		Type: events.ServerRejected,
+		Server: db.RegisteredServer{
+			PassID:      passID,
+			Host:        host,
+			Port:        port,
+			Name:        name,
+			Description: description,
+			UserCount:   userCount,
+		},
+		Reason: reason,
+	})
 }
 
 func (r *Registry) handleFederatedTrackers() {
@@ -374,83 +600,165 @@ func (r *Registry) handleFederatedTrackers() {
 
 func (r *Registry) pollFederatedTrackers() {
 	if trackers, err := r.federatedTrackerStore.GetFederatedTrackers(); err != nil {
-		log.Println(err)
+		federationLogger.Error("Could not get federated trackers", logging.Err(err))
 	} else {
 		for _, tracker := range trackers {
-			go r.pollFederatedTracker(tracker.Host, tracker.Port)
+			go r.pollFederatedTracker(tracker)
 		}
 	}
 
 }
 
-func (r *Registry) pollFederatedTracker(trackerHost string, trackerPort uint16) {
-	conn, err := net.Dial("tcp", trackerHost+":"+strconv.Itoa(int(trackerPort)))
+func (r *Registry) pollFederatedTracker(tracker db.FederatedTracker) {
+	trackerHost, trackerPort := tracker.Host, tracker.Port
 
-	defer func(conn net.Conn) {
-		if conn != nil {
-			err := conn.Close()
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}(conn)
+	startedAt := time.Now()
+	var serverMessages []client.ServerMessage
+	tlsConfig, err := trackerTlsConfig(tracker)
+	if err == nil {
+		serverMessages, err = fetchTrackerListing(trackerHost, trackerPort, 0, tlsConfig)
+	}
+
+	label := trackerLabel(trackerHost, trackerPort)
+	federationPollTimes.Observe(time.Since(startedAt).Seconds(), label)
 
 	if err != nil {
-		log.Println(err)
-		return
+		federationLogger.Warn("Could not poll federated tracker", logging.Tracker(label), logging.Err(err))
+		federationPolls.Inc(label, "failure")
+	} else {
+		federationPolls.Inc(label, "success")
 	}
 
-	if msgError := client.SendTrackerHeaderMsg(client.BuildHeaderMessage(), conn); msgError != nil {
-		log.Println(msgError)
-		return
+	r.health.report(tracker, err)
+
+	if recordError := r.federatedTrackerStore.RecordPoll(trackerHost, trackerPort, err); recordError != nil {
+		federationLogger.Error("Could not record poll of federated tracker", logging.Tracker(label), logging.Err(recordError))
 	}
 
-	if _, errorMsg := client.ReceiveTrackerHeaderMsg(conn); errorMsg != nil {
-		log.Println(errorMsg)
+	if len(serverMessages) == 0 {
 		return
 	}
 
-	if updateMsg, errorMsg := client.ReceiveUpdateMessage(conn); errorMsg != nil {
-		log.Println(errorMsg)
-		return
-	} else {
-		log.Println("Received update message from federated tracker with ", binary.BigEndian.Uint16(updateMsg.SrvCount[:]), " servers")
-		for i := 0; i < int(binary.BigEndian.Uint16(updateMsg.SrvCount[:])); i++ {
-			if serverMsg, errorMsg := client.ReceiveServerRegistry(conn); errorMsg != nil {
-				log.Println(errorMsg)
-				return
-			} else {
+	federationLogger.Debug("Received listing from federated tracker", logging.Tracker(label), "servers", len(serverMessages))
 
-				serverIp := net.IPv4(serverMsg.IPAddr[0], serverMsg.IPAddr[1], serverMsg.IPAddr[2], serverMsg.IPAddr[3]).String()
+	for i, serverMsg := range serverMessages {
 
-				portArray := make([]byte, 2)
-				portArray[0] = serverMsg.Port[0]
-				portArray[1] = serverMsg.Port[1]
-				port := binary.BigEndian.Uint16(portArray)
+		serverIp := net.IPv4(serverMsg.IPAddr[0], serverMsg.IPAddr[1], serverMsg.IPAddr[2], serverMsg.IPAddr[3]).String()
 
-				userCountArray := make([]byte, 2)
-				userCountArray[0] = serverMsg.NumUsers[0]
-				userCountArray[1] = serverMsg.NumUsers[1]
-				userCount := binary.BigEndian.Uint16(userCountArray)
+		portArray := make([]byte, 2)
+		portArray[0] = serverMsg.Port[0]
+		portArray[1] = serverMsg.Port[1]
+		port := binary.BigEndian.Uint16(portArray)
 
-				_, err := r.federatedServerStore.GetFederatedServer(trackerHost, trackerPort, serverIp, port)
+		userCountArray := make([]byte, 2)
+		userCountArray[0] = serverMsg.NumUsers[0]
+		userCountArray[1] = serverMsg.NumUsers[1]
+		userCount := binary.BigEndian.Uint16(userCountArray)
 
-				if err == nil {
-					if updateError := r.federatedServerStore.UpdateFederatedServer(trackerHost, trackerPort, serverIp, port, string(serverMsg.Name), string(serverMsg.Description), userCount, uint16(i)); updateError != nil {
-						log.Println(updateError)
-					}
-				} else {
-					if _, errorMsg := r.federatedServerStore.RegisterFederatedServer(trackerHost, trackerPort, serverIp, port, string(serverMsg.Name), string(serverMsg.Description), userCount, uint16(i)); errorMsg != nil {
-						log.Println(errorMsg)
-					}
-				}
+		_, err := r.federatedServerStore.GetFederatedServer(trackerHost, trackerPort, serverIp, port)
+
+		if err == nil {
+			if updateError := r.federatedServerStore.UpdateFederatedServer(trackerHost, trackerPort, serverIp, port, string(serverMsg.Name), string(serverMsg.Description), userCount, uint16(i)); updateError != nil {
+				federationLogger.Error("Could not update federated server", logging.Tracker(label), logging.ServerName(string(serverMsg.Name)), logging.Err(updateError))
 			}
+		} else {
+			if _, errorMsg := r.federatedServerStore.RegisterFederatedServer(trackerHost, trackerPort, serverIp, port, string(serverMsg.Name), string(serverMsg.Description), userCount, uint16(i)); errorMsg != nil {
+				federationLogger.Error("Could not register federated server", logging.Tracker(label), logging.ServerName(string(serverMsg.Name)), logging.Err(errorMsg))
+			}
+		}
+	}
+
+	r.discoverTrackers(trackerHost, trackerPort, serverMessages, 1)
+}
+
+// trackerTlsConfig returns the TLS configuration a federated tracker is polled with, nil when it is polled in the clear.
+func trackerTlsConfig(tracker db.FederatedTracker) (*tls.Config, error) {
+	if !tracker.Tls.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: tracker.Tls.ServerName}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = tracker.Host
+	}
+
+	if tracker.Tls.CAFile != "" {
+		bundle, err := os.ReadFile(tracker.Tls.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tracker CA file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in tracker CA file %s", tracker.Tls.CAFile)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// fetchTrackerListing performs the client side of the HTRK exchange with a tracker and returns its listing, over TLS
+// when tlsConfig is not nil. When the exchange fails part way through, the servers received so far are returned along
+// with the error.
+func fetchTrackerListing(trackerHost string, trackerPort uint16, timeout time.Duration, tlsConfig *tls.Config) ([]client.ServerMessage, error) {
+	address := net.JoinHostPort(trackerHost, strconv.Itoa(int(trackerPort)))
+
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(conn net.Conn) {
+		if err := conn.Close(); err != nil {
+			federationLogger.Debug("Could not close tracker connection", logging.Tracker(address), logging.Err(err))
+		}
+	}(conn)
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if msgError := client.SendTrackerHeaderMsg(client.BuildHeaderMessage(), conn); msgError != nil {
+		return nil, fmt.Errorf("%s (%s): %s", msgError.ErrorMessage, address, msgError.Error)
+	}
+
+	if _, msgError := client.ReceiveTrackerHeaderMsg(conn); msgError != nil {
+		return nil, fmt.Errorf("%s (%s): %s", msgError.ErrorMessage, address, msgError.Error)
+	}
+
+	updateMsg, msgError := client.ReceiveUpdateMessage(conn)
+	if msgError != nil {
+		return nil, fmt.Errorf("%s (%s): %s", msgError.ErrorMessage, address, msgError.Error)
+	}
+
+	serverCount := int(binary.BigEndian.Uint16(updateMsg.SrvCount[:]))
+	serverMessages := make([]client.ServerMessage, 0, serverCount)
+
+	for i := 0; i < serverCount; i++ {
+		if serverMsg, msgError := client.ReceiveServerRegistry(conn); msgError != nil {
+			return serverMessages, fmt.Errorf("%s (%s): %s", msgError.ErrorMessage, address, msgError.Error)
+		} else {
+			serverMessages = append(serverMessages, *serverMsg)
 		}
 	}
+
+	return serverMessages, nil
 }
 
 func (r *Registry) Serve() {
+	if r.passwords != nil {
+		go r.passwords.watch()
+	}
 	go r.serveClients()
+	if r.cfg.ClientTls.Enabled {
+		go r.serveClientsTls()
+	}
 	go r.handleFederatedTrackers()
 	r.serveServers()
 }
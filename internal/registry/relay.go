@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"magnetron/internal/config"
+	"magnetron/internal/logging"
+	"magnetron/internal/proto/server"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type upstream struct {
+	entry config.UpstreamEntry
+	addr  *net.UDPAddr
+}
+
+type relayKey struct {
+	upstream string
+	passID   [4]byte
+}
+
+// relay forwards accepted registrations to upstream trackers, so servers that only know this tracker are also
+// listed on the wider network.
+type relay struct {
+	cfg       *config.RelayConfig
+	conn      *net.UDPConn
+	upstreams []upstream
+	mu        sync.Mutex
+	lastSent  map[relayKey]time.Time
+}
+
+func newRelay(cfg *config.RelayConfig) (*relay, error) {
+	r := &relay{
+		cfg:      cfg,
+		lastSent: make(map[relayKey]time.Time),
+	}
+
+	if !cfg.Enabled {
+		return r, nil
+	}
+
+	for _, entry := range cfg.Upstreams {
+		host, err := entry.GetHost()
+		if err != nil {
+			return nil, err
+		}
+
+		port, err := entry.GetPort()
+		if err != nil {
+			return nil, err
+		}
+
+		addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			return nil, err
+		}
+
+		r.upstreams = append(r.upstreams, upstream{entry: entry, addr: addr})
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.conn = conn
+
+	go r.pruneLastSent()
+
+	return r, nil
+}
+
+// forward re-encodes a registration for every upstream that accepts it, naming host as its origin. passwordEntry is
+// the name of the password entry the server authenticated with, or empty when passwords are disabled.
+func (r *relay) forward(serverReg *server.ServerRegistration, passwordEntry string, host string, port uint16) {
+	if !r.cfg.Enabled {
+		return
+	}
+
+	name := string(serverReg.Name)
+	address := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	for _, upstream := range r.upstreams {
+		if !upstream.accepts(passwordEntry, name, address) {
+			continue
+		}
+
+		if !r.claim(relayKey{upstream.entry.Address, serverReg.PassId}) {
+			continue
+		}
+
+		var password []byte
+		if upstream.entry.Password != "" {
+			password = []byte(upstream.entry.Password)
+		} else if upstream.entry.ForwardPassword {
+			password = serverReg.Password
+		}
+
+		relayed := server.BuildServerRegistration(serverReg.Port, serverReg.NumberOfUsers, serverReg.PassId, serverReg.Name, serverReg.Description, password)
+
+		// Only IPv4 addresses can be named; others are listed under the address of this tracker.
+		if err := relayed.SetOrigin(host); err != nil {
+			federationLogger.Debug("Relaying server without its origin", logging.ServerName(name), logging.Tracker(upstream.entry.Address), logging.Err(err))
+		}
+
+		msgBytes := relayed.GetMessageInBytes()
+		if upstream.entry.SigningSecret != "" {
+			var err error
+			if msgBytes, err = relayed.GetSignedMessageInBytes(upstream.entry.SigningKey, []byte(upstream.entry.SigningSecret), time.Now()); err != nil {
+				federationLogger.Warn("Could not sign relayed server", logging.ServerName(name), logging.Tracker(upstream.entry.Address), logging.Err(err))
+				continue
+			}
+		}
+
+		if _, err := r.conn.WriteToUDP(msgBytes, upstream.addr); err != nil {
+			federationLogger.Warn("Could not relay server", logging.ServerName(name), logging.Tracker(upstream.entry.Address), logging.Err(err))
+		}
+	}
+}
+
+// claim reports whether a server may be forwarded to an upstream now, and if so records the forward.
+func (r *relay) claim(key relayKey) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sentAt, ok := r.lastSent[key]; ok && time.Since(sentAt) < r.cfg.MinInterval {
+		return false
+	}
+
+	r.lastSent[key] = time.Now()
+	return true
+}
+
+func (r *relay) pruneLastSent() {
+	ticker := time.NewTicker(10 * time.Minute)
+
+	for range ticker.C {
+		r.mu.Lock()
+		for key, sentAt := range r.lastSent {
+			if time.Since(sentAt) > r.cfg.MinInterval {
+				delete(r.lastSent, key)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (u *upstream) accepts(passwordEntry string, name string, address string) bool {
+	if len(u.entry.PasswordEntries) > 0 && !slices.Contains(u.entry.PasswordEntries, passwordEntry) {
+		return false
+	}
+
+	if len(u.entry.Servers) == 0 {
+		return true
+	}
+
+	for _, pattern := range u.entry.Servers {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+
+		if matched, _ := path.Match(pattern, address); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,500 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"magnetron/internal/config"
+	"magnetron/internal/db"
+	"magnetron/internal/events"
+	"magnetron/internal/logging"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 10
+	defaultTimeout     = 10 * time.Second
+	defaultRetention   = 7 * 24 * time.Hour
+	minRetryDelay      = 10 * time.Second
+	maxRetryDelay      = 1 * time.Hour
+	deliveryBatchSize  = 20
+	pollInterval       = 1 * time.Second
+	cleanupInterval    = 1 * time.Hour
+	maxErrorBodyLength = 512
+)
+
+const (
+	EventHeader     = "X-Magnetron-Event"
+	DeliveryHeader  = "X-Magnetron-Delivery"
+	TimestampHeader = "X-Magnetron-Timestamp"
+	SignatureHeader = "X-Magnetron-Signature" // "sha256=" and the hex HMAC of the timestamp, a dot and the body
+)
+
+// Refreshes happen every few minutes per server without changing anything, so hooks only receive them on request.
+var defaultEventTypes = []events.EventType{
+	events.ServerRegistered, events.ServerUpdated, events.ServerExpired, events.ServerRejected, events.TrackerUp, events.TrackerDown,
+}
+
+type WebhookService struct {
+	db            *gorm.DB
+	cfg           *config.Config
+	hooks         []hook
+	deliveryStore *db.WebhookDeliveryStore
+	subscription  *events.Subscription
+	httpClient    *http.Client
+	workers       map[string]*deliveryWorker // Delivery worker of each webhook, by name
+}
+
+type hook struct {
+	config.WebhookEntry
+	eventTypes []events.EventType
+	template   *template.Template
+}
+
+// deliveryWorker delivers the queued events of one webhook, so that a slow or failing endpoint does not hold up
+// the others.
+type deliveryWorker struct {
+	hook     string
+	wake     chan struct{} // Signalled when deliveries are queued
+	retry    chan struct{} // Signalled when a delivery is retried by hand, ending the backoff
+	failures int           // Attempts that failed in a row
+	resumeAt time.Time     // Nothing is attempted before, while the webhook is backed off
+}
+
+var (
+	WebhookServiceInstance *WebhookService
+
+	logger = logging.For(logging.API)
+)
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON, so that names and descriptions are safely quoted inside templates.
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+func NewWebhookService(cfg *config.Config) error {
+	var database *gorm.DB
+	var err error
+
+	// Without a queue file, or while webhooks are disabled, deliveries only live in memory.
+	if cfg.Webhooks.Enabled && cfg.Webhooks.QueueFile != "" {
+		database, err = db.GetFileDB(cfg.Webhooks.QueueFile)
+	} else {
+		database, err = db.GetDB()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while opening webhook queue: %s", err)
+	}
+
+	deliveryStore, err := db.NewWebhookDeliveryStore(database)
+
+	if err != nil {
+		return fmt.Errorf("error while initializing webhook delivery store: %s", err)
+	}
+
+	var hooks []hook
+
+	for _, entry := range cfg.Webhooks.Hooks {
+		h, err := newHook(entry)
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, h)
+	}
+
+	service := &WebhookService{
+		db:            database,
+		cfg:           cfg,
+		hooks:         hooks,
+		deliveryStore: deliveryStore,
+		httpClient:    &http.Client{Timeout: durationOrDefault(cfg.Webhooks.Timeout, defaultTimeout)},
+		workers:       make(map[string]*deliveryWorker),
+	}
+
+	for _, h := range hooks {
+		service.workers[h.Name] = &deliveryWorker{hook: h.Name, wake: make(chan struct{}, 1), retry: make(chan struct{}, 1)}
+	}
+
+	// Subscribe right away so that events published while the other services start are not missed.
+	if cfg.Webhooks.Enabled {
+		service.subscription = events.Subscribe(1024)
+	}
+
+	WebhookServiceInstance = service
+	return nil
+}
+
+func newHook(entry config.WebhookEntry) (hook, error) {
+	h := hook{WebhookEntry: entry, eventTypes: defaultEventTypes}
+
+	if len(entry.Events) > 0 {
+		h.eventTypes = nil
+
+		for _, eventName := range entry.Events {
+			eventType := events.EventType(eventName)
+			if !slices.Contains(events.AllTypes, eventType) {
+				return h, fmt.Errorf("webhook %s has an unknown event type: %s", entry.Name, eventName)
+			}
+			h.eventTypes = append(h.eventTypes, eventType)
+		}
+	}
+
+	if entry.Template != "" {
+		parsed, err := template.New(entry.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(entry.Template)
+		if err != nil {
+			return h, fmt.Errorf("webhook %s has an invalid template: %s", entry.Name, err)
+		}
+		h.template = parsed
+	}
+
+	return h, nil
+}
+
+func durationOrDefault(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func (s *WebhookService) Serve() {
+
+	if !s.cfg.Webhooks.Enabled {
+		return
+	}
+
+	logger.Info("Delivering events to webhooks", "webhooks", len(s.hooks))
+
+	for _, worker := range s.workers {
+		go s.deliverQueued(worker)
+	}
+
+	go s.cleanUp()
+
+	lastEventID := s.subscription.Start
+
+	for event := range s.subscription.C {
+		// Every subscriber sees every event, so a gap in the IDs means the bus dropped some while queueing fell
+		// behind. Their deliveries are lost, as the events are not kept.
+		if event.ID > lastEventID+1 {
+			missed := event.ID - lastEventID - 1
+			logger.Warn("Webhooks missed events", "missed", missed, "first_missed_event_id", lastEventID+1)
+			events.MissedEvents.Add(float64(missed), "webhooks")
+		}
+
+		lastEventID = event.ID
+		s.enqueue(event)
+	}
+}
+
+// enqueue renders the event for every hook it matches and queues the deliveries.
+func (s *WebhookService) enqueue(event events.Event) {
+	for _, h := range s.hooks {
+		if !h.matches(event) {
+			continue
+		}
+
+		payload, err := h.render(event)
+		if err != nil {
+			logger.Error("Could not render event for webhook", "webhook", h.Name, "event_type", event.Type, logging.Err(err))
+			continue
+		}
+
+		if _, err := s.deliveryStore.Enqueue(h.Name, event.ID, string(event.Type), payload); err != nil {
+			logger.Error("Could not queue delivery", "webhook", h.Name, logging.Err(err))
+			continue
+		}
+
+		signal(s.workers[h.Name].wake)
+	}
+}
+
+func (h *hook) matches(event events.Event) bool {
+	if !slices.Contains(h.eventTypes, event.Type) {
+		return false
+	}
+
+	if !event.IsTrackerEvent() && event.Server.UserCount < h.MinUsers {
+		return false
+	}
+
+	return true
+}
+
+func (h *hook) render(event events.Event) (string, error) {
+	document := event.Document()
+
+	if h.template == nil {
+		payload, err := json.Marshal(document)
+		return string(payload), err
+	}
+
+	var payload bytes.Buffer
+	if err := h.template.Execute(&payload, document); err != nil {
+		return "", err
+	}
+
+	if !json.Valid(payload.Bytes()) {
+		return "", fmt.Errorf("template did not produce valid JSON: %s", payload.String())
+	}
+
+	return payload.String(), nil
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// deliverQueued works through the queue of a webhook until the process exits. Its deliveries are attempted one at
+// a time, in the order they became due, so the webhook receives events in the order they happened unless an
+// attempt fails. Every failed attempt backs the webhook off, from minRetryDelay up to maxRetryDelay, so that an
+// endpoint that is down is not sent each queued delivery in turn.
+func (s *WebhookService) deliverQueued(worker *deliveryWorker) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if wait := time.Until(worker.resumeAt); wait > 0 {
+			select {
+			case <-worker.retry:
+				worker.failures = 0
+				worker.resumeAt = time.Time{}
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		deliveries, err := s.deliveryStore.GetDueDeliveries(worker.hook, deliveryBatchSize)
+		if err != nil {
+			logger.Error("Could not get due deliveries", "webhook", worker.hook, logging.Err(err))
+		}
+
+		for _, delivery := range deliveries {
+			if s.attempt(&delivery) {
+				worker.failures = 0
+				continue
+			}
+
+			worker.failures++
+			worker.resumeAt = time.Now().Add(retryDelay(worker.failures))
+			break
+		}
+
+		// A full batch means more deliveries may be due right away.
+		if len(deliveries) == deliveryBatchSize && worker.resumeAt.IsZero() {
+			continue
+		}
+
+		select {
+		case <-worker.wake:
+		case <-worker.retry:
+			worker.failures = 0
+			worker.resumeAt = time.Time{}
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanUp removes finished deliveries once they are past the retention period, and gives up on the deliveries of
+// webhooks that are no longer configured.
+func (s *WebhookService) cleanUp() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		retention := durationOrDefault(s.cfg.Webhooks.Retention, defaultRetention)
+		if err := s.deliveryStore.RemoveFinishedDeliveries(time.Now().Add(-retention)); err != nil {
+			logger.Error("Could not remove finished deliveries", logging.Err(err))
+		}
+
+		hooks := make([]string, 0, len(s.hooks))
+		for _, h := range s.hooks {
+			hooks = append(hooks, h.Name)
+		}
+
+		if failed, err := s.deliveryStore.FailOrphanedDeliveries(hooks, "webhook is no longer configured"); err != nil {
+			logger.Error("Could not fail deliveries of removed webhooks", logging.Err(err))
+		} else if failed > 0 {
+			logger.Warn("Gave up delivering events to removed webhooks", "deliveries", failed)
+		}
+	}
+}
+
+// attempt sends a delivery and records the outcome. It reports whether the delivery was accepted.
+func (s *WebhookService) attempt(delivery *db.WebhookDelivery) bool {
+	delivery.Attempts++
+	delivery.LastAttempt = time.Now()
+
+	statusCode, err := s.send(delivery)
+	delivery.LastStatusCode = statusCode
+
+	if err == nil {
+		delivery.Status = db.DeliveryDelivered
+		delivery.DeliveredAt = time.Now()
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = err.Error()
+
+		if delivery.Attempts >= s.maxAttempts() {
+			delivery.Status = db.DeliveryFailed
+			logger.Warn("Gave up delivering event to webhook", "webhook", delivery.Hook, "event_type", delivery.EventType, "attempts", delivery.Attempts, logging.Err(err))
+		} else {
+			delivery.NextAttempt = time.Now().Add(retryDelay(delivery.Attempts))
+		}
+	}
+
+	if err := s.deliveryStore.SaveDelivery(delivery); err != nil {
+		logger.Error("Could not save delivery", "webhook", delivery.Hook, logging.Err(err))
+	}
+
+	return delivery.Status == db.DeliveryDelivered
+}
+
+func (s *WebhookService) maxAttempts() int {
+	if s.cfg.Webhooks.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return s.cfg.Webhooks.MaxAttempts
+}
+
+// retryDelay doubles the wait after each failed attempt, from minRetryDelay up to maxRetryDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func (s *WebhookService) findHook(name string) (hook, bool) {
+	for _, h := range s.hooks {
+		if h.Name == name {
+			return h, true
+		}
+	}
+	return hook{}, false
+}
+
+// send posts a delivery to its webhook and returns the response status, or an error unless it was a 2xx.
+func (s *WebhookService) send(delivery *db.WebhookDelivery) (int, error) {
+	h, ok := s.findHook(delivery.Hook)
+	if !ok {
+		return 0, fmt.Errorf("webhook is no longer configured")
+	}
+
+	request, err := http.NewRequest(http.MethodPost, h.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Magnetron")
+	request.Header.Set(EventHeader, delivery.EventType)
+	request.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set(TimestampHeader, timestamp)
+
+	if h.Secret != "" {
+		request.Header.Set(SignatureHeader, Sign(h.Secret, timestamp, []byte(delivery.Payload)))
+	}
+
+	for name, value := range h.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodyLength))
+		return response.StatusCode, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+
+	return response.StatusCode, nil
+}
+
+// Sign computes the signature header value that receivers compare against to authenticate a delivery.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookService) Enabled() bool {
+	return s.cfg.Webhooks.Enabled
+}
+
+// Hooks returns the configured webhooks.
+func (s *WebhookService) Hooks() []config.WebhookEntry {
+	entries := make([]config.WebhookEntry, 0, len(s.hooks))
+	for _, h := range s.hooks {
+		entries = append(entries, h.WebhookEntry)
+	}
+	return entries
+}
+
+// EventTypes returns the event types a webhook receives, including the defaults for hooks that list none.
+func (s *WebhookService) EventTypes(name string) []events.EventType {
+	h, _ := s.findHook(name)
+	return h.eventTypes
+}
+
+func (s *WebhookService) DeliveryCounts(name string) (map[db.DeliveryStatus]int64, error) {
+	return s.deliveryStore.CountByStatus(name)
+}
+
+// Deliveries returns a page of the deliveries matching the query, along with how many match in total.
+func (s *WebhookService) Deliveries(query db.DeliveryQuery) ([]db.WebhookDelivery, int64, error) {
+	return s.deliveryStore.GetDeliveries(query)
+}
+
+// Retry queues a delivery for an immediate attempt, ending the backoff of its webhook. Failed deliveries get a
+// fresh set of attempts.
+func (s *WebhookService) Retry(id uint) (db.WebhookDelivery, error) {
+	delivery, err := s.deliveryStore.GetDelivery(id)
+	if err != nil {
+		return delivery, err
+	}
+
+	worker, ok := s.workers[delivery.Hook]
+	if !ok {
+		return delivery, fmt.Errorf("webhook %s is no longer configured", delivery.Hook)
+	}
+
+	if delivery.Status == db.DeliveryFailed {
+		delivery.Attempts = 0
+	}
+
+	delivery.Status = db.DeliveryPending
+	delivery.NextAttempt = time.Now()
+
+	if err := s.deliveryStore.SaveDelivery(&delivery); err != nil {
+		return delivery, err
+	}
+
+	signal(worker.retry)
+	return delivery, nil
+}
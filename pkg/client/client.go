@@ -0,0 +1,532 @@
+// Package client is a Go client for the Magnetron REST API.
+//
+//	c := client.New("http://localhost:8080", client.WithToken(os.Getenv("MAGNETRON_TOKEN")))
+//	servers, err := c.RegisteredServers(ctx, client.ListOptions{Sort: "userCount", Desc: true})
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Client struct {
+	baseURL      string
+	token        string
+	tlsConfig    *tls.Config
+	httpClient   *http.Client // Client of every request but the event stream
+	streamClient *http.Client // Same as httpClient without its timeout, which would end the event stream
+}
+
+type Option func(*Client)
+
+// WithToken authenticates every request with the given bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient replaces the default HTTP client, e.g. to configure proxies or timeouts. The client is not
+// modified; its timeout does not apply to the event stream.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTLSConfig uses the given TLS configuration, e.g. from TLSConfig, for every request. It applies to a copy of the
+// transport of the HTTP client, whichever order the options are given in, and is ignored for transports that are
+// not an *http.Transport.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// TLSConfig returns a TLS configuration presenting the client certificate in certFile and keyFile, and verifying the
+// REST API's certificate with the PEM bundle in caFile. Empty paths leave out the client certificate and use the
+// system roots.
+func TLSConfig(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		bundle, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// New returns a client for the REST API at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	// The HTTP client is copied rather than changed, as it may be shared, e.g. http.DefaultClient.
+	var httpClient http.Client
+	if c.httpClient != nil {
+		httpClient = *c.httpClient
+	} else {
+		httpClient.Timeout = defaultTimeout
+	}
+
+	if c.tlsConfig != nil {
+		transport, ok := httpClient.Transport.(*http.Transport)
+		if httpClient.Transport == nil {
+			transport, ok = http.DefaultTransport.(*http.Transport)
+		}
+
+		if ok {
+			transport = transport.Clone()
+			transport.TLSClientConfig = c.tlsConfig
+			httpClient.Transport = transport
+		}
+	}
+
+	streamClient := httpClient
+	streamClient.Timeout = 0
+
+	c.httpClient = &httpClient
+	c.streamClient = &streamClient
+
+	return c
+}
+
+// APIError is returned when the REST API answers with an unsuccessful status.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string // Body of the response, which the API fills with a plain text reason
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s failed: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+func hasStatus(err error, statusCode int) bool {
+	var apiError *APIError
+	return errors.As(err, &apiError) && apiError.StatusCode == statusCode
+}
+
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsForbidden reports whether the token was valid but is not granted the scope the operation requires.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+func IsBadRequest(err error) bool {
+	return hasStatus(err, http.StatusBadRequest)
+}
+
+// ListOptions holds the filtering, sorting and pagination parameters of the list endpoints. Zero values are omitted.
+type ListOptions struct {
+	Search   string // Case-insensitive substring of the name or description
+	MinUsers uint16 // Lowest user count to include
+	Tracker  string // host or host:port of the federated tracker the item belongs to
+	Sort     string // Field to sort by, empty to keep the listing order
+	Desc     bool
+	Limit    int
+	Offset   int
+}
+
+func (o ListOptions) values() url.Values {
+	values := url.Values{}
+
+	if o.Search != "" {
+		values.Set("q", o.Search)
+	}
+	if o.MinUsers != 0 {
+		values.Set("minUsers", strconv.Itoa(int(o.MinUsers)))
+	}
+	if o.Tracker != "" {
+		values.Set("tracker", o.Tracker)
+	}
+	if o.Sort != "" {
+		values.Set("sort", o.Sort)
+	}
+	if o.Desc {
+		values.Set("order", "desc")
+	}
+	if o.Limit != 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset != 0 {
+		values.Set("offset", strconv.Itoa(o.Offset))
+	}
+
+	return values
+}
+
+func persistValues(persist bool) url.Values {
+	values := url.Values{}
+	if persist {
+		values.Set("persist", "true")
+	}
+	return values
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, requestBody any, responseBody any) error {
+	response, err := c.send(ctx, c.httpClient, method, path, query, requestBody)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if responseBody == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(responseBody)
+}
+
+func (c *Client) send(ctx context.Context, httpClient *http.Client, method string, path string, query url.Values, requestBody any) (*http.Response, error) {
+	var body io.Reader
+
+	if requestBody != nil {
+		requestJson, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(requestJson)
+	}
+
+	requestURL := c.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if requestBody != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		message, _ := io.ReadAll(response.Body)
+		return nil, &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			Message:    strings.TrimSpace(string(message)),
+		}
+	}
+
+	return response, nil
+}
+
+func idPath(prefix string, id uint) string {
+	return prefix + strconv.FormatUint(uint64(id), 10)
+}
+
+func passIDPath(passID uint32) string {
+	return "/api/v1/servers/registered/" + strconv.FormatUint(uint64(passID), 10)
+}
+
+// OpenAPI returns the OpenAPI document describing the REST API, in YAML.
+func (c *Client) OpenAPI(ctx context.Context) ([]byte, error) {
+	response, err := c.send(ctx, c.httpClient, "GET", "/api/v1/openapi.yaml", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return io.ReadAll(response.Body)
+}
+
+// Metrics returns the tracker's metrics in the Prometheus text format.
+func (c *Client) Metrics(ctx context.Context) ([]byte, error) {
+	response, err := c.send(ctx, c.httpClient, "GET", "/metrics", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return io.ReadAll(response.Body)
+}
+
+// Session describes the token or client certificate the client is authenticated with and the scopes it is granted.
+func (c *Client) Session(ctx context.Context) (Session, error) {
+	var session Session
+	err := c.do(ctx, "GET", "/api/v1/session", nil, nil, &session)
+	return session, err
+}
+
+// Tokens lists the entries of the tracker's token file, without their tokens.
+func (c *Client) Tokens(ctx context.Context) (Tokens, error) {
+	var tokens Tokens
+	err := c.do(ctx, "GET", "/api/v1/tokens/", nil, nil, &tokens)
+	return tokens, err
+}
+
+// Listing returns the rows a Hotline client receives from the tracker.
+func (c *Client) Listing(ctx context.Context) (Listing, error) {
+	var listing Listing
+	err := c.do(ctx, "GET", "/api/v1/listing", nil, nil, &listing)
+	return listing, err
+}
+
+// RawListing returns the bytes the tracker writes to a Hotline client.
+func (c *Client) RawListing(ctx context.Context) ([]byte, error) {
+	response, err := c.send(ctx, c.httpClient, "GET", "/api/v1/listing", url.Values{"format": {"raw"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return io.ReadAll(response.Body)
+}
+
+func (c *Client) StaticServers(ctx context.Context, options ListOptions) (StaticServers, error) {
+	var servers StaticServers
+	err := c.do(ctx, "GET", "/api/v1/servers/static/", options.values(), nil, &servers)
+	return servers, err
+}
+
+func (c *Client) CreateStaticServer(ctx context.Context, server StaticServer, persist bool) (StaticServer, error) {
+	var created StaticServer
+	err := c.do(ctx, "POST", "/api/v1/servers/static/", persistValues(persist), server, &created)
+	return created, err
+}
+
+func (c *Client) UpdateStaticServer(ctx context.Context, id uint, server StaticServer, persist bool) (StaticServer, error) {
+	var updated StaticServer
+	err := c.do(ctx, "PUT", idPath("/api/v1/servers/static/", id), persistValues(persist), server, &updated)
+	return updated, err
+}
+
+func (c *Client) DeleteStaticServer(ctx context.Context, id uint, persist bool) error {
+	return c.do(ctx, "DELETE", idPath("/api/v1/servers/static/", id), persistValues(persist), nil, nil)
+}
+
+// ReorderStaticServers sets the listing order; ids must contain every static entry exactly once.
+func (c *Client) ReorderStaticServers(ctx context.Context, ids []uint, persist bool) (StaticServers, error) {
+	var servers StaticServers
+	err := c.do(ctx, "PUT", "/api/v1/servers/static/order", persistValues(persist), reorderRequest{IDs: ids}, &servers)
+	return servers, err
+}
+
+func (c *Client) RegisteredServers(ctx context.Context, options ListOptions) (RegisteredServers, error) {
+	var servers RegisteredServers
+	err := c.do(ctx, "GET", "/api/v1/servers/registered/", options.values(), nil, &servers)
+	return servers, err
+}
+
+// ExpireRegisteredServer delists a registered server until it registers again.
+func (c *Client) ExpireRegisteredServer(ctx context.Context, passID uint32) error {
+	return c.do(ctx, "DELETE", passIDPath(passID), nil, nil, nil)
+}
+
+func (c *Client) SetOverride(ctx context.Context, passID uint32, override Override, persist bool) (Override, error) {
+	var updated Override
+	err := c.do(ctx, "PUT", passIDPath(passID)+"/override", persistValues(persist), override, &updated)
+	return updated, err
+}
+
+// PatchOverride changes some fields of the override of a registered server, keeping the others.
+func (c *Client) PatchOverride(ctx context.Context, passID uint32, patch OverridePatch, persist bool) (Override, error) {
+	var updated Override
+	err := c.do(ctx, "PATCH", passIDPath(passID)+"/override", persistValues(persist), patch, &updated)
+	return updated, err
+}
+
+func (c *Client) RemoveOverride(ctx context.Context, passID uint32, persist bool) error {
+	return c.do(ctx, "DELETE", passIDPath(passID)+"/override", persistValues(persist), nil, nil)
+}
+
+func (c *Client) Overrides(ctx context.Context) (Overrides, error) {
+	var overrides Overrides
+	err := c.do(ctx, "GET", "/api/v1/overrides/", nil, nil, &overrides)
+	return overrides, err
+}
+
+func (c *Client) Bans(ctx context.Context) (Bans, error) {
+	var bans Bans
+	err := c.do(ctx, "GET", "/api/v1/bans/", nil, nil, &bans)
+	return bans, err
+}
+
+// CreateBan bans servers and delists the registered servers it matches.
+func (c *Client) CreateBan(ctx context.Context, ban Ban, persist bool) (Ban, error) {
+	var created Ban
+	err := c.do(ctx, "POST", "/api/v1/bans/", persistValues(persist), ban, &created)
+	return created, err
+}
+
+func (c *Client) RemoveBan(ctx context.Context, id uint, persist bool) error {
+	return c.do(ctx, "DELETE", idPath("/api/v1/bans/", id), persistValues(persist), nil, nil)
+}
+
+func (c *Client) FederatedServers(ctx context.Context, options ListOptions) (FederatedServers, error) {
+	var servers FederatedServers
+	err := c.do(ctx, "GET", "/api/v1/servers/federated/", options.values(), nil, &servers)
+	return servers, err
+}
+
+func (c *Client) FederatedTrackers(ctx context.Context, options ListOptions) (FederatedTrackers, error) {
+	var trackers FederatedTrackers
+	err := c.do(ctx, "GET", "/api/v1/trackers/federated/", options.values(), nil, &trackers)
+	return trackers, err
+}
+
+func (c *Client) CreateFederatedTracker(ctx context.Context, tracker FederatedTracker, persist bool) (FederatedTracker, error) {
+	var created FederatedTracker
+	err := c.do(ctx, "POST", "/api/v1/trackers/federated/", persistValues(persist), tracker, &created)
+	return created, err
+}
+
+func (c *Client) UpdateFederatedTracker(ctx context.Context, id uint, tracker FederatedTracker, persist bool) (FederatedTracker, error) {
+	var updated FederatedTracker
+	err := c.do(ctx, "PUT", idPath("/api/v1/trackers/federated/", id), persistValues(persist), tracker, &updated)
+	return updated, err
+}
+
+func (c *Client) DeleteFederatedTracker(ctx context.Context, id uint, persist bool) error {
+	return c.do(ctx, "DELETE", idPath("/api/v1/trackers/federated/", id), persistValues(persist), nil, nil)
+}
+
+// ReorderFederatedTrackers sets the listing order; ids must contain every federated tracker exactly once.
+func (c *Client) ReorderFederatedTrackers(ctx context.Context, ids []uint, persist bool) (FederatedTrackers, error) {
+	var trackers FederatedTrackers
+	err := c.do(ctx, "PUT", "/api/v1/trackers/federated/order", persistValues(persist), reorderRequest{IDs: ids}, &trackers)
+	return trackers, err
+}
+
+func (c *Client) Webhooks(ctx context.Context) (Webhooks, error) {
+	var webhooks Webhooks
+	err := c.do(ctx, "GET", "/api/v1/webhooks/", nil, nil, &webhooks)
+	return webhooks, err
+}
+
+// WebhookDeliveries lists deliveries, newest first. hook and status narrow the list down when not empty.
+func (c *Client) WebhookDeliveries(ctx context.Context, hook string, status string, options ListOptions) (WebhookDeliveries, error) {
+	query := options.values()
+	if hook != "" {
+		query.Set("hook", hook)
+	}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	var deliveries WebhookDeliveries
+	err := c.do(ctx, "GET", "/api/v1/webhooks/deliveries/", query, nil, &deliveries)
+	return deliveries, err
+}
+
+func (c *Client) RetryWebhookDelivery(ctx context.Context, id uint) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := c.do(ctx, "POST", idPath("/api/v1/webhooks/deliveries/", id)+"/retry", nil, nil, &delivery)
+	return delivery, err
+}
+
+// periodValues holds the period of a history request, leaving the server's default in place when zero.
+func periodValues(period time.Duration) url.Values {
+	values := url.Values{}
+	if period > 0 {
+		values.Set("period", period.String())
+	}
+	return values
+}
+
+// ServerHistory returns the history of a registered server over the last period, merged into points of the
+// given resolution when it is not zero.
+func (c *Client) ServerHistory(ctx context.Context, passID uint32, period time.Duration, resolution time.Duration) (ServerHistory, error) {
+	query := periodValues(period)
+	if resolution > 0 {
+		query.Set("resolution", resolution.String())
+	}
+
+	var serverHistory ServerHistory
+	err := c.do(ctx, "GET", "/api/v1/history/servers/"+strconv.FormatUint(uint64(passID), 10), query, nil, &serverHistory)
+	return serverHistory, err
+}
+
+func (c *Client) ServerUptimes(ctx context.Context, period time.Duration, options ListOptions) (ServerUptimes, error) {
+	query := options.values()
+	if period > 0 {
+		query.Set("period", period.String())
+	}
+
+	var uptimes ServerUptimes
+	err := c.do(ctx, "GET", "/api/v1/history/uptime/", query, nil, &uptimes)
+	return uptimes, err
+}
+
+func (c *Client) TrackerHistory(ctx context.Context, period time.Duration) (TrackerHistory, error) {
+	var trackerHistory TrackerHistory
+	err := c.do(ctx, "GET", "/api/v1/history/tracker", periodValues(period), nil, &trackerHistory)
+	return trackerHistory, err
+}
+
+func (c *Client) CandidateTrackers(ctx context.Context, options ListOptions) (CandidateTrackers, error) {
+	var trackers CandidateTrackers
+	err := c.do(ctx, "GET", "/api/v1/trackers/candidates/", options.values(), nil, &trackers)
+	return trackers, err
+}
+
+// PromoteCandidateTracker moves a tracker found by discovery into the federation.
+func (c *Client) PromoteCandidateTracker(ctx context.Context, promoteRequest PromoteCandidateRequest) (FederatedTracker, error) {
+	var tracker FederatedTracker
+	err := c.do(ctx, "POST", "/api/v1/trackers/candidates/promote", nil, promoteRequest, &tracker)
+	return tracker, err
+}
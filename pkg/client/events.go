@@ -0,0 +1,102 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ResyncEvent is the type of the event sent when the stream could not replay every missed event. Clients that
+// mirror the tracker's state should reload it when they receive one.
+const ResyncEvent = "resync"
+
+type EventOptions struct {
+	Types       []string // Event types to receive, empty for the server's default selection
+	LastEventID uint64   // ID of the last event received before reconnecting, zero to start from now
+}
+
+// EventStream reads Server-Sent Events from the tracker. Close it when done.
+type EventStream struct {
+	body        io.ReadCloser
+	reader      *bufio.Reader
+	lastEventID uint64
+}
+
+// Events opens the event stream. The client's timeout applies to the whole stream, so long-lived streams need a
+// client without one, e.g. New(url, WithHTTPClient(&http.Client{})).
+func (c *Client) Events(ctx context.Context, options EventOptions) (*EventStream, error) {
+	query := url.Values{}
+
+	if len(options.Types) > 0 {
+		query.Set("types", strings.Join(options.Types, ","))
+	}
+	if options.LastEventID != 0 {
+		query.Set("lastEventId", strconv.FormatUint(options.LastEventID, 10))
+	}
+
+	// The stream stays open for as long as ctx allows, so it is not subject to the timeout of other requests.
+	response, err := c.send(ctx, c.streamClient, http.MethodGet, "/api/v1/events", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventStream{body: response.Body, reader: bufio.NewReader(response.Body), lastEventID: options.LastEventID}, nil
+}
+
+// Next blocks until the next event arrives. A resync is returned as an Event with Type ResyncEvent.
+func (s *EventStream) Next() (Event, error) {
+	var eventType string
+	var data strings.Builder
+
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return Event{}, io.ErrUnexpectedEOF
+			}
+			return Event{}, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if eventType == "" && data.Len() == 0 {
+				continue
+			}
+
+			if eventType == ResyncEvent {
+				return Event{Type: ResyncEvent}, nil
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return Event{}, err
+			}
+
+			s.lastEventID = event.ID
+			return event, nil
+		case strings.HasPrefix(line, ":"):
+			// Keep-alive comment
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+// LastEventID returns the ID of the last event read, to pass in EventOptions when reconnecting.
+func (s *EventStream) LastEventID() uint64 {
+	return s.lastEventID
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,299 @@
+package client
+
+import "time"
+
+// The types below mirror the schemas of the OpenAPI document served at /api/v1/openapi.yaml.
+
+type Page struct {
+	Total  int `json:"total"`           // Number of items that matched the filters
+	Offset int `json:"offset"`          // Index of the first returned item amongst the matches
+	Limit  int `json:"limit,omitempty"` // Maximum number of items returned, zero when unlimited
+}
+
+type Listing struct {
+	Rows []ListingRow `json:"rows"`
+}
+
+type ListingRow struct {
+	Kind        string `json:"kind"` // static, registered, header, federated-tracker or federated-server
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Host        string `json:"host"`
+	Port        uint16 `json:"port"`
+	UserCount   uint16 `json:"userCount"`
+}
+
+// Event is a registry or federation event received from the event stream.
+type Event struct {
+	ID      uint64        `json:"id"`
+	Type    string        `json:"type"` // e.g. server.registered, server.updated, tracker.down
+	Time    time.Time     `json:"time"`
+	Origin  string        `json:"origin,omitempty"` // Peer tracker the change originated on, empty for local changes
+	Via     []string      `json:"via,omitempty"`
+	Server  *EventServer  `json:"server,omitempty"`
+	Tracker *EventTracker `json:"tracker,omitempty"`
+	Reason  string        `json:"reason,omitempty"` // Why a registration was rejected or a tracker is down
+}
+
+type EventServer struct {
+	PassID      uint32 `json:"passId"`
+	Name        string `json:"name"`
+	Host        string `json:"host"`
+	Port        uint16 `json:"port"`
+	Description string `json:"description"`
+	UserCount   uint16 `json:"userCount"`
+}
+
+type EventTracker struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+}
+
+type StaticServers struct {
+	Servers []StaticServer `json:"servers"`
+	Page
+}
+
+type StaticServer struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Host        string `json:"host"`
+	Port        uint16 `json:"port"`
+	Description string `json:"description"`
+	UserCount   uint16 `json:"userCount"`
+}
+
+type RegisteredServers struct {
+	Servers []RegisteredServer `json:"servers"`
+	Page
+}
+
+type RegisteredServer struct {
+	PassID        uint32    `json:"passId"`
+	Name          string    `json:"name"`
+	Host          string    `json:"host"`
+	Port          uint16    `json:"port"`
+	Description   string    `json:"description"`
+	UserCount     uint16    `json:"userCount"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+	Pinned        bool      `json:"pinned"`        // Listed before the other registered servers
+	Overridden    bool      `json:"overridden"`    // Name or description is replaced by an override
+	PasswordEntry string    `json:"passwordEntry"` // Password entry the server registered with, empty without passwords
+	Verified      bool      `json:"verified"`      // Registered with a valid password
+}
+
+type FederatedServers struct {
+	Servers []FederatedServer `json:"servers"`
+	Page
+}
+
+type FederatedServer struct {
+	Name        string    `json:"name"`
+	Host        string    `json:"host"`
+	Port        uint16    `json:"port"`
+	Description string    `json:"description"`
+	UserCount   uint16    `json:"userCount"`
+	TrackerHost string    `json:"trackerHost"`
+	TrackerPort uint16    `json:"trackerPort"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+type FederatedTrackers struct {
+	Trackers []FederatedTracker `json:"trackers"`
+	Page
+}
+
+type FederatedTracker struct {
+	ID            uint      `json:"id"`
+	Name          string    `json:"name"`
+	Host          string    `json:"host"`
+	Port          uint16    `json:"port"`
+	Description   string    `json:"description"`
+	UserCount     uint16    `json:"userCount"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+	Tls           bool      `json:"tls"`
+	TlsServerName string    `json:"tlsServerName,omitempty"`
+	TlsCAFile     string    `json:"tlsCAFile,omitempty"`
+	Up            bool      `json:"up"`
+	LastPolled    time.Time `json:"lastPolled"`
+	LastPollError string    `json:"lastPollError,omitempty"`
+}
+
+type CandidateTrackers struct {
+	Trackers []CandidateTracker `json:"trackers"`
+	Page
+}
+
+type CandidateTracker struct {
+	Host          string    `json:"host"`
+	Port          uint16    `json:"port"`
+	ListedName    string    `json:"listedName"`
+	ServerCount   uint16    `json:"serverCount"`
+	Depth         uint16    `json:"depth"`
+	DiscoveredVia string    `json:"discoveredVia"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+}
+
+type PromoteCandidateRequest struct {
+	Host        string `json:"host"`
+	Port        uint16 `json:"port"`
+	Name        string `json:"name"`        // Defaults to the name the candidate was listed under
+	Description string `json:"description"` // Optional description shown in the listing
+	Persist     bool   `json:"persist"`     // Write the promoted tracker back to the configuration file
+}
+
+type Bans struct {
+	Bans []Ban `json:"bans"`
+}
+
+// Ban matches servers by exactly one of PassID, Address (ip:port) or IP (address or CIDR range).
+type Ban struct {
+	ID        uint      `json:"id"`
+	PassID    uint32    `json:"passId,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Overrides struct {
+	Overrides []Override `json:"overrides"`
+}
+
+type Override struct {
+	PassID      uint32 `json:"passId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Pinned      bool   `json:"pinned"`
+}
+
+// OverridePatch changes the fields of an override that are not nil.
+type OverridePatch struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Pinned      *bool   `json:"pinned,omitempty"`
+}
+
+type Session struct {
+	TokenAuth          bool       `json:"tokenAuth"`
+	Name               string     `json:"name,omitempty"`
+	CertificateSubject string     `json:"certificateSubject,omitempty"`
+	Scopes             []string   `json:"scopes"`
+	Expiry             *time.Time `json:"expiry,omitempty"`
+}
+
+type Tokens struct {
+	Enabled bool    `json:"enabled"`
+	Tokens  []Token `json:"tokens"`
+}
+
+type Token struct {
+	Name                string     `json:"name"`
+	Description         string     `json:"description"`
+	Scopes              []string   `json:"scopes"`
+	CertificateSubjects []string   `json:"certificateSubjects,omitempty"`
+	CertificateOnly     bool       `json:"certificateOnly"`
+	CreatedAt           *time.Time `json:"createdAt,omitempty"`
+	Expiry              *time.Time `json:"expiry,omitempty"`
+	Expired             bool       `json:"expired"`
+}
+
+type Webhooks struct {
+	Enabled  bool      `json:"enabled"`
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type Webhook struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	MinUsers  uint16   `json:"minUsers"`
+	Signed    bool     `json:"signed"`
+	Pending   int64    `json:"pending"`
+	Delivered int64    `json:"delivered"`
+	Failed    int64    `json:"failed"`
+}
+
+type WebhookDeliveries struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Page
+}
+
+type WebhookDelivery struct {
+	ID             uint       `json:"id"`
+	Hook           string     `json:"hook"`
+	EventID        uint64     `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	Status         string     `json:"status"` // pending, delivered or failed
+	Attempts       int        `json:"attempts"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	NextAttempt    *time.Time `json:"nextAttempt,omitempty"`
+	LastAttempt    *time.Time `json:"lastAttempt,omitempty"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	Payload        string     `json:"payload"`
+}
+
+type ServerHistory struct {
+	PassID        uint32         `json:"passId"`
+	Name          string         `json:"name"`
+	Since         time.Time      `json:"since"`
+	UptimePercent float64        `json:"uptimePercent"`
+	Points        []HistoryPoint `json:"points"`
+	Transitions   []Transition   `json:"transitions"`
+}
+
+type HistoryPoint struct {
+	Time       time.Time `json:"time"`
+	Resolution string    `json:"resolution"`
+	MinUsers   uint16    `json:"minUsers"`
+	MaxUsers   uint16    `json:"maxUsers"`
+	AvgUsers   float64   `json:"avgUsers"`
+}
+
+type Transition struct {
+	Time   time.Time `json:"time"`
+	Online bool      `json:"online"`
+}
+
+type ServerUptimes struct {
+	Since   time.Time      `json:"since"`
+	Servers []ServerUptime `json:"servers"`
+	Page
+}
+
+type ServerUptime struct {
+	PassID          uint32  `json:"passId"`
+	Name            string  `json:"name"`
+	UptimePercent   float64 `json:"uptimePercent"`
+	OnlineSeconds   int64   `json:"onlineSeconds"`
+	ObservedSeconds int64   `json:"observedSeconds"`
+	AvgUsers        float64 `json:"avgUsers"`
+	MaxUsers        uint16  `json:"maxUsers"`
+}
+
+type TrackerHistory struct {
+	Since  time.Time       `json:"since"`
+	Peak   TrackerPeriod   `json:"peak"`
+	Daily  []TrackerPeriod `json:"daily"`
+	Weekly []TrackerPeriod `json:"weekly"`
+}
+
+type TrackerPeriod struct {
+	Start       time.Time  `json:"start"`
+	MaxUsers    uint64     `json:"maxUsers"`
+	AvgUsers    float64    `json:"avgUsers"`
+	MaxServers  uint32     `json:"maxServers"`
+	AvgServers  float64    `json:"avgServers"`
+	PeakUsersAt *time.Time `json:"peakUsersAt,omitempty"`
+}
+
+type reorderRequest struct {
+	IDs []uint `json:"ids"`
+}